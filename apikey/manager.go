@@ -0,0 +1,106 @@
+package apikey
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/ortymid/t2-http/logging"
+	"github.com/ortymid/t2-http/market"
+)
+
+// Interface may be used by protocol layers for RPC or mocking.
+type Interface interface {
+	Keys(ctx context.Context, userID string) ([]*Key, error)
+	// AddKey returns the token of the new key. It is not shown anywhere else.
+	AddKey(ctx context.Context, k *Key, userID string) (*Key, string, error)
+	DeleteKey(ctx context.Context, id int, userID string) error
+	// Authenticate finds the key of the token.
+	Authenticate(ctx context.Context, token string) (*Key, error)
+}
+
+// lastUsedPrecision limits how often the last use of a key is written.
+const lastUsedPrecision = time.Minute
+
+// Manager lets users manage their own API keys.
+type Manager struct {
+	Store Store
+}
+
+// Keys returns all keys of the user.
+func (m *Manager) Keys(ctx context.Context, userID string) ([]*Key, error) {
+	keys, err := m.Store.Keys(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("api keys: %w", err)
+	}
+	return keys, nil
+}
+
+// AddKey creates a key for the user.
+func (m *Manager) AddKey(ctx context.Context, k *Key, userID string) (*Key, string, error) {
+	if err := validateScopes(k.Scopes); err != nil {
+		return nil, "", fmt.Errorf("add api key: %w", err)
+	}
+
+	token, prefix := newToken()
+	k.UserID = userID
+	k.Prefix = prefix
+	k.Hash = hashToken(token)
+	k.CreatedAt = time.Now().UTC()
+
+	k, err := m.Store.AddKey(ctx, k)
+	if err != nil {
+		return nil, "", fmt.Errorf("add api key: %w", err)
+	}
+	return k, token, nil
+}
+
+// DeleteKey revokes the user's key.
+func (m *Manager) DeleteKey(ctx context.Context, id int, userID string) error {
+	k, err := m.Store.Key(ctx, id)
+	if err != nil {
+		return fmt.Errorf("delete api key: %w", err)
+	}
+	if k.UserID != userID {
+		err := &market.ErrPermission{Reason: errors.New("api key belongs to another user")}
+		return fmt.Errorf("delete api key: %w", err)
+	}
+
+	if err := m.Store.DeleteKey(ctx, id); err != nil {
+		return fmt.Errorf("delete api key: %w", err)
+	}
+	return nil
+}
+
+// Authenticate finds the key of the token and records its use.
+// Any mismatch is reported as ErrUnauthorized. A failure to record
+// the use is only logged, it does not deny the valid key.
+func (m *Manager) Authenticate(ctx context.Context, token string) (*Key, error) {
+	prefix, ok := parseToken(token)
+	if !ok {
+		return nil, fmt.Errorf("authenticate: %w", ErrUnauthorized)
+	}
+
+	k, err := m.Store.KeyByPrefix(ctx, prefix)
+	if errors.Is(err, ErrKeyNotFound) {
+		return nil, fmt.Errorf("authenticate: %w", ErrUnauthorized)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("authenticate: %w", err)
+	}
+
+	if subtle.ConstantTimeCompare(k.Hash, hashToken(token)) != 1 {
+		return nil, fmt.Errorf("authenticate: %w", ErrUnauthorized)
+	}
+
+	now := time.Now().UTC()
+	if now.Sub(k.LastUsedAt) >= lastUsedPrecision {
+		if err := m.Store.MarkUsed(ctx, k.ID, now); err != nil {
+			err = fmt.Errorf("authenticate: marking used: %w", err)
+			logging.FromContext(ctx).Error("api key use not recorded", "api_key_id", k.ID, "error", err)
+		}
+	}
+	return k, nil
+}
@@ -0,0 +1,95 @@
+package apikey_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/ortymid/t2-http/apikey"
+	"github.com/ortymid/t2-http/market"
+	"github.com/ortymid/t2-http/service/mem"
+)
+
+// failingStore fails to record the use of the keys.
+type failingStore struct {
+	*mem.APIKeyStore
+}
+
+func (s failingStore) MarkUsed(ctx context.Context, id int, at time.Time) error {
+	return errors.New("disk full")
+}
+
+func TestManager_AuthenticateMarkUsedFailed(t *testing.T) {
+	ctx := context.Background()
+	m := &apikey.Manager{Store: failingStore{mem.NewAPIKeyStore()}}
+
+	_, token, err := m.AddKey(ctx, &apikey.Key{Name: "batch", Scopes: []string{apikey.ScopeProductsWrite}}, "1")
+	if err != nil {
+		t.Fatalf("AddKey() unexpected error: %v", err)
+	}
+	got, err := m.Authenticate(ctx, token)
+	if err != nil {
+		t.Fatalf("Authenticate() unexpected error: %v", err)
+	}
+	if got.UserID != "1" {
+		t.Errorf("Authenticate() = %+v, want the key of user 1", got)
+	}
+}
+
+func TestManager(t *testing.T) {
+	ctx := context.Background()
+	m := &apikey.Manager{Store: mem.NewAPIKeyStore()}
+
+	k, token, err := m.AddKey(ctx, &apikey.Key{Name: "batch", Scopes: []string{apikey.ScopeProductsWrite}}, "1")
+	if err != nil {
+		t.Fatalf("AddKey() unexpected error: %v", err)
+	}
+	if string(k.Hash) == token {
+		t.Errorf("AddKey() stored the token in plain text")
+	}
+
+	t.Run("Should authenticate the token", func(t *testing.T) {
+		got, err := m.Authenticate(ctx, token)
+		if err != nil {
+			t.Fatalf("Authenticate() unexpected error: %v", err)
+		}
+		if got.UserID != "1" || !got.HasScope(apikey.ScopeProductsWrite) {
+			t.Errorf("Authenticate() = %+v, want the key of user 1", got)
+		}
+		if got, _ := m.Keys(ctx, "1"); got[0].LastUsedAt.IsZero() {
+			t.Errorf("LastUsedAt is not recorded")
+		}
+	})
+
+	t.Run("Should reject a forged token", func(t *testing.T) {
+		_, err := m.Authenticate(ctx, token+"x")
+		if !errors.Is(err, apikey.ErrUnauthorized) {
+			t.Errorf("Authenticate() error = %v, want %v", err, apikey.ErrUnauthorized)
+		}
+	})
+
+	t.Run("Should reject unknown scopes", func(t *testing.T) {
+		_, _, err := m.AddKey(ctx, &apikey.Key{Scopes: []string{"admin"}}, "1")
+		if !errors.Is(err, apikey.ErrInvalidKey) {
+			t.Errorf("AddKey() error = %v, want %v", err, apikey.ErrInvalidKey)
+		}
+	})
+
+	t.Run("Should not let others revoke the key", func(t *testing.T) {
+		err := m.DeleteKey(ctx, k.ID, "2")
+		if !errors.Is(err, &market.ErrPermission{}) {
+			t.Errorf("DeleteKey() error = %v, want %v", err, &market.ErrPermission{})
+		}
+	})
+
+	t.Run("Should reject a revoked key", func(t *testing.T) {
+		if err := m.DeleteKey(ctx, k.ID, "1"); err != nil {
+			t.Fatalf("DeleteKey() unexpected error: %v", err)
+		}
+		_, err := m.Authenticate(ctx, token)
+		if !errors.Is(err, apikey.ErrUnauthorized) {
+			t.Errorf("Authenticate() error = %v, want %v", err, apikey.ErrUnauthorized)
+		}
+	})
+}
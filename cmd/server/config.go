@@ -0,0 +1,88 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/ortymid/t2-http/config"
+	"github.com/ortymid/t2-http/jwt"
+	"github.com/ortymid/t2-http/logging"
+)
+
+// configCommand runs "config print [flags]", printing the effective config
+// with the secrets redacted, followed by its problems if any.
+// It returns the exit code.
+func configCommand(args []string) int {
+	if len(args) == 0 || args[0] != "print" {
+		fmt.Fprintf(os.Stderr, "usage: %s config print [flags]\n", os.Args[0])
+		return 2
+	}
+
+	conf, err := config.Load(os.Args[0]+" config print", args[1:], os.LookupEnv)
+	if errors.Is(err, flag.ErrHelp) {
+		usage()
+		return 0
+	}
+	var verr *config.ValidationError
+	if err != nil && !errors.As(err, &verr) {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	if werr := conf.WriteYAML(os.Stdout); werr != nil {
+		fmt.Fprintln(os.Stderr, werr)
+		return 1
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	return 0
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: %s [flags]\n       %s config print [flags]\n\nflags:\n", os.Args[0], os.Args[0])
+	config.Usage(os.Stderr)
+}
+
+// fatal logs the error stopping the server and exits.
+func fatal(err error) {
+	logging.Default().Error("server failed", "error", err)
+	os.Exit(1)
+}
+
+// reloadOnHangup loads the config again on every SIGHUP and applies
+// the settings that may change without a restart. The other changes
+// are reported and ignored.
+func reloadOnHangup(conf *config.Config, logger *logging.Logger, verifier *jwt.Verifier) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+
+	for range hup {
+		next, err := config.Load(os.Args[0], os.Args[1:], os.LookupEnv)
+		if err != nil {
+			logger.Error("config reload failed", "error", err)
+			continue
+		}
+
+		var applied []string
+		for _, change := range conf.Diff(next) {
+			if !change.Reloadable {
+				logger.Warn("config change needs a restart", "setting", change.Setting)
+				continue
+			}
+			applied = append(applied, change.Setting)
+		}
+
+		logger.SetLevel(next.LogLevel())
+		verifier.SetTimeLimits(next.JWT.Leeway, next.JWT.MaxAge)
+		conf.Log = next.Log
+		conf.JWT.Leeway = next.JWT.Leeway
+		conf.JWT.MaxAge = next.JWT.MaxAge
+		logger.Info("config reloaded", "applied", applied)
+	}
+}
@@ -1,62 +1,343 @@
 package main
 
 import (
+	"context"
 	"crypto/rsa"
 	"encoding/json"
 	"errors"
+	"flag"
 	"fmt"
+	"io/ioutil"
 	"net/http"
 	"os"
-	"strconv"
+	"sort"
+	"strings"
+	"time"
 
+	"github.com/ortymid/t2-http/apikey"
+	"github.com/ortymid/t2-http/config"
+	"github.com/ortymid/t2-http/event"
+	"github.com/ortymid/t2-http/health"
 	httpserver "github.com/ortymid/t2-http/http"
+	"github.com/ortymid/t2-http/jwt"
+	"github.com/ortymid/t2-http/logging"
 	"github.com/ortymid/t2-http/market"
+	"github.com/ortymid/t2-http/metrics"
+	"github.com/ortymid/t2-http/ratelimit"
+	"github.com/ortymid/t2-http/service/cache"
+	"github.com/ortymid/t2-http/service/file"
 	httpservice "github.com/ortymid/t2-http/service/http"
 	"github.com/ortymid/t2-http/service/mem"
+	metricsservice "github.com/ortymid/t2-http/service/metrics"
+	traceservice "github.com/ortymid/t2-http/service/trace"
+	"github.com/ortymid/t2-http/trace"
+	"github.com/ortymid/t2-http/user"
+	"github.com/ortymid/t2-http/webhook"
 )
 
-type Config struct {
-	Port           int
-	JWTAlg         string
-	JWTSecret      interface{}
-	UserServiceURL string
-}
-
 func main() {
-	config := getConfig()
+	if len(os.Args) > 1 && os.Args[1] == "config" {
+		os.Exit(configCommand(os.Args[2:]))
+	}
+
+	conf, err := config.Load(os.Args[0], os.Args[1:], os.LookupEnv)
+	if errors.Is(err, flag.ErrHelp) {
+		usage()
+		return
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	logger := logging.New(os.Stdout, conf.LogLevel())
+	logging.SetDefault(logger)
+
+	jwtKeys, err := getJWTKeys(conf.JWT)
+	if err != nil {
+		fatal(fmt.Errorf("cannot get JWT keys: %w", err))
+	}
+
+	tracer := trace.NewTracer(nil)
+	if conf.Trace.Exporter == "stdout" {
+		tracer = trace.NewTracer(trace.NewWriterExporter(os.Stdout))
+	}
+	trace.SetDefault(tracer)
+
+	registry := metrics.NewRegistry()
+	registry.RegisterRuntime()
+
+	verifier, err := jwt.NewVerifier(jwtKeys)
+	if err != nil {
+		fatal(err)
+	}
+	verifier.Issuer = conf.JWT.Issuer
+	verifier.Audience = conf.JWT.Audience
+	verifier.Leeway = conf.JWT.Leeway
+	verifier.MaxAge = conf.JWT.MaxAge
+
+	go reloadOnHangup(conf, logger, verifier)
+
+	checker := health.NewChecker(2 * time.Second)
+	if check := jwtKeyCheck(conf.JWT, jwtKeys); check != nil {
+		checker.Register("jwt_keys", check)
+	}
+
+	// Without the external user service the market manages the users itself.
+	var userService market.UserService
+	var users *user.Service
+	if conf.Users.ServiceURL != "" {
+		client := httpservice.NewUserService(conf.Users.ServiceURL)
+		checker.Register("users", client.Check)
+		remote := metricsservice.NewUserService(client, registry)
+		cached := cache.NewUserService(remote, 10000, time.Minute, 10*time.Second)
+		registerCacheMetrics(registry, cached)
+		userService = cached
+	} else {
+		accountStore, err := file.OpenAccountStore(conf.Users.AccountsFile)
+		if err != nil {
+			fatal(err)
+		}
+		issuer := &jwt.Issuer{
+			Alg:  conf.JWT.Alg,
+			Key:  []byte(conf.JWT.Secret),
+			TTL:  conf.JWT.TTL,
+			Name: conf.JWT.Issuer,
+		}
+		if conf.JWT.Audience != "" {
+			issuer.Audience = []string{conf.JWT.Audience}
+		}
+		checker.Register("users", accountStore.Check)
+		users = &user.Service{
+			Store:  accountStore,
+			Issuer: issuer,
+		}
+		userService = metricsservice.NewUserService(users, registry)
+	}
+
+	apiKeyStore, err := file.OpenAPIKeyStore(conf.Users.APIKeysFile)
+	if err != nil {
+		fatal(err)
+	}
+	checker.Register("api_keys", apiKeyStore.Check)
 
-	userService := httpservice.NewUserService(config.UserServiceURL)
 	productService := mem.NewProductService()
+	registerProductGauges(registry, productService)
+	checker.Register("products", func(ctx context.Context) error {
+		_, err := productService.Products(ctx)
+		return err
+	})
+	bus := event.NewBus()
+
+	// The product service records the events, the relay publishes them.
+	productService.EnableOutbox()
+	relay := event.NewRelay(productService, bus)
+	relay.Start()
 
 	m := &market.Market{
-		UserService:    userService,
-		ProductService: productService,
+		UserService:    traceservice.NewUserService(userService),
+		ProductService: traceservice.NewProductService(productService),
+		Logger:         logger,
 	}
 
-	httpserver.Run(config.Port, config.JWTAlg, config.JWTSecret, m)
-}
+	webhookStore := mem.NewWebhookStore()
+	dispatcher := webhook.NewDispatcher(webhookStore)
+	bus.SubscribeAsync(event.Deduplicate(dispatcher.Handle, 1000), 100)
 
-func getConfig() *Config {
-	portString := getEnvDefault("PORT", "8080")
-	port, err := strconv.Atoi(portString)
-	if err != nil {
-		panic("cannot read PORT: " + err.Error())
+	stream := httpserver.NewProductStream(256, 16)
+	bus.Subscribe(stream.Handle)
+	socket := httpserver.NewProductSocket(16)
+	bus.Subscribe(socket.Handle)
+
+	routerConfig := httpserver.RouterConfig{
+		Market:   m,
+		JWT:      verifier,
+		APIKeys:  &apikey.Manager{Store: apiKeyStore},
+		Webhooks: &webhook.Manager{Store: webhookStore},
+		Stream:   stream,
+		Socket:   socket,
+		Logger:   logger,
+		Metrics:  registry,
+		Tracer:   tracer,
+		Health:   checker,
+		RateLimit: &httpserver.RateLimiter{
+			Store:          mem.NewRateLimitStore(),
+			Read:           ratelimit.Limit{Requests: conf.RateLimit.Read, Period: conf.RateLimit.Period},
+			Write:          ratelimit.Limit{Requests: conf.RateLimit.Write, Period: conf.RateLimit.Period},
+			TrustedProxies: conf.RateLimit.TrustedNetworks(),
+		},
 	}
+	if users != nil {
+		routerConfig.Users = users
+	}
+	if conf.Idempotency.TTL > 0 {
+		routerConfig.Idempotency = &httpserver.Idempotency{
+			Store: mem.NewIdempotencyStore(),
+			TTL:   conf.Idempotency.TTL,
+		}
+	}
+	router := httpserver.NewRouter(routerConfig)
 
-	jwtAlg := getEnvDefault("JWT_ALG", "HS256")
-	jwtSecret, err := getKey(os.Getenv("KEY_SERVICE_URL"))
+	err = httpserver.Run(context.Background(), serverConfig(conf.Server), router, checker.Shutdown, stream.Close, socket.Close)
+	relay.Close()
+	bus.Close()
+	dispatcher.Close()
 	if err != nil {
-		panic(fmt.Errorf("cannot get JWT secret: %w", err))
+		fatal(err)
+	}
+}
+
+func serverConfig(c config.ServerConfig) httpserver.ServerConfig {
+	sc := httpserver.ServerConfig{
+		Addr:              fmt.Sprintf(":%d", c.Port),
+		ReadHeaderTimeout: c.ReadHeaderTimeout,
+		ReadTimeout:       c.ReadTimeout,
+		WriteTimeout:      c.WriteTimeout,
+		IdleTimeout:       c.IdleTimeout,
+		MaxHeaderBytes:    c.MaxHeaderBytes,
+		ShutdownTimeout:   c.ShutdownTimeout,
+		DisableHTTP2:      !c.HTTP2,
+	}
+	if c.TLS.Enabled() {
+		sc.TLS = &httpserver.TLSConfig{
+			CertFile:     c.TLS.CertFile,
+			KeyFile:      c.TLS.KeyFile,
+			ClientCAFile: c.TLS.ClientCAFile,
+			ClientAuth:   c.TLS.ClientAuth,
+		}
+	}
+	return sc
+}
+
+// registerProductGauges reports the number of products and sellers.
+func registerProductGauges(reg *metrics.Registry, ps market.ProductService) {
+	products := func() []*market.Product {
+		products, err := ps.Products(context.Background())
+		if err != nil {
+			logging.Default().Error("counting products failed", "error", err)
+		}
+		return products
+	}
+
+	reg.NewGaugeFunc("market_products", "Number of products on the market.", func() float64 {
+		return float64(len(products()))
+	})
+	reg.NewGaugeFunc("market_sellers", "Number of users selling at least one product.", func() float64 {
+		sellers := make(map[string]bool)
+		for _, p := range products() {
+			sellers[p.Seller] = true
+		}
+		return float64(len(sellers))
+	})
+}
+
+// registerCacheMetrics reports the counters of the user cache.
+func registerCacheMetrics(reg *metrics.Registry, c *cache.UserService) {
+	counter := func(name, help string, value func(s cache.Stats) uint64) {
+		reg.NewCounterFunc(name, help, func() float64 {
+			return float64(value(c.Stats()))
+		})
+	}
+	counter("user_cache_hits_total", "Found users served from the user cache.", func(s cache.Stats) uint64 { return s.Hits })
+	counter("user_cache_negative_hits_total", "Not found users served from the user cache.", func(s cache.Stats) uint64 { return s.NegativeHits })
+	counter("user_cache_misses_total", "User lookups passed to the user service.", func(s cache.Stats) uint64 { return s.Misses })
+	counter("user_cache_shared_total", "User lookups that waited for an identical one in flight.", func(s cache.Stats) uint64 { return s.Shared })
+	counter("user_cache_evictions_total", "Users evicted from the full user cache.", func(s cache.Stats) uint64 { return s.Evictions })
+	reg.NewGaugeFunc("user_cache_size", "Number of entries in the user cache.", func() float64 {
+		return float64(c.Stats().Size)
+	})
+}
+
+// jwtKeyCheck checks the sources of the public keys are still available,
+// if there are public keys. The shared secret needs no check.
+func jwtKeyCheck(c config.JWTConfig, keys map[string]interface{}) health.Check {
+	files := make(map[string]bool)
+	keyService := false
+	for alg, key := range keys {
+		if _, ok := key.([]byte); ok {
+			continue
+		}
+		if path := c.PublicKeyFileOf(alg); path == "" {
+			keyService = true
+		} else {
+			files[path] = true
+		}
+	}
+	if len(files) == 0 && !keyService {
+		return nil
+	}
+	paths := make([]string, 0, len(files))
+	for path := range files {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	url := c.KeyServiceURL
+	return func(ctx context.Context) error {
+		for _, path := range paths {
+			if _, err := os.Stat(path); err != nil {
+				return err
+			}
+		}
+		if !keyService {
+			return nil
+		}
+
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+		if err != nil {
+			return err
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return fmt.Errorf("key service: unexpected status %d", resp.StatusCode)
+		}
+		return nil
 	}
+}
+
+// getJWTKeys finds the keys of the accepted algorithms. HS* algorithms use
+// the secret, the others use the public key from the PEM file of the algorithm
+// or, if there is none, the RSA key from the key service.
+func getJWTKeys(c config.JWTConfig) (map[string]interface{}, error) {
+	// The algorithms sharing a file or the key service share the key.
+	public := make(map[string]interface{})
+	algs := c.Algorithms()
+	keys := make(map[string]interface{}, len(algs))
+	for _, alg := range algs {
+		if strings.HasPrefix(alg, "HS") {
+			keys[alg] = []byte(c.Secret)
+			continue
+		}
 
-	usURL := os.Getenv("USER_SERVICE_URL")
+		path := c.PublicKeyFileOf(alg)
+		key, ok := public[path]
+		if !ok {
+			var err error
+			key, err = getPublicKey(c, path)
+			if err != nil {
+				return nil, fmt.Errorf("%s key: %w", alg, err)
+			}
+			public[path] = key
+		}
+		keys[alg] = key
+	}
+	return keys, nil
+}
 
-	return &Config{
-		Port:           port,
-		JWTAlg:         jwtAlg,
-		JWTSecret:      jwtSecret,
-		UserServiceURL: usURL,
+// getPublicKey reads the PEM file or, if path is empty, asks the key service.
+func getPublicKey(c config.JWTConfig, path string) (interface{}, error) {
+	if path != "" {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		return jwt.ParsePublicKeyPEM(data)
 	}
+	return getKey(c.KeyServiceURL)
 }
 
 func getKey(url string) (*rsa.PublicKey, error) {
@@ -65,7 +346,7 @@ func getKey(url string) (*rsa.PublicKey, error) {
 		return nil, err
 	}
 	if resp.StatusCode != http.StatusOK {
-		return nil, errors.New("something went wrong")
+		return nil, fmt.Errorf("key service: unexpected status %d", resp.StatusCode)
 	}
 
 	key := &rsa.PublicKey{}
@@ -74,11 +355,3 @@ func getKey(url string) (*rsa.PublicKey, error) {
 
 	return key, err
 }
-
-func getEnvDefault(key string, d string) string {
-	val, ok := os.LookupEnv(key)
-	if !ok {
-		val = d
-	}
-	return val
-}
@@ -0,0 +1,357 @@
+// Package config loads the server settings from a YAML or JSON file,
+// the environment and the command line flags, in the increasing precedence.
+//
+// Every setting is described by the struct tags of its field:
+// yaml is its name in the file, env is its environment variable,
+// the flag is named after the path, e.g. -jwt-max-age for jwt.max_age,
+// unless the flag tag names it.
+// The settings tagged with secret are redacted when printed,
+// the ones tagged with reload may be changed without a restart.
+package config
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ortymid/t2-http/logging"
+)
+
+type Config struct {
+	Server      ServerConfig      `yaml:"server"`
+	RateLimit   RateLimitConfig   `yaml:"rate_limit"`
+	Idempotency IdempotencyConfig `yaml:"idempotency"`
+	Log         LogConfig         `yaml:"log"`
+	Trace       TraceConfig       `yaml:"trace"`
+	JWT         JWTConfig         `yaml:"jwt"`
+	Users       UsersConfig       `yaml:"users"`
+}
+
+type ServerConfig struct {
+	Port              int           `yaml:"port" env:"PORT" flag:"port" usage:"port to listen on"`
+	ReadHeaderTimeout time.Duration `yaml:"read_header_timeout" env:"SERVER_READ_HEADER_TIMEOUT" usage:"limit of reading the request headers"`
+	ReadTimeout       time.Duration `yaml:"read_timeout" env:"SERVER_READ_TIMEOUT" usage:"limit of reading the whole request"`
+	WriteTimeout      time.Duration `yaml:"write_timeout" env:"SERVER_WRITE_TIMEOUT" usage:"limit of writing the whole response, cuts the product stream"`
+	IdleTimeout       time.Duration `yaml:"idle_timeout" env:"SERVER_IDLE_TIMEOUT" usage:"limit of keeping an idle connection"`
+	MaxHeaderBytes    int           `yaml:"max_header_bytes" env:"SERVER_MAX_HEADER_BYTES" usage:"limit of the request headers size"`
+	ShutdownTimeout   time.Duration `yaml:"shutdown_timeout" env:"SERVER_SHUTDOWN_TIMEOUT" usage:"limit of waiting for the requests on shutdown"`
+	HTTP2             bool          `yaml:"http2" env:"SERVER_HTTP2" usage:"whether HTTPS speaks HTTP/2"`
+	TLS               TLSConfig     `yaml:"tls"`
+}
+
+// TLSConfig enables HTTPS when CertFile and KeyFile are set.
+type TLSConfig struct {
+	CertFile     string `yaml:"cert_file" env:"TLS_CERT_FILE" usage:"PEM certificate, reloaded when changed"`
+	KeyFile      string `yaml:"key_file" env:"TLS_KEY_FILE" usage:"PEM private key, reloaded when changed"`
+	ClientCAFile string `yaml:"client_ca_file" env:"TLS_CLIENT_CA_FILE" usage:"PEM CAs of the client certificates, enables mTLS"`
+	ClientAuth   string `yaml:"client_auth" env:"TLS_CLIENT_AUTH" usage:"client certificate policy: require or verify_if_given"`
+}
+
+// Enabled reports whether the server uses HTTPS.
+func (c *TLSConfig) Enabled() bool {
+	return c.CertFile != "" || c.KeyFile != ""
+}
+
+// RateLimitConfig limits the requests of every user or anonymous client IP.
+type RateLimitConfig struct {
+	Read   int           `yaml:"read" env:"RATE_LIMIT_READ" usage:"reading requests of a client IP or a user per period, 0 for no limit"`
+	Write  int           `yaml:"write" env:"RATE_LIMIT_WRITE" usage:"writing requests of a client IP or a user per period, 0 for no limit"`
+	Period time.Duration `yaml:"period" env:"RATE_LIMIT_PERIOD" usage:"period of the rate limits"`
+	// TrustedProxies are IPs or CIDRs.
+	TrustedProxies []string `yaml:"trusted_proxies" env:"TRUSTED_PROXIES" usage:"proxies whose X-Forwarded-For is trusted, comma-separated IPs or CIDRs"`
+}
+
+// TrustedNetworks returns the parsed trusted proxies. The config must be valid.
+func (c *RateLimitConfig) TrustedNetworks() []*net.IPNet {
+	networks := make([]*net.IPNet, 0, len(c.TrustedProxies))
+	for _, p := range c.TrustedProxies {
+		n, _ := parseNetwork(p)
+		networks = append(networks, n)
+	}
+	return networks
+}
+
+// parseNetwork parses a CIDR or a single IP.
+func parseNetwork(s string) (*net.IPNet, error) {
+	if !strings.Contains(s, "/") {
+		ip := net.ParseIP(s)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid IP %q", s)
+		}
+		bits := 8 * net.IPv6len
+		if ip4 := ip.To4(); ip4 != nil {
+			ip, bits = ip4, 8*net.IPv4len
+		}
+		return &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}, nil
+	}
+	_, n, err := net.ParseCIDR(s)
+	return n, err
+}
+
+type IdempotencyConfig struct {
+	TTL time.Duration `yaml:"ttl" env:"IDEMPOTENCY_TTL" usage:"how long the idempotency keys are remembered, 0 to ignore them"`
+}
+
+type LogConfig struct {
+	Level string `yaml:"level" env:"LOG_LEVEL" reload:"true" usage:"lowest log level: debug, info, warn or error"`
+}
+
+type TraceConfig struct {
+	Exporter string `yaml:"exporter" env:"TRACE_EXPORTER" usage:"where the spans go: stdout or none if empty"`
+}
+
+type JWTConfig struct {
+	// Algs are the accepted token algorithms, Alg if empty.
+	Algs           []string          `yaml:"algs" env:"JWT_ALGS" usage:"accepted token algorithms, comma-separated"`
+	Alg            string            `yaml:"alg" env:"JWT_ALG" usage:"algorithm of the locally issued tokens"`
+	Secret         string            `yaml:"secret" env:"JWT_SECRET" secret:"true" usage:"HS* token secret"`
+	PublicKeyFile  string            `yaml:"public_key_file" env:"JWT_PUBLIC_KEY_FILE" usage:"PEM public key of the other algorithms"`
+	PublicKeyFiles map[string]string `yaml:"public_key_files" env:"JWT_PUBLIC_KEY_FILES" usage:"PEM public keys by algorithm overriding public_key_file, e.g. RS256=rsa.pem,ES256=ec.pem"`
+	KeyServiceURL  string            `yaml:"key_service_url" env:"KEY_SERVICE_URL" usage:"RSA public key service, used without the public key files"`
+	Issuer         string            `yaml:"issuer" env:"JWT_ISSUER" usage:"required iss claim, must be set with the public key algorithms"`
+	Audience       string            `yaml:"audience" env:"JWT_AUDIENCE" usage:"required aud claim, must be set with the public key algorithms"`
+	Leeway         time.Duration     `yaml:"leeway" env:"JWT_LEEWAY" reload:"true" usage:"tolerated clock skew"`
+	MaxAge         time.Duration     `yaml:"max_age" env:"JWT_MAX_AGE" reload:"true" usage:"maximum token age since its issue, if set"`
+	TTL            time.Duration     `yaml:"ttl" env:"JWT_TTL" usage:"lifetime of the locally issued tokens"`
+}
+
+type UsersConfig struct {
+	// ServiceURL is the external user service. Without it the users are
+	// managed locally.
+	ServiceURL   string `yaml:"service_url" env:"USER_SERVICE_URL" usage:"external user service, the users are managed locally without it"`
+	AccountsFile string `yaml:"accounts_file" env:"ACCOUNTS_FILE" usage:"local accounts file"`
+	APIKeysFile  string `yaml:"api_keys_file" env:"API_KEYS_FILE" usage:"API keys file"`
+}
+
+// Default returns the settings used where nothing else is given.
+func Default() *Config {
+	return &Config{
+		Server: ServerConfig{
+			Port:              8080,
+			ReadHeaderTimeout: 5 * time.Second,
+			ReadTimeout:       30 * time.Second,
+			IdleTimeout:       2 * time.Minute,
+			MaxHeaderBytes:    64 << 10,
+			ShutdownTimeout:   30 * time.Second,
+			HTTP2:             true,
+			TLS:               TLSConfig{ClientAuth: "require"},
+		},
+		RateLimit: RateLimitConfig{
+			Read:   600,
+			Write:  60,
+			Period: time.Minute,
+		},
+		Idempotency: IdempotencyConfig{TTL: 24 * time.Hour},
+		Log:         LogConfig{Level: "info"},
+		JWT: JWTConfig{
+			Alg:    "HS256",
+			Leeway: 30 * time.Second,
+			TTL:    24 * time.Hour,
+		},
+		Users: UsersConfig{AccountsFile: "accounts.json", APIKeysFile: "api_keys.json"},
+	}
+}
+
+// Algorithms returns the accepted token algorithms.
+func (c *JWTConfig) Algorithms() []string {
+	if len(c.Algs) == 0 {
+		return []string{c.Alg}
+	}
+	return c.Algs
+}
+
+// PublicKeyFileOf returns the public key file of the algorithm,
+// empty if the key comes from the key service.
+func (c *JWTConfig) PublicKeyFileOf(alg string) string {
+	if path, ok := c.PublicKeyFiles[alg]; ok {
+		return path
+	}
+	return c.PublicKeyFile
+}
+
+// LogLevel returns the parsed log level. The config must be valid.
+func (c *Config) LogLevel() logging.Level {
+	level, _ := logging.ParseLevel(c.Log.Level)
+	return level
+}
+
+// ValidationError lists every problem of the config.
+type ValidationError struct {
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	return "invalid config:\n  " + strings.Join(e.Problems, "\n  ")
+}
+
+// Validate checks the settings make sense together.
+// The error is a *ValidationError.
+func (c *Config) Validate() error {
+	var problems []string
+	add := func(setting, format string, args ...interface{}) {
+		problems = append(problems, setting+": "+fmt.Sprintf(format, args...))
+	}
+
+	if c.Server.Port < 1 || c.Server.Port > 65535 {
+		add("server.port", "must be between 1 and 65535, got %d", c.Server.Port)
+	}
+	for _, d := range []struct {
+		setting string
+		value   time.Duration
+	}{
+		{"server.read_header_timeout", c.Server.ReadHeaderTimeout},
+		{"server.read_timeout", c.Server.ReadTimeout},
+		{"server.write_timeout", c.Server.WriteTimeout},
+		{"server.idle_timeout", c.Server.IdleTimeout},
+		{"server.shutdown_timeout", c.Server.ShutdownTimeout},
+	} {
+		if d.value < 0 {
+			add(d.setting, "must not be negative")
+		}
+	}
+	if c.Server.MaxHeaderBytes < 0 {
+		add("server.max_header_bytes", "must not be negative")
+	}
+	tls := c.Server.TLS
+	if tls.Enabled() && (tls.CertFile == "" || tls.KeyFile == "") {
+		add("server.tls", "cert_file and key_file must be set together")
+	}
+	if tls.ClientCAFile != "" && !tls.Enabled() {
+		add("server.tls.client_ca_file", "requires cert_file and key_file")
+	}
+	if tls.ClientAuth != "require" && tls.ClientAuth != "verify_if_given" {
+		add("server.tls.client_auth", "must be require or verify_if_given, got %q", tls.ClientAuth)
+	}
+	if c.RateLimit.Read < 0 {
+		add("rate_limit.read", "must not be negative")
+	}
+	if c.RateLimit.Write < 0 {
+		add("rate_limit.write", "must not be negative")
+	}
+	if (c.RateLimit.Read > 0 || c.RateLimit.Write > 0) && c.RateLimit.Period <= 0 {
+		add("rate_limit.period", "must be positive with a limit")
+	}
+	for _, p := range c.RateLimit.TrustedProxies {
+		if _, err := parseNetwork(p); err != nil {
+			add("rate_limit.trusted_proxies", "must be IPs or CIDRs, got %q", p)
+		}
+	}
+	if c.Idempotency.TTL < 0 {
+		add("idempotency.ttl", "must not be negative")
+	}
+	if _, err := logging.ParseLevel(c.Log.Level); err != nil {
+		add("log.level", "must be one of debug, info, warn and error, got %q", c.Log.Level)
+	}
+	if c.Trace.Exporter != "" && c.Trace.Exporter != "stdout" {
+		add("trace.exporter", "must be stdout or empty, got %q", c.Trace.Exporter)
+	}
+
+	shared := false
+	var public []string
+	for _, alg := range c.JWT.Algorithms() {
+		switch {
+		case !contains(algorithms, alg):
+			add("jwt.algs", "unknown algorithm %q", alg)
+		case strings.HasPrefix(alg, "HS"):
+			shared = true
+		default:
+			public = append(public, alg)
+		}
+	}
+	if shared && c.JWT.Secret == "" {
+		add("jwt.secret", "required by the HS* algorithms")
+	}
+	if len(public) > 0 && c.JWT.KeyServiceURL == "" {
+		if c.JWT.PublicKeyFile == "" && len(c.JWT.PublicKeyFiles) == 0 {
+			add("jwt.public_key_file", "either it, jwt.public_key_files or jwt.key_service_url is required by the public key algorithms")
+		} else {
+			for _, alg := range public {
+				if c.JWT.PublicKeyFileOf(alg) == "" {
+					add("jwt.public_key_files", "no key for %s without jwt.public_key_file or jwt.key_service_url", alg)
+				}
+			}
+		}
+	}
+	var keyAlgs []string
+	for alg := range c.JWT.PublicKeyFiles {
+		keyAlgs = append(keyAlgs, alg)
+	}
+	sort.Strings(keyAlgs)
+	for _, alg := range keyAlgs {
+		if !contains(public, alg) {
+			add("jwt.public_key_files", "%s is not an accepted public key algorithm", alg)
+		}
+	}
+	// The owner of a public key may sign tokens for other services too,
+	// so the tokens must name the issuer and this service.
+	if len(public) > 0 && c.JWT.Issuer == "" {
+		add("jwt.issuer", "required by the public key algorithms")
+	}
+	if len(public) > 0 && c.JWT.Audience == "" {
+		add("jwt.audience", "required by the public key algorithms")
+	}
+	if c.JWT.KeyServiceURL != "" && !validURL(c.JWT.KeyServiceURL) {
+		add("jwt.key_service_url", "must be an http or https URL, got %q", c.JWT.KeyServiceURL)
+	}
+	if c.JWT.Leeway < 0 {
+		add("jwt.leeway", "must not be negative")
+	}
+	if c.JWT.MaxAge < 0 {
+		add("jwt.max_age", "must not be negative")
+	}
+
+	if c.Users.ServiceURL != "" {
+		if !validURL(c.Users.ServiceURL) {
+			add("users.service_url", "must be an http or https URL, got %q", c.Users.ServiceURL)
+		}
+	} else {
+		// The tokens are issued locally with the shared secret.
+		if !strings.HasPrefix(c.JWT.Alg, "HS") {
+			add("jwt.alg", "must be HS256, HS384 or HS512 without users.service_url, got %q", c.JWT.Alg)
+		} else if !contains(c.JWT.Algorithms(), c.JWT.Alg) {
+			add("jwt.algs", "must include jwt.alg %s without users.service_url", c.JWT.Alg)
+		}
+		if c.JWT.Secret == "" && !shared {
+			add("jwt.secret", "required without users.service_url")
+		}
+		if c.Users.AccountsFile == "" {
+			add("users.accounts_file", "required without users.service_url")
+		}
+		if c.JWT.TTL <= 0 {
+			add("jwt.ttl", "must be positive without users.service_url")
+		}
+	}
+	if c.Users.APIKeysFile == "" {
+		add("users.api_keys_file", "required")
+	}
+
+	if len(problems) > 0 {
+		return &ValidationError{Problems: problems}
+	}
+	return nil
+}
+
+var algorithms = []string{
+	"HS256", "HS384", "HS512",
+	"RS256", "RS384", "RS512",
+	"PS256", "PS384", "PS512",
+	"ES256", "ES384", "ES512",
+	"EdDSA",
+}
+
+func validURL(s string) bool {
+	u, err := url.Parse(s)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+func contains(ss []string, s string) bool {
+	for _, v := range ss {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,360 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func envMap(m map[string]string) func(string) (string, bool) {
+	return func(key string) (string, bool) {
+		v, ok := m[key]
+		return v, ok
+	}
+}
+
+func writeFile(t *testing.T, name, content string) string {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	path := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoad(t *testing.T) {
+	yamlFile := writeFile(t, "config.yaml", `
+server:
+  port: 9000
+log:
+  level: debug
+jwt:
+  secret: from-file
+  leeway: 1m
+  max_age: 2h
+`)
+	jsonFile := writeFile(t, "config.json", `{"server": {"port": 9001}, "jwt": {"secret": "from-file"}}`)
+
+	tests := []struct {
+		name    string
+		args    []string
+		env     map[string]string
+		check   func(t *testing.T, c *Config)
+		wantErr string
+	}{
+		{
+			name: "Should use the defaults",
+			env:  map[string]string{"JWT_SECRET": "s"},
+			check: func(t *testing.T, c *Config) {
+				want := Default()
+				want.JWT.Secret = "s"
+				if !reflect.DeepEqual(c, want) {
+					t.Errorf("Load() = %+v, want %+v", c, want)
+				}
+			},
+		},
+		{
+			name: "Should read the YAML file",
+			args: []string{"-config", yamlFile},
+			check: func(t *testing.T, c *Config) {
+				if c.Server.Port != 9000 || c.Log.Level != "debug" || c.JWT.Leeway != time.Minute || c.JWT.MaxAge != 2*time.Hour {
+					t.Errorf("Load() = %+v, want the file settings", c)
+				}
+				if c.JWT.TTL != 24*time.Hour {
+					t.Errorf("JWT.TTL = %v, want the default", c.JWT.TTL)
+				}
+			},
+		},
+		{
+			name: "Should read the JSON file named by the environment",
+			env:  map[string]string{EnvFile: jsonFile},
+			check: func(t *testing.T, c *Config) {
+				if c.Server.Port != 9001 {
+					t.Errorf("Server.Port = %d, want 9001", c.Server.Port)
+				}
+			},
+		},
+		{
+			name: "Should prefer the flags to the environment to the file",
+			args: []string{"-config", yamlFile, "-log-level", "warn"},
+			env:  map[string]string{"PORT": "9100", "LOG_LEVEL": "error", "JWT_ALGS": "HS256, HS512"},
+			check: func(t *testing.T, c *Config) {
+				if c.Server.Port != 9100 {
+					t.Errorf("Server.Port = %d, want the variable", c.Server.Port)
+				}
+				if c.Log.Level != "warn" {
+					t.Errorf("Log.Level = %q, want the flag", c.Log.Level)
+				}
+				if !reflect.DeepEqual(c.JWT.Algs, []string{"HS256", "HS512"}) {
+					t.Errorf("JWT.Algs = %q, want the variable", c.JWT.Algs)
+				}
+			},
+		},
+		{
+			name: "Should read a map variable",
+			env:  map[string]string{"JWT_SECRET": "s", "JWT_ALGS": "HS256,RS256,ES256", "JWT_PUBLIC_KEY_FILES": "RS256=rsa.pem, ES256=ec.pem", "JWT_ISSUER": "users", "JWT_AUDIENCE": "market"},
+			check: func(t *testing.T, c *Config) {
+				want := map[string]string{"RS256": "rsa.pem", "ES256": "ec.pem"}
+				if !reflect.DeepEqual(c.JWT.PublicKeyFiles, want) {
+					t.Errorf("JWT.PublicKeyFiles = %q, want %q", c.JWT.PublicKeyFiles, want)
+				}
+			},
+		},
+		{
+			name:    "Should fail on a malformed map variable",
+			env:     map[string]string{"JWT_PUBLIC_KEY_FILES": "rsa.pem"},
+			wantErr: `JWT_PUBLIC_KEY_FILES: invalid item "rsa.pem", want key=value`,
+		},
+		{
+			name:    "Should fail on an unknown file setting",
+			args:    []string{"-config", writeFile(t, "bad.yaml", "server:\n  prot: 1\n")},
+			wantErr: "field prot not found",
+		},
+		{
+			name:    "Should fail on a malformed variable",
+			env:     map[string]string{"JWT_LEEWAY": "30"},
+			wantErr: `JWT_LEEWAY: invalid duration "30"`,
+		},
+		{
+			name:    "Should fail on an unknown flag",
+			args:    []string{"-prot", "1"},
+			wantErr: "flag provided but not defined: -prot",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := Load("server", tt.args, envMap(tt.env))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Load() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Load() unexpected error: %v", err)
+			}
+			tt.check(t, c)
+		})
+	}
+}
+
+func TestConfig_Validate(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(c *Config)
+		want   []string
+	}{
+		{
+			name:   "Should accept the local users with a secret",
+			change: func(c *Config) { c.JWT.Secret = "s" },
+		},
+		{
+			name: "Should accept the remote users with a public key",
+			change: func(c *Config) {
+				c.Users.ServiceURL = "http://users"
+				c.JWT.Algs = []string{"RS256"}
+				c.JWT.KeyServiceURL = "http://keys"
+				c.JWT.Issuer = "users"
+				c.JWT.Audience = "market"
+			},
+		},
+		{
+			name: "Should report every problem",
+			change: func(c *Config) {
+				c.Server.Port = 0
+				c.Log.Level = "loud"
+				c.JWT.Leeway = -time.Second
+			},
+			want: []string{
+				"server.port: must be between 1 and 65535, got 0",
+				`log.level: must be one of debug, info, warn and error, got "loud"`,
+				"jwt.secret: required by the HS* algorithms",
+				"jwt.leeway: must not be negative",
+			},
+		},
+		{
+			name: "Should require the public key source",
+			change: func(c *Config) {
+				c.Users.ServiceURL = "http://users"
+				c.JWT.Algs = []string{"RS256"}
+				c.JWT.Issuer = "users"
+				c.JWT.Audience = "market"
+			},
+			want: []string{
+				"jwt.public_key_file: either it, jwt.public_key_files or jwt.key_service_url is required by the public key algorithms",
+			},
+		},
+		{
+			name: "Should require a public key of every algorithm",
+			change: func(c *Config) {
+				c.Users.ServiceURL = "http://users"
+				c.JWT.Algs = []string{"RS256", "ES256", "EdDSA"}
+				c.JWT.PublicKeyFiles = map[string]string{"RS256": "rsa.pem", "PS256": "rsa.pem", "HS256": "s.pem"}
+				c.JWT.Issuer = "users"
+				c.JWT.Audience = "market"
+			},
+			want: []string{
+				"jwt.public_key_files: no key for ES256 without jwt.public_key_file or jwt.key_service_url",
+				"jwt.public_key_files: no key for EdDSA without jwt.public_key_file or jwt.key_service_url",
+				"jwt.public_key_files: HS256 is not an accepted public key algorithm",
+				"jwt.public_key_files: PS256 is not an accepted public key algorithm",
+			},
+		},
+		{
+			name: "Should accept a public key file for each algorithm",
+			change: func(c *Config) {
+				c.Users.ServiceURL = "http://users"
+				c.JWT.Algs = []string{"RS256", "ES256"}
+				c.JWT.PublicKeyFiles = map[string]string{"RS256": "rsa.pem", "ES256": "ec.pem"}
+				c.JWT.Issuer = "users"
+				c.JWT.Audience = "market"
+			},
+		},
+		{
+			name: "Should require the issuer and the audience with a public key",
+			change: func(c *Config) {
+				c.Users.ServiceURL = "http://users"
+				c.JWT.Algs = []string{"HS256", "RS256"}
+				c.JWT.Secret = "s"
+				c.JWT.PublicKeyFile = "rsa.pem"
+			},
+			want: []string{
+				"jwt.issuer: required by the public key algorithms",
+				"jwt.audience: required by the public key algorithms",
+			},
+		},
+		{
+			name: "Should require a shared secret algorithm for the local users",
+			change: func(c *Config) {
+				c.JWT.Alg = "RS256"
+				c.JWT.KeyServiceURL = "keys"
+				c.JWT.Issuer = "users"
+				c.JWT.Audience = "market"
+			},
+			want: []string{
+				`jwt.key_service_url: must be an http or https URL, got "keys"`,
+				`jwt.alg: must be HS256, HS384 or HS512 without users.service_url, got "RS256"`,
+				"jwt.secret: required without users.service_url",
+			},
+		},
+		{
+			name: "Should require the TLS files together",
+			change: func(c *Config) {
+				c.JWT.Secret = "s"
+				c.Server.ShutdownTimeout = -time.Second
+				c.Server.TLS.CertFile = "server.crt"
+				c.Server.TLS.ClientAuth = "maybe"
+			},
+			want: []string{
+				"server.shutdown_timeout: must not be negative",
+				"server.tls: cert_file and key_file must be set together",
+				`server.tls.client_auth: must be require or verify_if_given, got "maybe"`,
+			},
+		},
+		{
+			name: "Should check the rate limits",
+			change: func(c *Config) {
+				c.JWT.Secret = "s"
+				c.RateLimit.Write = -1
+				c.RateLimit.Period = 0
+				c.RateLimit.TrustedProxies = []string{"10.0.0.0/8", "::1", "proxy"}
+			},
+			want: []string{
+				"rate_limit.write: must not be negative",
+				"rate_limit.period: must be positive with a limit",
+				`rate_limit.trusted_proxies: must be IPs or CIDRs, got "proxy"`,
+			},
+		},
+		{
+			name: "Should require the API keys file",
+			change: func(c *Config) {
+				c.JWT.Secret = "s"
+				c.Users.APIKeysFile = ""
+			},
+			want: []string{
+				"users.api_keys_file: required",
+			},
+		},
+		{
+			name: "Should require TLS for the client CAs",
+			change: func(c *Config) {
+				c.JWT.Secret = "s"
+				c.Server.TLS.ClientCAFile = "ca.crt"
+			},
+			want: []string{
+				"server.tls.client_ca_file: requires cert_file and key_file",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := Default()
+			tt.change(c)
+			err := c.Validate()
+
+			var got []string
+			var verr *ValidationError
+			if errors.As(err, &verr) {
+				got = verr.Problems
+			} else if err != nil {
+				t.Fatalf("Validate() error = %v, want a *ValidationError", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Validate() problems =\n%q\nwant\n%q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestConfig_WriteYAML(t *testing.T) {
+	c := Default()
+	c.JWT.Secret = "top-secret"
+
+	var buf bytes.Buffer
+	if err := c.WriteYAML(&buf); err != nil {
+		t.Fatalf("WriteYAML() unexpected error: %v", err)
+	}
+	if strings.Contains(buf.String(), "top-secret") || !strings.Contains(buf.String(), "secret: "+redacted) {
+		t.Errorf("WriteYAML() = %s, want the secret redacted", buf.String())
+	}
+	if c.JWT.Secret != "top-secret" {
+		t.Errorf("WriteYAML() changed the config")
+	}
+
+	// The printed config is a valid config file.
+	printed := Default()
+	if err := decodeFile(buf.Bytes(), printed); err != nil {
+		t.Fatalf("decoding the printed config: %v", err)
+	}
+	if printed.JWT.Leeway != c.JWT.Leeway {
+		t.Errorf("printed JWT.Leeway = %v, want %v", printed.JWT.Leeway, c.JWT.Leeway)
+	}
+}
+
+func TestConfig_Diff(t *testing.T) {
+	old := Default()
+	c := Default()
+	c.Log.Level = "debug"
+	c.Server.Port = 9000
+
+	want := []Change{
+		{Setting: "server.port", Reloadable: false},
+		{Setting: "log.level", Reloadable: true},
+	}
+	if got := old.Diff(c); !reflect.DeepEqual(got, want) {
+		t.Errorf("Diff() = %+v, want %+v", got, want)
+	}
+}
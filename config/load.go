@@ -0,0 +1,195 @@
+package config
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// EnvFile names the config file when there is no -config flag.
+const EnvFile = "CONFIG_FILE"
+
+// setting is a leaf field of the config with its tags.
+type setting struct {
+	path   string // e.g. "jwt.max_age"
+	name   string // flag name, if not derived from the path
+	env    string
+	usage  string
+	secret bool
+	reload bool
+	value  reflect.Value
+}
+
+func (s setting) flag() string {
+	if s.name != "" {
+		return s.name
+	}
+	return strings.NewReplacer(".", "-", "_", "-").Replace(s.path)
+}
+
+// settings lists the leaf fields of the config in the order of declaration.
+func (c *Config) settings() []setting {
+	var ss []setting
+	var walk func(prefix string, v reflect.Value)
+	walk = func(prefix string, v reflect.Value) {
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			path := prefix + f.Tag.Get("yaml")
+			if f.Type.Kind() == reflect.Struct {
+				walk(path+".", v.Field(i))
+				continue
+			}
+			ss = append(ss, setting{
+				path:   path,
+				name:   f.Tag.Get("flag"),
+				env:    f.Tag.Get("env"),
+				usage:  f.Tag.Get("usage"),
+				secret: f.Tag.Get("secret") == "true",
+				reload: f.Tag.Get("reload") == "true",
+				value:  v.Field(i),
+			})
+		}
+	}
+	walk("", reflect.ValueOf(c).Elem())
+	return ss
+}
+
+// Load reads the config from the defaults, the file, the environment
+// and the flags, every next source overriding the previous ones.
+// The file is named by the -config flag or the CONFIG_FILE variable,
+// there may be none. lookupEnv is usually os.LookupEnv.
+// The loaded config is validated. When it is invalid, the config is returned
+// with the *ValidationError, so it still may be printed.
+func Load(name string, args []string, lookupEnv func(string) (string, bool)) (*Config, error) {
+	c := Default()
+	settings := c.settings()
+
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(ioutil.Discard)
+	file := fs.String("config", "", "YAML or JSON config file")
+	flags := make(map[string]setting, len(settings))
+	for _, s := range settings {
+		fs.String(s.flag(), "", s.usage)
+		flags[s.flag()] = s
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, fmt.Errorf("loading config: %w", err)
+	}
+	if fs.NArg() > 0 {
+		return nil, fmt.Errorf("loading config: unexpected argument %q", fs.Arg(0))
+	}
+
+	if *file == "" {
+		*file, _ = lookupEnv(EnvFile)
+	}
+	if *file != "" {
+		data, err := ioutil.ReadFile(*file)
+		if err != nil {
+			return nil, fmt.Errorf("loading config: %w", err)
+		}
+		if err := decodeFile(data, c); err != nil {
+			return nil, fmt.Errorf("loading config %s: %w", *file, err)
+		}
+	}
+
+	for _, s := range settings {
+		if s.env == "" {
+			continue
+		}
+		if val, ok := lookupEnv(s.env); ok {
+			if err := set(s.value, val); err != nil {
+				return nil, fmt.Errorf("loading config: %s: %w", s.env, err)
+			}
+		}
+	}
+
+	var err error
+	fs.Visit(func(f *flag.Flag) {
+		s, ok := flags[f.Name]
+		if !ok || err != nil {
+			return
+		}
+		if serr := set(s.value, f.Value.String()); serr != nil {
+			err = fmt.Errorf("loading config: -%s: %w", f.Name, serr)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := c.Validate(); err != nil {
+		return c, err
+	}
+	return c, nil
+}
+
+// Usage writes the flags with the matching variables.
+func Usage(w io.Writer) {
+	fmt.Fprintf(w, "  -config string\n    \tYAML or JSON config file (env %s)\n", EnvFile)
+	for _, s := range Default().settings() {
+		fmt.Fprintf(w, "  -%s %s\n    \t%s (env %s)\n", s.flag(), s.value.Type(), s.usage, s.env)
+	}
+}
+
+// decodeFile reads YAML, JSON being its subset. Unknown settings are errors.
+func decodeFile(data []byte, c *Config) error {
+	return yaml.UnmarshalStrict(data, c)
+}
+
+// set parses the string into the setting value.
+func set(v reflect.Value, s string) error {
+	switch v.Interface().(type) {
+	case string:
+		v.SetString(s)
+	case int:
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			return fmt.Errorf("invalid integer %q", s)
+		}
+		v.SetInt(int64(n))
+	case bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return fmt.Errorf("invalid boolean %q", s)
+		}
+		v.SetBool(b)
+	case time.Duration:
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return fmt.Errorf("invalid duration %q, e.g. 30s or 1h", s)
+		}
+		v.SetInt(int64(d))
+	case []string:
+		var items []string
+		for _, item := range strings.Split(s, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		v.Set(reflect.ValueOf(items))
+	case map[string]string:
+		items := make(map[string]string)
+		for _, item := range strings.Split(s, ",") {
+			if item = strings.TrimSpace(item); item == "" {
+				continue
+			}
+			i := strings.Index(item, "=")
+			if i <= 0 {
+				return fmt.Errorf("invalid item %q, want key=value", item)
+			}
+			items[strings.TrimSpace(item[:i])] = strings.TrimSpace(item[i+1:])
+		}
+		v.Set(reflect.ValueOf(items))
+	default:
+		return fmt.Errorf("unsupported type %s", v.Type())
+	}
+	return nil
+}
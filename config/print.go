@@ -0,0 +1,53 @@
+package config
+
+import (
+	"fmt"
+	"io"
+	"reflect"
+
+	"gopkg.in/yaml.v2"
+)
+
+// redacted replaces the values of the secret settings when printed.
+const redacted = "REDACTED"
+
+// Redacted returns a copy of the config with the set secrets replaced.
+func (c *Config) Redacted() *Config {
+	r := *c
+	for _, s := range r.settings() {
+		if s.secret && !s.value.IsZero() {
+			s.value.SetString(redacted)
+		}
+	}
+	return &r
+}
+
+// WriteYAML writes the config with the secrets redacted, in the format
+// of the config file.
+func (c *Config) WriteYAML(w io.Writer) error {
+	data, err := yaml.Marshal(c.Redacted())
+	if err != nil {
+		return fmt.Errorf("printing config: %w", err)
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+// Change is a setting that differs between two configs.
+type Change struct {
+	Setting string
+	// Reloadable tells whether the change may be applied without a restart.
+	Reloadable bool
+}
+
+// Diff lists the settings of the other config that differ from c.
+func (c *Config) Diff(other *Config) []Change {
+	var changes []Change
+	theirs := other.settings()
+	for i, s := range c.settings() {
+		if !reflect.DeepEqual(s.value.Interface(), theirs[i].value.Interface()) {
+			changes = append(changes, Change{Setting: s.path, Reloadable: s.reload})
+		}
+	}
+	return changes
+}
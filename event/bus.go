@@ -0,0 +1,173 @@
+// Package event provides an in-process event bus for market events.
+package event
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/ortymid/t2-http/logging"
+	"github.com/ortymid/t2-http/market"
+)
+
+// Handler reacts to a published event.
+type Handler func(e market.Event)
+
+// Subscriber is implemented by the sources of events other subsystems may hook in.
+type Subscriber interface {
+	Subscribe(h Handler) (unsubscribe func())
+	SubscribeAsync(h Handler, buffer int) (unsubscribe func())
+}
+
+type subscription struct {
+	handler Handler
+
+	// Async subscriptions only.
+	queue chan market.Event
+	quit  chan struct{}
+}
+
+// Bus dispatches published events to its subscribers.
+// It implements market.EventPublisher.
+type Bus struct {
+	mu     sync.RWMutex
+	closed bool
+	lastID int
+	subs   map[int]*subscription
+	wg     sync.WaitGroup
+}
+
+func NewBus() *Bus {
+	return &Bus{subs: make(map[int]*subscription)}
+}
+
+// Subscribe registers the handler that is called synchronously by Publish.
+// A slow handler slows down the market mutations.
+func (b *Bus) Subscribe(h Handler) (unsubscribe func()) {
+	return b.add(&subscription{handler: h})
+}
+
+// SubscribeAsync registers the handler that is called from its own goroutine.
+// Up to buffer events are queued for the handler, after that Publish blocks
+// until there is room in the queue.
+func (b *Bus) SubscribeAsync(h Handler, buffer int) (unsubscribe func()) {
+	s := &subscription{
+		handler: h,
+		queue:   make(chan market.Event, buffer),
+		quit:    make(chan struct{}),
+	}
+	unsubscribe = b.add(s)
+
+	b.wg.Add(1)
+	go func() {
+		defer b.wg.Done()
+		s.run()
+	}()
+
+	return unsubscribe
+}
+
+func (b *Bus) add(s *subscription) (unsubscribe func()) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.closed {
+		if s.quit != nil {
+			close(s.quit)
+		}
+		return func() {}
+	}
+
+	b.lastID++
+	id := b.lastID
+	b.subs[id] = s
+
+	var once sync.Once
+	return func() {
+		once.Do(func() { b.remove(id) })
+	}
+}
+
+func (b *Bus) remove(id int) {
+	b.mu.Lock()
+	s, ok := b.subs[id]
+	delete(b.subs, id)
+	b.mu.Unlock()
+
+	if ok && s.quit != nil {
+		close(s.quit)
+	}
+}
+
+// Publish delivers the event to all subscribers.
+// Events published after Close are dropped.
+func (b *Bus) Publish(e market.Event) {
+	b.mu.RLock()
+	if b.closed {
+		b.mu.RUnlock()
+		return
+	}
+	subs := make([]*subscription, 0, len(b.subs))
+	for _, s := range b.subs {
+		subs = append(subs, s)
+	}
+	b.mu.RUnlock()
+
+	for _, s := range subs {
+		if s.queue == nil {
+			s.handle(e)
+			continue
+		}
+		select {
+		case s.queue <- e:
+		case <-s.quit:
+		}
+	}
+}
+
+// Close unsubscribes everyone and waits for the asynchronous handlers
+// to process the events queued so far.
+func (b *Bus) Close() {
+	b.mu.Lock()
+	b.closed = true
+	subs := b.subs
+	b.subs = make(map[int]*subscription)
+	b.mu.Unlock()
+
+	for _, s := range subs {
+		if s.quit != nil {
+			close(s.quit)
+		}
+	}
+	b.wg.Wait()
+}
+
+// run processes the queue of an asynchronous subscription until it quits.
+// The events queued before quitting are still handled.
+func (s *subscription) run() {
+	for {
+		select {
+		case e := <-s.queue:
+			s.handle(e)
+		case <-s.quit:
+			for {
+				select {
+				case e := <-s.queue:
+					s.handle(e)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+// handle calls the handler preventing its panic from crashing the publisher.
+func (s *subscription) handle(e market.Event) {
+	defer func() {
+		if r := recover(); r != nil {
+			err := fmt.Errorf("event handler panic on %s: %v", e.EventType(), r)
+			logging.Default().Error("event handler failed", "error", err)
+		}
+	}()
+	s.handler(e)
+}
@@ -0,0 +1,84 @@
+package event
+
+import (
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/ortymid/t2-http/market"
+)
+
+func TestBus_Publish(t *testing.T) {
+	e1 := market.ProductAdded{Meta: market.EventMeta{ID: "1"}, Product: market.Product{ID: 1}}
+	e2 := market.ProductDeleted{Meta: market.EventMeta{ID: "2"}, Product: market.Product{ID: 1}}
+
+	t.Run("Should deliver events to sync subscribers", func(t *testing.T) {
+		b := NewBus()
+		defer b.Close()
+
+		var got []market.Event
+		b.Subscribe(func(e market.Event) { got = append(got, e) })
+
+		b.Publish(e1)
+		b.Publish(e2)
+
+		want := []market.Event{e1, e2}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("got events %v, want %v", got, want)
+		}
+	})
+
+	t.Run("Should deliver events to async subscribers in order", func(t *testing.T) {
+		b := NewBus()
+
+		var mu sync.Mutex
+		var got []market.Event
+		b.SubscribeAsync(func(e market.Event) {
+			mu.Lock()
+			defer mu.Unlock()
+			got = append(got, e)
+		}, 1)
+
+		b.Publish(e1)
+		b.Publish(e2)
+		b.Close()
+
+		want := []market.Event{e1, e2}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("got events %v, want %v", got, want)
+		}
+	})
+
+	t.Run("Should stop delivering after unsubscribe", func(t *testing.T) {
+		b := NewBus()
+		defer b.Close()
+
+		var got []market.Event
+		unsubscribe := b.Subscribe(func(e market.Event) { got = append(got, e) })
+
+		b.Publish(e1)
+		unsubscribe()
+		b.Publish(e2)
+
+		want := []market.Event{e1}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("got events %v, want %v", got, want)
+		}
+	})
+
+	t.Run("Should survive a panicking handler", func(t *testing.T) {
+		b := NewBus()
+		defer b.Close()
+
+		var got []market.Event
+		b.Subscribe(func(e market.Event) { panic("oops") })
+		b.Subscribe(func(e market.Event) { got = append(got, e) })
+
+		b.Publish(e1)
+
+		want := []market.Event{e1}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("got events %v, want %v", got, want)
+		}
+	})
+}
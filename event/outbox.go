@@ -0,0 +1,147 @@
+package event
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/ortymid/t2-http/logging"
+	"github.com/ortymid/t2-http/market"
+)
+
+// Outbox is a store of events written together with the data changes
+// they describe and waiting to be published.
+type Outbox interface {
+	// PendingEvents returns up to limit oldest undelivered events.
+	PendingEvents(limit int) ([]market.Event, error)
+	// MarkDelivered removes the events with the given IDs from the outbox.
+	MarkDelivered(ids ...string) error
+}
+
+// Relay moves the events from the outbox to the publisher.
+//
+// The delivery is at-least-once: an event published right before a failure
+// to mark it delivered is published again. Subscribers not tolerating
+// duplicates should be wrapped with Deduplicate.
+type Relay struct {
+	Outbox    Outbox
+	Publisher market.EventPublisher
+	Interval  time.Duration
+	BatchSize int
+
+	mu       sync.Mutex // serializes flushes
+	started  bool
+	stop     chan struct{}
+	stopOnce sync.Once
+	done     chan struct{}
+}
+
+// NewRelay creates a relay with reasonable defaults.
+func NewRelay(outbox Outbox, publisher market.EventPublisher) *Relay {
+	return &Relay{
+		Outbox:    outbox,
+		Publisher: publisher,
+		Interval:  100 * time.Millisecond,
+		BatchSize: 100,
+		stop:      make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+}
+
+// Start begins polling the outbox in the background.
+func (r *Relay) Start() {
+	r.mu.Lock()
+	r.started = true
+	r.mu.Unlock()
+
+	go func() {
+		defer close(r.done)
+
+		ticker := time.NewTicker(r.Interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				if err := r.Flush(); err != nil {
+					logging.Default().Error("outbox relay failed", "error", err)
+				}
+			case <-r.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Flush publishes all the pending events.
+func (r *Relay) Flush() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for {
+		events, err := r.Outbox.PendingEvents(r.BatchSize)
+		if err != nil {
+			return fmt.Errorf("relay: pending events: %w", err)
+		}
+		if len(events) == 0 {
+			return nil
+		}
+
+		ids := make([]string, len(events))
+		for i, e := range events {
+			r.Publisher.Publish(e)
+			ids[i] = e.Metadata().ID
+		}
+
+		if err := r.Outbox.MarkDelivered(ids...); err != nil {
+			return fmt.Errorf("relay: mark delivered: %w", err)
+		}
+	}
+}
+
+// Close stops the polling started by Start and publishes the remaining events.
+func (r *Relay) Close() {
+	r.stopOnce.Do(func() {
+		close(r.stop)
+
+		r.mu.Lock()
+		started := r.started
+		r.mu.Unlock()
+		if started {
+			<-r.done
+		}
+	})
+	if err := r.Flush(); err != nil {
+		logging.Default().Error("outbox relay failed", "error", err)
+	}
+}
+
+// Deduplicate wraps the handler to skip the events which IDs were among
+// the last size handled ones. The handler is not wrapped if size is not positive.
+func Deduplicate(h Handler, size int) Handler {
+	if size <= 0 {
+		return h
+	}
+	var mu sync.Mutex
+	seen := make(map[string]bool, size)
+	order := make([]string, 0, size)
+
+	return func(e market.Event) {
+		id := e.Metadata().ID
+
+		mu.Lock()
+		if seen[id] {
+			mu.Unlock()
+			return
+		}
+		if len(order) == size {
+			delete(seen, order[0])
+			order = order[1:]
+		}
+		seen[id] = true
+		order = append(order, id)
+		mu.Unlock()
+
+		h(e)
+	}
+}
@@ -0,0 +1,84 @@
+package event_test
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/ortymid/t2-http/event"
+	"github.com/ortymid/t2-http/market"
+	"github.com/ortymid/t2-http/service/mem"
+)
+
+type recorder struct {
+	types []string
+}
+
+func (r *recorder) handle(e market.Event) {
+	r.types = append(r.types, e.EventType())
+}
+
+func TestRelay_Flush(t *testing.T) {
+	ctx := context.Background()
+	ps := mem.NewProductService()
+	ps.EnableOutbox()
+
+	bus := event.NewBus()
+	defer bus.Close()
+	rec := &recorder{}
+	bus.Subscribe(rec.handle)
+
+	p, _ := ps.AddProduct(ctx, &market.Product{Name: "p3", Price: 300, Seller: "1"})
+	_, _ = ps.ReplaceProduct(ctx, &market.Product{ID: p.ID, Name: "p3", Price: 400, Seller: "1"})
+	_ = ps.DeleteProduct(ctx, p.ID)
+
+	relay := event.NewRelay(ps, bus)
+	relay.BatchSize = 2
+	if err := relay.Flush(); err != nil {
+		t.Fatalf("Flush() unexpected error: %v", err)
+	}
+
+	want := []string{market.EventProductAdded, market.EventProductReplaced, market.EventProductDeleted}
+	if !reflect.DeepEqual(rec.types, want) {
+		t.Errorf("published %v, want %v", rec.types, want)
+	}
+
+	pending, _ := ps.PendingEvents(10)
+	if len(pending) != 0 {
+		t.Errorf("%d events left in the outbox, want 0", len(pending))
+	}
+}
+
+func TestDeduplicate(t *testing.T) {
+	rec := &recorder{}
+	h := event.Deduplicate(rec.handle, 2)
+
+	e1 := market.ProductAdded{Meta: market.EventMeta{ID: "1"}}
+	e2 := market.ProductReplaced{Meta: market.EventMeta{ID: "2"}}
+	e3 := market.ProductDeleted{Meta: market.EventMeta{ID: "3"}}
+
+	h(e1)
+	h(e1)
+	h(e2)
+	h(e3)
+	h(e1) // forgotten after e3
+
+	want := []string{market.EventProductAdded, market.EventProductReplaced, market.EventProductDeleted, market.EventProductAdded}
+	if !reflect.DeepEqual(rec.types, want) {
+		t.Errorf("handled %v, want %v", rec.types, want)
+	}
+}
+
+func TestDeduplicate_zeroSize(t *testing.T) {
+	rec := &recorder{}
+	h := event.Deduplicate(rec.handle, 0)
+
+	e1 := market.ProductAdded{Meta: market.EventMeta{ID: "1"}}
+	h(e1)
+	h(e1)
+
+	want := []string{market.EventProductAdded, market.EventProductAdded}
+	if !reflect.DeepEqual(rec.types, want) {
+		t.Errorf("handled %v, want %v", rec.types, want)
+	}
+}
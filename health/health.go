@@ -0,0 +1,142 @@
+// Package health checks the dependencies of the service for the liveness
+// and readiness probes.
+package health
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Check reports whether a dependency is usable.
+type Check func(ctx context.Context) error
+
+// Status of the service or a component.
+type Status string
+
+const (
+	StatusOK   Status = "ok"
+	StatusFail Status = "fail"
+)
+
+// ErrShuttingDown fails the readiness once the shutdown begins.
+var ErrShuttingDown = errors.New("shutting down")
+
+// Report is the result of the checks.
+type Report struct {
+	Status     Status                     `json:"status"`
+	Components map[string]ComponentReport `json:"components,omitempty"`
+}
+
+// ComponentReport is the result of a single check.
+type ComponentReport struct {
+	Status    Status  `json:"status"`
+	Error     string  `json:"error,omitempty"`
+	LatencyMS float64 `json:"latency_ms"`
+}
+
+// Checker runs the registered checks concurrently, every one is limited by Timeout.
+type Checker struct {
+	Timeout time.Duration
+
+	mu           sync.Mutex
+	names        []string
+	checks       map[string]Check
+	shuttingDown int32
+}
+
+func NewChecker(timeout time.Duration) *Checker {
+	return &Checker{Timeout: timeout, checks: make(map[string]Check)}
+}
+
+// Register adds the check of the named component. A check with the same name
+// is replaced.
+func (c *Checker) Register(name string, check Check) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := c.checks[name]; !ok {
+		c.names = append(c.names, name)
+	}
+	c.checks[name] = check
+}
+
+// Shutdown makes the service not ready from now on.
+// It is meant to be called as soon as the server begins to shut down.
+func (c *Checker) Shutdown() {
+	atomic.StoreInt32(&c.shuttingDown, 1)
+}
+
+// Live reports whether the service itself works. It does not run the checks,
+// as a failing dependency is not fixed by restarting the service.
+func (c *Checker) Live(ctx context.Context) Report {
+	return Report{Status: StatusOK}
+}
+
+// Ready runs the checks. The service is ready if all of them pass
+// and it is not shutting down.
+func (c *Checker) Ready(ctx context.Context) Report {
+	c.mu.Lock()
+	names := make([]string, len(c.names))
+	copy(names, c.names)
+	checks := make([]Check, len(names))
+	for i, name := range names {
+		checks[i] = c.checks[name]
+	}
+	c.mu.Unlock()
+
+	results := make([]ComponentReport, len(checks))
+	var wg sync.WaitGroup
+	for i, check := range checks {
+		wg.Add(1)
+		go func(i int, check Check) {
+			defer wg.Done()
+			results[i] = c.run(ctx, check)
+		}(i, check)
+	}
+	wg.Wait()
+
+	report := Report{Status: StatusOK, Components: make(map[string]ComponentReport, len(names))}
+	for i, name := range names {
+		report.Components[name] = results[i]
+		if results[i].Status != StatusOK {
+			report.Status = StatusFail
+		}
+	}
+	if atomic.LoadInt32(&c.shuttingDown) == 1 {
+		report.Status = StatusFail
+		report.Components["server"] = ComponentReport{Status: StatusFail, Error: ErrShuttingDown.Error()}
+	}
+	return report
+}
+
+func (c *Checker) run(ctx context.Context, check Check) ComponentReport {
+	if c.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.Timeout)
+		defer cancel()
+	}
+
+	// A check ignoring the context must not hold the probe.
+	start := time.Now()
+	done := make(chan error, 1)
+	go func() { done <- check(ctx) }()
+	var err error
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	r := ComponentReport{
+		Status:    StatusOK,
+		LatencyMS: float64(time.Since(start)) / float64(time.Millisecond),
+	}
+	if err != nil {
+		r.Status = StatusFail
+		r.Error = err.Error()
+	}
+	return r
+}
@@ -0,0 +1,88 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestChecker_Ready(t *testing.T) {
+	ok := func(ctx context.Context) error { return nil }
+	down := func(ctx context.Context) error { return errors.New("down") }
+	stuck := func(ctx context.Context) error {
+		time.Sleep(time.Second)
+		return nil
+	}
+
+	tests := []struct {
+		name         string
+		checks       map[string]Check
+		shuttingDown bool
+		want         Report
+	}{
+		{
+			name:   "Should be ready when all checks pass",
+			checks: map[string]Check{"a": ok, "b": ok},
+			want: Report{Status: StatusOK, Components: map[string]ComponentReport{
+				"a": {Status: StatusOK},
+				"b": {Status: StatusOK},
+			}},
+		},
+		{
+			name:   "Should fail when a check fails",
+			checks: map[string]Check{"a": ok, "b": down},
+			want: Report{Status: StatusFail, Components: map[string]ComponentReport{
+				"a": {Status: StatusOK},
+				"b": {Status: StatusFail, Error: "down"},
+			}},
+		},
+		{
+			name:   "Should fail when a check times out",
+			checks: map[string]Check{"a": stuck},
+			want: Report{Status: StatusFail, Components: map[string]ComponentReport{
+				"a": {Status: StatusFail, Error: context.DeadlineExceeded.Error()},
+			}},
+		},
+		{
+			name:         "Should fail when shutting down",
+			checks:       map[string]Check{"a": ok},
+			shuttingDown: true,
+			want: Report{Status: StatusFail, Components: map[string]ComponentReport{
+				"a":      {Status: StatusOK},
+				"server": {Status: StatusFail, Error: ErrShuttingDown.Error()},
+			}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := NewChecker(10 * time.Millisecond)
+			for name, check := range tt.checks {
+				c.Register(name, check)
+			}
+			if tt.shuttingDown {
+				c.Shutdown()
+			}
+
+			got := c.Ready(context.Background())
+			for name, r := range got.Components {
+				r.LatencyMS = 0
+				got.Components[name] = r
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Ready() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestChecker_Live(t *testing.T) {
+	c := NewChecker(time.Second)
+	c.Register("a", func(ctx context.Context) error { return errors.New("down") })
+
+	if got := c.Live(context.Background()); got.Status != StatusOK {
+		t.Errorf("Live() = %+v, want ok regardless of the dependencies", got)
+	}
+}
@@ -0,0 +1,146 @@
+package http
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/ortymid/t2-http/apikey"
+	"github.com/ortymid/t2-http/market"
+)
+
+// APIKeyHandler forwards API key requests to the API key manager.
+// All the requests require authorization with a JWT: an API key
+// cannot be used to create or revoke the keys.
+type APIKeyHandler struct {
+	auth *Authenticator
+	keys apikey.Interface
+}
+
+func (h *APIKeyHandler) RegisterHandlers(r *mux.Router) {
+	auth := h.auth.Required(rejectAPIKeys)
+	r.Handle("/", auth.ThenFunc(h.List)).Methods(http.MethodGet)
+	r.Handle("/", auth.ThenFunc(h.Create)).Methods(http.MethodPost)
+	r.Handle("/{id}", auth.ThenFunc(h.Delete)).Methods(http.MethodDelete)
+}
+
+// List handles requests for all API keys of the user.
+func (h *APIKeyHandler) List(w http.ResponseWriter, r *http.Request) {
+	userID := requestUserID(r)
+
+	keys, err := h.keys.Keys(r.Context(), userID)
+	if err != nil {
+		writeError(w, apiKeyErrorStatus(err), err)
+		return
+	}
+
+	resp := make([]apiKeyResponse, len(keys))
+	for i, k := range keys {
+		resp[i] = newAPIKeyResponse(k, "")
+	}
+	err = json.NewEncoder(w).Encode(resp)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+}
+
+// Create handles requests for new API keys.
+// The response is the only place the token is shown.
+func (h *APIKeyHandler) Create(w http.ResponseWriter, r *http.Request) {
+	userID := requestUserID(r)
+
+	data := struct {
+		Name   string   `json:"name"`
+		Scopes []string `json:"scopes"`
+	}{}
+	err := json.NewDecoder(r.Body).Decode(&data)
+	if err != nil {
+		err = fmt.Errorf("decoding create api key request: %w", err)
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	k := &apikey.Key{Name: data.Name, Scopes: data.Scopes}
+	k, token, err := h.keys.AddKey(r.Context(), k, userID)
+	if err != nil {
+		writeError(w, apiKeyErrorStatus(err), err)
+		return
+	}
+
+	err = json.NewEncoder(w).Encode(newAPIKeyResponse(k, token))
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+}
+
+// Delete handles API key revocation requests.
+func (h *APIKeyHandler) Delete(w http.ResponseWriter, r *http.Request) {
+	userID := requestUserID(r)
+
+	id, err := getVarID(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	err = h.keys.DeleteKey(r.Context(), id, userID)
+	if err != nil {
+		writeError(w, apiKeyErrorStatus(err), err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// rejectAPIKeys lets only the requests authorized with a JWT through.
+func rejectAPIKeys(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := r.Context().Value(KeyScopes).([]string); ok {
+			writeError(w, http.StatusForbidden, errors.New("authorization: API keys cannot manage API keys"))
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+func apiKeyErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, &market.ErrPermission{}):
+		return http.StatusForbidden
+	case errors.Is(err, apikey.ErrKeyNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, apikey.ErrInvalidKey):
+		return http.StatusBadRequest
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+type apiKeyResponse struct {
+	ID         int        `json:"id"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	Token      string     `json:"token,omitempty"`
+	Scopes     []string   `json:"scopes"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+}
+
+func newAPIKeyResponse(k *apikey.Key, token string) apiKeyResponse {
+	resp := apiKeyResponse{
+		ID:        k.ID,
+		Name:      k.Name,
+		Prefix:    apikey.TokenPrefix + k.Prefix,
+		Token:     token,
+		Scopes:    k.Scopes,
+		CreatedAt: k.CreatedAt,
+	}
+	if !k.LastUsedAt.IsZero() {
+		resp.LastUsedAt = &k.LastUsedAt
+	}
+	return resp
+}
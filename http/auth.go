@@ -0,0 +1,301 @@
+package http
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/websocket"
+	"github.com/ortymid/t2-http/apikey"
+	"github.com/ortymid/t2-http/jwt"
+)
+
+// authRealm is the protection space named in the WWW-Authenticate challenges.
+const authRealm = "market"
+
+var (
+	errAuthorizationRequired = errors.New("authorization required")
+	// errInvalidRequest marks a malformed Authorization header.
+	errInvalidRequest = errors.New("malformed authorization")
+)
+
+// Middleware wraps a handler, e.g. to check the request first.
+type Middleware func(http.Handler) http.Handler
+
+// Chain composes the middleware, the first one sees the request first.
+func Chain(mws ...Middleware) Middleware {
+	return func(h http.Handler) http.Handler {
+		for i := len(mws) - 1; i >= 0; i-- {
+			h = mws[i](h)
+		}
+		return h
+	}
+}
+
+// Then wraps the handler.
+func (mw Middleware) Then(h http.Handler) http.Handler {
+	return mw(h)
+}
+
+// ThenFunc wraps the handler function.
+func (mw Middleware) ThenFunc(f http.HandlerFunc) http.Handler {
+	return mw(f)
+}
+
+// Authenticator provides the middleware declaring the authorization
+// requirements of the routes. The authenticated user ID is put to the request
+// context under KeyUserID, the API key scopes are put under KeyScopes.
+// The requests are rate limited per client IP before the authentication
+// and per user after it.
+// The POST requests with an Idempotency-Key passing the authorization
+// checks of Required and RequiredScope are replayed.
+type Authenticator struct {
+	JWT *jwt.Verifier
+	// APIKeys may be nil, then the API keys are rejected.
+	APIKeys apikey.Interface
+	// Limiter may be nil, then nothing is rate limited.
+	Limiter *RateLimiter
+	// Idempotency may be nil, then the Idempotency-Key is ignored.
+	Idempotency *Idempotency
+}
+
+// Anonymous ignores the credentials, so even a broken Authorization header
+// does not affect the route.
+func (a *Authenticator) Anonymous() Middleware {
+	return a.Limiter.limitIP
+}
+
+// Optional authenticates the request if it has credentials.
+// Invalid credentials are rejected anyway.
+func (a *Authenticator) Optional() Middleware {
+	return func(h http.Handler) http.Handler {
+		limited := a.Limiter.limitUser(h)
+		return a.Limiter.limitIP(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			r, err := a.authenticate(r)
+			if err != nil {
+				writeAuthError(w, err)
+				return
+			}
+			if rec, ok := w.(*responseRecorder); ok {
+				rec.userID = requestUserID(r)
+			}
+			limited.ServeHTTP(w, r)
+		}))
+	}
+}
+
+// Required rejects the requests without valid credentials.
+// The checks of the route go to checks, the replay of the idempotent
+// requests goes after them, so the rejected requests are not remembered.
+func (a *Authenticator) Required(checks ...Middleware) Middleware {
+	mws := append([]Middleware{a.Optional(), requireUser}, checks...)
+	return Chain(append(mws, a.Idempotency.replay)...)
+}
+
+// RequiredScope rejects the requests without valid credentials
+// and the API keys not granting the scope.
+func (a *Authenticator) RequiredScope(scope string) Middleware {
+	return a.Required(requireScope(scope))
+}
+
+// RequiredScopeStream is RequiredScope for the routes streaming large bodies,
+// their requests are not replayed.
+func (a *Authenticator) RequiredScopeStream(scope string) Middleware {
+	return Chain(a.Optional(), requireUser, requireScope(scope))
+}
+
+func requireUser(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := r.Context().Value(KeyUserID).(string); !ok {
+			writeAuthError(w, errAuthorizationRequired)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+func requireScope(scope string) Middleware {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !hasScope(r.Context(), scope) {
+				writeInsufficientScope(w, scope)
+				return
+			}
+			h.ServeHTTP(w, r)
+		})
+	}
+}
+
+// requestUserID returns the user authenticated by the route middleware.
+func requestUserID(r *http.Request) string {
+	userID, _ := r.Context().Value(KeyUserID).(string)
+	return userID
+}
+
+// authenticate attaches a user ID obtained from the JWT or the API key
+// to the request context.
+// getToken function defines where is the JWT expected to be found.
+// No token means an anonymous request.
+func (a *Authenticator) authenticate(req *http.Request) (*http.Request, error) {
+	tokenString, err := getTokenString(req)
+	if err != nil {
+		return nil, err
+	}
+	if len(tokenString) == 0 {
+		return req, nil // ok, no token
+	}
+
+	if strings.HasPrefix(tokenString, apikey.TokenPrefix) {
+		return a.withAPIKey(req, tokenString)
+	}
+
+	claims, err := a.JWT.Verify(tokenString)
+	if err != nil {
+		return nil, &invalidTokenError{err: err}
+	}
+
+	userID := strconv.Itoa(claims.UserID)
+
+	ctx := req.Context()
+	ctx = context.WithValue(ctx, KeyUserID, userID)
+	return req.WithContext(ctx), nil
+}
+
+// withAPIKey attaches the owner and the scopes of the API key to the request context.
+func (a *Authenticator) withAPIKey(req *http.Request, token string) (*http.Request, error) {
+	if a.APIKeys == nil {
+		return nil, &invalidTokenError{err: errors.New("API keys are not supported")}
+	}
+
+	k, err := a.APIKeys.Authenticate(req.Context(), token)
+	if errors.Is(err, apikey.ErrUnauthorized) {
+		return nil, &invalidTokenError{err: err}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	ctx := req.Context()
+	ctx = context.WithValue(ctx, KeyUserID, k.UserID)
+	ctx = context.WithValue(ctx, KeyScopes, k.Scopes)
+	return req.WithContext(ctx), nil
+}
+
+// hasScope reports whether the request credentials grant the scope.
+// A JWT grants everything, an API key only its own scopes.
+func hasScope(ctx context.Context, scope string) bool {
+	scopes, ok := ctx.Value(KeyScopes).([]string)
+	if !ok {
+		return true
+	}
+	for _, s := range scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// getTokenString looks for the JWT or the API key in the Authorization header.
+// The API key may also be passed in the X-API-Key header.
+// WebSocket handshakes may pass it in the access_token query parameter instead,
+// since browsers cannot set headers for them.
+// Absence of the token cosidered a normal case.
+func getTokenString(req *http.Request) (string, error) {
+	if key := req.Header.Get(HeaderAPIKey); len(key) != 0 {
+		return key, nil
+	}
+
+	auth := req.Header.Get("Authorization")
+	if len(auth) == 0 && websocket.IsWebSocketUpgrade(req) {
+		return req.URL.Query().Get("access_token"), nil
+	}
+	if len(auth) == 0 {
+		return "", nil // ok, no token
+	}
+
+	authFields := strings.Fields(auth)
+	if len(authFields) != 2 {
+		return "", fmt.Errorf("%w: Authorization header is not \"Bearer <token>\"", errInvalidRequest)
+	}
+
+	typ := authFields[0]
+	if !strings.EqualFold(typ, "Bearer") {
+		return "", fmt.Errorf("%w: Authorization type is not Bearer", errInvalidRequest)
+	}
+
+	token := authFields[1]
+	return token, nil
+}
+
+// invalidTokenError is returned for the tokens failing the verification.
+// The message names the failed check.
+type invalidTokenError struct {
+	err error
+}
+
+func (e *invalidTokenError) Error() string {
+	return "invalid request token: " + e.err.Error()
+}
+
+func (e *invalidTokenError) Unwrap() error {
+	return e.err
+}
+
+// writeAuthError responds to a request that failed the authentication
+// with the Bearer challenge of RFC 6750: 401 for a missing or an invalid token
+// and 400 for a malformed request.
+func writeAuthError(w http.ResponseWriter, err error) {
+	var tokenErr *invalidTokenError
+	switch {
+	case errors.As(err, &tokenErr):
+		w.Header().Set("WWW-Authenticate", bearerChallenge("invalid_token", tokenErr.err.Error(), ""))
+		writeError(w, http.StatusUnauthorized, fmt.Errorf("authorization: %w", err))
+	case errors.Is(err, errInvalidRequest):
+		w.Header().Set("WWW-Authenticate", bearerChallenge("invalid_request", err.Error(), ""))
+		writeError(w, http.StatusBadRequest, fmt.Errorf("authorization: %w", err))
+	case errors.Is(err, errAuthorizationRequired):
+		w.Header().Set("WWW-Authenticate", bearerChallenge("", "", ""))
+		writeError(w, http.StatusUnauthorized, err)
+	default:
+		writeError(w, http.StatusInternalServerError, fmt.Errorf("authorization: %w", err))
+	}
+}
+
+// writeInsufficientScope responds to a request made with an API key
+// that does not grant the scope.
+func writeInsufficientScope(w http.ResponseWriter, scope string) {
+	err := fmt.Errorf("authorization: API key lacks the %s scope", scope)
+	w.Header().Set("WWW-Authenticate", bearerChallenge("insufficient_scope", err.Error(), scope))
+	writeError(w, http.StatusForbidden, err)
+}
+
+// bearerChallenge builds the WWW-Authenticate header value.
+// Empty attributes are omitted.
+func bearerChallenge(code, description, scope string) string {
+	var b strings.Builder
+	b.WriteString(`Bearer realm="` + authRealm + `"`)
+	if code != "" {
+		b.WriteString(`, error="` + code + `"`)
+	}
+	if description != "" {
+		b.WriteString(`, error_description="` + quoteChallenge(description) + `"`)
+	}
+	if scope != "" {
+		b.WriteString(`, scope="` + scope + `"`)
+	}
+	return b.String()
+}
+
+// quoteChallenge drops the characters not allowed in the error_description.
+func quoteChallenge(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '"' || r == '\\' || r < 0x20 || r > 0x7e {
+			return -1
+		}
+		return r
+	}, s)
+}
@@ -0,0 +1,155 @@
+package http
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// The formats of the product export and import.
+const (
+	mediaCSV    = "text/csv"
+	mediaNDJSON = "application/x-ndjson"
+)
+
+// exportFlushRows is how many rows are written between the flushes.
+const exportFlushRows = 100
+
+// productRecord is a product as a row of the export and the import.
+type productRecord struct {
+	ID     int    `json:"id"`
+	Name   string `json:"name"`
+	Price  int    `json:"price"`
+	Seller string `json:"seller"`
+}
+
+// csvHeader names the CSV columns of the export.
+var csvHeader = []string{"id", "name", "price", "seller"}
+
+// Export handles requests for all products as CSV or NDJSON, chosen
+// by the Accept header, CSV if any format will do.
+func (h *ProductHandler) Export(w http.ResponseWriter, r *http.Request) {
+	format := negotiate(r.Header.Get("Accept"), mediaCSV, mediaNDJSON)
+	if format == "" {
+		writeError(w, http.StatusNotAcceptable, fmt.Errorf("export is available as %s or %s", mediaCSV, mediaNDJSON))
+		return
+	}
+
+	products, err := h.market.Products(r.Context())
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	ext := "csv"
+	if format == mediaNDJSON {
+		ext = "ndjson"
+	}
+	w.Header().Set("Content-Type", format)
+	w.Header().Set("Content-Disposition", `attachment; filename="products.`+ext+`"`)
+	flusher, _ := w.(http.Flusher)
+	flush := func() {
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+
+	if format == mediaNDJSON {
+		enc := json.NewEncoder(w)
+		for i, p := range products {
+			if err := enc.Encode(productRecord(*p)); err != nil {
+				// The client is gone, the status is sent already.
+				return
+			}
+			if (i+1)%exportFlushRows == 0 {
+				flush()
+			}
+		}
+		return
+	}
+
+	cw := csv.NewWriter(w)
+	cw.Write(csvHeader)
+	for i, p := range products {
+		cw.Write([]string{strconv.Itoa(p.ID), csvCell(p.Name), strconv.Itoa(p.Price), csvCell(p.Seller)})
+		if (i+1)%exportFlushRows == 0 {
+			cw.Flush()
+			if cw.Error() != nil {
+				return
+			}
+			flush()
+		}
+	}
+	cw.Flush()
+}
+
+// csvEscapedPrefixes start the cells a spreadsheet takes for formulas
+// and the cells starting with the escaping quote itself.
+const csvEscapedPrefixes = "=+-@\t\r'"
+
+// csvCell escapes a text cell taken for a formula with a leading quote,
+// so opening the export in a spreadsheet does not run it.
+func csvCell(s string) string {
+	if s != "" && strings.ContainsRune(csvEscapedPrefixes, rune(s[0])) {
+		return "'" + s
+	}
+	return s
+}
+
+// csvCellValue undoes csvCell, so an export is imported back unchanged.
+func csvCellValue(s string) string {
+	if len(s) > 1 && s[0] == '\'' && strings.ContainsRune(csvEscapedPrefixes, rune(s[1])) {
+		return s[1:]
+	}
+	return s
+}
+
+// negotiate returns the first offer the Accept header takes,
+// the first one if there is no header, "" if it takes none.
+// The quality values are not weighed, only q=0 rejects the type.
+func negotiate(accept string, offers ...string) string {
+	if strings.TrimSpace(accept) == "" {
+		return offers[0]
+	}
+	type mediaRange struct {
+		media    string
+		rejected bool
+	}
+	var ranges []mediaRange
+	rejected := make(map[string]bool)
+	for _, s := range strings.Split(accept, ",") {
+		media, params, err := mime.ParseMediaType(s)
+		if err != nil {
+			continue
+		}
+		if media == "application/ndjson" {
+			media = mediaNDJSON
+		}
+		q, err := strconv.ParseFloat(params["q"], 64)
+		r := mediaRange{media: media, rejected: err == nil && q == 0}
+		if r.rejected {
+			rejected[media] = true
+		}
+		ranges = append(ranges, r)
+	}
+
+	for _, r := range ranges {
+		if r.rejected {
+			continue
+		}
+		for _, offer := range offers {
+			if rejected[offer] {
+				continue
+			}
+			if r.media == "*/*" || r.media == offer ||
+				strings.HasSuffix(r.media, "/*") && strings.HasPrefix(offer, strings.TrimSuffix(r.media, "*")) {
+				return offer
+			}
+		}
+	}
+	return ""
+}
@@ -0,0 +1,118 @@
+package http
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ortymid/t2-http/market"
+)
+
+func TestRouter_Export(t *testing.T) {
+	h := NewRouter(RouterConfig{
+		Market: MockMarket{ProductsRet: []*market.Product{
+			{ID: 1, Name: "Banana", Price: 1500, Seller: "1"},
+			{ID: 2, Name: "Carrot, washed", Price: 1400, Seller: "2"},
+			{ID: 3, Name: "=SUM(A1)", Price: 1, Seller: "@2"},
+		}},
+		JWT: verifier,
+	})
+
+	tests := []struct {
+		name            string
+		accept          string
+		wantStatus      int
+		wantContentType string
+		wantBody        string
+	}{
+		{
+			name:            "Should export CSV by default",
+			wantStatus:      http.StatusOK,
+			wantContentType: "text/csv",
+			wantBody:        "id,name,price,seller\n1,Banana,1500,1\n2,\"Carrot, washed\",1400,2\n3,'=SUM(A1),1,'@2\n",
+		},
+		{
+			name:            "Should export NDJSON when accepted",
+			accept:          "application/x-ndjson, text/csv;q=0.5",
+			wantStatus:      http.StatusOK,
+			wantContentType: "application/x-ndjson",
+			wantBody:        "{\"id\":1,\"name\":\"Banana\",\"price\":1500,\"seller\":\"1\"}\n{\"id\":2,\"name\":\"Carrot, washed\",\"price\":1400,\"seller\":\"2\"}\n{\"id\":3,\"name\":\"=SUM(A1)\",\"price\":1,\"seller\":\"@2\"}\n",
+		},
+		{
+			name:            "Should reject an unknown format",
+			accept:          "application/xml",
+			wantStatus:      http.StatusNotAcceptable,
+			wantContentType: "application/json",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest("GET", "/products/export", nil)
+			if tt.accept != "" {
+				r.Header.Set("Accept", tt.accept)
+			}
+			h.ServeHTTP(w, r)
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("Status = %d, want %d", w.Code, tt.wantStatus)
+			}
+			if got := w.Header().Get("Content-Type"); got != tt.wantContentType {
+				t.Errorf("Content-Type = %q, want %q", got, tt.wantContentType)
+			}
+			if tt.wantBody != "" && w.Body.String() != tt.wantBody {
+				t.Errorf("Body = %q, want %q", w.Body.String(), tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestCSVCell(t *testing.T) {
+	tests := []struct {
+		name  string
+		value string
+		want  string
+	}{
+		{name: "Should keep the plain text", value: "Banana", want: "Banana"},
+		{name: "Should keep the empty text", value: "", want: ""},
+		{name: "Should escape a formula", value: "=SUM(A1)", want: "'=SUM(A1)"},
+		{name: "Should escape a sign", value: "-1", want: "'-1"},
+		{name: "Should escape a leading quote", value: "'Banana", want: "''Banana"},
+		{name: "Should keep a quote inside", value: "Banana's", want: "Banana's"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := csvCell(tt.value)
+			if got != tt.want {
+				t.Errorf("csvCell() = %q, want %q", got, tt.want)
+			}
+			if back := csvCellValue(got); back != tt.value {
+				t.Errorf("csvCellValue(%q) = %q, want %q", got, back, tt.value)
+			}
+		})
+	}
+}
+
+func TestNegotiate(t *testing.T) {
+	tests := []struct {
+		name   string
+		accept string
+		want   string
+	}{
+		{name: "Should take the first offer without a header", accept: "", want: mediaCSV},
+		{name: "Should take the first accepted offer", accept: "application/json, application/x-ndjson, text/csv", want: mediaNDJSON},
+		{name: "Should match the wildcards", accept: "text/*", want: mediaCSV},
+		{name: "Should take application/ndjson for NDJSON", accept: "application/ndjson", want: mediaNDJSON},
+		{name: "Should skip the rejected ranges", accept: "text/csv;q=0, */*", want: mediaNDJSON},
+		{name: "Should take nothing else", accept: "application/json", want: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := negotiate(tt.accept, mediaCSV, mediaNDJSON); got != tt.want {
+				t.Errorf("negotiate() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
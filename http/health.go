@@ -0,0 +1,28 @@
+package http
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/ortymid/t2-http/health"
+	"github.com/ortymid/t2-http/logging"
+)
+
+// healthHandler serves the liveness or the readiness report,
+// failing reports get 503 Service Unavailable.
+func healthHandler(report func(r *http.Request) health.Report) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rep := report(r)
+		status := http.StatusOK
+		if rep.Status != health.StatusOK {
+			status = http.StatusServiceUnavailable
+		}
+
+		w.WriteHeader(status)
+		if err := json.NewEncoder(w).Encode(rep); err != nil {
+			err = fmt.Errorf("encoding health report: %w", err)
+			logging.FromContext(r.Context()).Error("writing health report", "error", err)
+		}
+	})
+}
@@ -0,0 +1,142 @@
+package http
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/ortymid/t2-http/idempotency"
+	"github.com/ortymid/t2-http/logging"
+)
+
+const (
+	// HeaderIdempotencyKey makes a retried POST request not done twice.
+	HeaderIdempotencyKey = "Idempotency-Key"
+	// HeaderIdempotentReplayed marks the remembered responses.
+	HeaderIdempotentReplayed = "Idempotent-Replayed"
+
+	maxIdempotencyKeyLength = 255
+	// maxIdempotentBodySize limits the body read to tell the requests apart.
+	maxIdempotentBodySize = 1 << 20
+)
+
+// notReplayedHeaders are not replayed, they describe the request at hand.
+var notReplayedHeaders = []string{
+	logging.HeaderRequestID,
+	"RateLimit-Limit",
+	"RateLimit-Remaining",
+	"RateLimit-Reset",
+	"Retry-After",
+}
+
+// Idempotency replays the responses to the POST requests of a user
+// repeated with the same Idempotency-Key.
+type Idempotency struct {
+	Store idempotency.Store
+	// TTL is how long the keys are remembered since the first request.
+	TTL time.Duration
+}
+
+// replay serves the first request with a key and replays its response
+// to the next ones. The key reused for another request gets 422,
+// the request repeated while the first one is served gets 409.
+// The server errors and the authorization failures are not remembered,
+// so such requests may be retried. The body is read into memory, so it is
+// limited to maxIdempotentBodySize. It must go after all the authorization
+// checks, the anonymous requests are not replayed. A nil Idempotency
+// replays nothing.
+func (i *Idempotency) replay(h http.Handler) http.Handler {
+	if i == nil {
+		return h
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(HeaderIdempotencyKey)
+		userID := requestUserID(r)
+		if r.Method != http.MethodPost || key == "" || userID == "" {
+			h.ServeHTTP(w, r)
+			return
+		}
+		if len(key) > maxIdempotencyKeyLength {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("%s is longer than %d characters", HeaderIdempotencyKey, maxIdempotencyKeyLength))
+			return
+		}
+
+		body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxIdempotentBodySize))
+		if err != nil {
+			// Go 1.15 has no distinct error type for the exceeded limit.
+			if err.Error() == "http: request body too large" {
+				writeError(w, http.StatusRequestEntityTooLarge, fmt.Errorf("the body of a request with %s is larger than %d bytes", HeaderIdempotencyKey, maxIdempotentBodySize))
+				return
+			}
+			writeError(w, http.StatusBadRequest, fmt.Errorf("reading body: %w", err))
+			return
+		}
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+		hash := sha256.New()
+		fmt.Fprintf(hash, "%s %s\n", r.Method, r.URL.RequestURI())
+		hash.Write(body)
+
+		ctx := r.Context()
+		resp, err := i.Store.Begin(ctx, userID, key, hash.Sum(nil), i.TTL)
+		switch {
+		case errors.Is(err, idempotency.ErrMismatch):
+			writeError(w, http.StatusUnprocessableEntity, err)
+			return
+		case errors.Is(err, idempotency.ErrInProgress):
+			writeError(w, http.StatusConflict, err)
+			return
+		case err != nil:
+			writeError(w, http.StatusInternalServerError, fmt.Errorf("idempotency: %w", err))
+			return
+		case resp != nil:
+			for name, values := range resp.Header {
+				w.Header()[name] = values
+			}
+			w.Header().Set(HeaderIdempotentReplayed, "true")
+			w.WriteHeader(resp.Status)
+			w.Write(resp.Body)
+			return
+		}
+
+		rec, ok := w.(*responseRecorder)
+		if !ok {
+			rec = &responseRecorder{ResponseWriter: w}
+		}
+		rec.body = &bytes.Buffer{}
+		finished := false
+		defer func() {
+			rec.body = nil
+			if !finished {
+				// The handler panicked.
+				i.Store.Abort(ctx, userID, key)
+			}
+		}()
+		h.ServeHTTP(rec, r)
+		finished = true
+
+		status := rec.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		if status >= http.StatusInternalServerError || status == http.StatusUnauthorized || status == http.StatusForbidden {
+			err = i.Store.Abort(ctx, userID, key)
+		} else {
+			header := rec.Header().Clone()
+			for _, name := range notReplayedHeaders {
+				header.Del(name)
+			}
+			err = i.Store.Finish(ctx, userID, key, &idempotency.Response{
+				Status: status,
+				Header: header,
+				Body:   rec.body.Bytes(),
+			})
+		}
+		if err != nil {
+			logging.FromContext(ctx).Error("saving idempotent response failed", "error", err)
+		}
+	})
+}
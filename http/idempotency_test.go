@@ -0,0 +1,243 @@
+package http
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ortymid/t2-http/apikey"
+	"github.com/ortymid/t2-http/service/mem"
+	"github.com/ortymid/t2-http/webhook"
+)
+
+func TestIdempotency_replay(t *testing.T) {
+	a := &Authenticator{
+		JWT:         verifier,
+		Idempotency: &Idempotency{Store: mem.NewIdempotencyStore(), TTL: time.Hour},
+	}
+	calls := 0
+	h := a.Required().ThenFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if r.URL.Path == "/fail" {
+			writeError(w, http.StatusInternalServerError, errors.New("storage is down"))
+			return
+		}
+		if r.URL.Path == "/forbidden" {
+			writeError(w, http.StatusForbidden, errors.New("product of another seller"))
+			return
+		}
+		w.Header().Set("Location", "/products/"+strconv.Itoa(calls))
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(strconv.Itoa(calls)))
+	})
+	user1, user2 := "Bearer "+testToken(t, 1), "Bearer "+testToken(t, 2)
+
+	tests := []struct {
+		name          string
+		method        string
+		path          string
+		body          string
+		key           string
+		authorization string
+		wantStatus    int
+		wantBody      string
+		wantReplayed  bool
+	}{
+		{name: "Should serve the first request", key: "k1", authorization: user1, wantStatus: http.StatusCreated, wantBody: "1"},
+		{name: "Should replay the repeated request", key: "k1", authorization: user1, wantStatus: http.StatusCreated, wantBody: "1", wantReplayed: true},
+		{name: "Should reject the key reused with another body", key: "k1", body: "other", authorization: user1, wantStatus: http.StatusUnprocessableEntity},
+		{name: "Should reject the key reused with another path", key: "k1", path: "/other", authorization: user1, wantStatus: http.StatusUnprocessableEntity},
+		{name: "Should reject the key reused with another query", key: "k1", path: "/products/?dry_run=true", authorization: user1, wantStatus: http.StatusUnprocessableEntity},
+		{name: "Should keep the keys of the users apart", key: "k1", authorization: user2, wantStatus: http.StatusCreated, wantBody: "2"},
+		{name: "Should serve the requests without a key", authorization: user1, wantStatus: http.StatusCreated, wantBody: "3"},
+		{name: "Should ignore the key of the other methods", method: "PUT", key: "k1", authorization: user1, wantStatus: http.StatusCreated, wantBody: "4"},
+		{name: "Should not remember the server errors", key: "k2", path: "/fail", authorization: user1, wantStatus: http.StatusInternalServerError},
+		{name: "Should serve the request again after a server error", key: "k2", path: "/fail", authorization: user1, wantStatus: http.StatusInternalServerError},
+		{name: "Should not remember the authorization failures", key: "k3", path: "/forbidden", authorization: user1, wantStatus: http.StatusForbidden},
+		{name: "Should serve the request again after an authorization failure", key: "k3", path: "/forbidden", authorization: user1, wantStatus: http.StatusForbidden},
+		{name: "Should reject a too long key", key: strings.Repeat("k", 256), authorization: user1, wantStatus: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			method, path := tt.method, tt.path
+			if method == "" {
+				method = "POST"
+			}
+			if path == "" {
+				path = "/products/"
+			}
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(method, path, strings.NewReader(tt.body))
+			r.Header.Set("Authorization", tt.authorization)
+			if tt.key != "" {
+				r.Header.Set(HeaderIdempotencyKey, tt.key)
+			}
+			h.ServeHTTP(w, r)
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("Status = %d, want %d", w.Code, tt.wantStatus)
+			}
+			if tt.wantBody != "" && w.Body.String() != tt.wantBody {
+				t.Errorf("Body = %q, want %q", w.Body.String(), tt.wantBody)
+			}
+			if tt.wantBody != "" && w.Header().Get("Location") != "/products/"+tt.wantBody {
+				t.Errorf("Location = %q, want the first response", w.Header().Get("Location"))
+			}
+			if got := w.Header().Get(HeaderIdempotentReplayed) == "true"; got != tt.wantReplayed {
+				t.Errorf("replayed = %v, want %v", got, tt.wantReplayed)
+			}
+		})
+	}
+	if calls != 8 {
+		t.Errorf("handler calls = %d, want 8", calls)
+	}
+}
+
+func TestIdempotency_replayInProgress(t *testing.T) {
+	a := &Authenticator{
+		JWT:         verifier,
+		Idempotency: &Idempotency{Store: mem.NewIdempotencyStore(), TTL: time.Hour},
+	}
+	started, release := make(chan struct{}), make(chan struct{})
+	h := a.Required().ThenFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+	})
+	request := func() *http.Request {
+		r := httptest.NewRequest("POST", "/products/", nil)
+		r.Header.Set("Authorization", "Bearer "+testToken(t, 1))
+		r.Header.Set(HeaderIdempotencyKey, "k")
+		return r
+	}
+
+	done := make(chan struct{})
+	go func() {
+		h.ServeHTTP(httptest.NewRecorder(), request())
+		close(done)
+	}()
+	<-started
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, request())
+	if w.Code != http.StatusConflict {
+		t.Errorf("Status = %d, want %d", w.Code, http.StatusConflict)
+	}
+	close(release)
+	<-done
+}
+
+func TestIdempotency_replayLargeBody(t *testing.T) {
+	a := &Authenticator{
+		JWT:         verifier,
+		Idempotency: &Idempotency{Store: mem.NewIdempotencyStore(), TTL: time.Hour},
+	}
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		n, _ := io.Copy(ioutil.Discard, r.Body)
+		w.Write([]byte(strconv.FormatInt(n, 10)))
+	}
+	body := strings.Repeat("x", maxIdempotentBodySize+1)
+
+	tests := []struct {
+		name       string
+		mw         Middleware
+		wantStatus int
+		wantBody   string
+	}{
+		{name: "Should reject the too large body", mw: a.Required(), wantStatus: http.StatusRequestEntityTooLarge},
+		{name: "Should stream the body of the routes not replayed", mw: a.RequiredScopeStream(apikey.ScopeProductsWrite), wantStatus: http.StatusOK, wantBody: strconv.Itoa(len(body))},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest("POST", "/products/import", strings.NewReader(body))
+			r.Header.Set("Authorization", "Bearer "+testToken(t, 1))
+			r.Header.Set(HeaderIdempotencyKey, "k")
+			tt.mw.ThenFunc(handler).ServeHTTP(w, r)
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("Status = %d, want %d", w.Code, tt.wantStatus)
+			}
+			if tt.wantBody != "" && w.Body.String() != tt.wantBody {
+				t.Errorf("Body = %q, want %q", w.Body.String(), tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestRouter_IdempotencyAuthorization(t *testing.T) {
+	h := NewRouter(RouterConfig{
+		Market:      MockMarket{},
+		JWT:         verifier,
+		APIKeys:     &apikey.Manager{Store: mem.NewAPIKeyStore()},
+		Webhooks:    &webhook.Manager{Store: mem.NewWebhookStore()},
+		Idempotency: &Idempotency{Store: mem.NewIdempotencyStore(), TTL: time.Hour},
+	})
+	jwtAuth := "Bearer " + testToken(t, 1)
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("POST", "/apikeys/", strings.NewReader(`{"name":"batch","scopes":["products:write"]}`))
+	r.Header.Set("Authorization", jwtAuth)
+	h.ServeHTTP(w, r)
+	created := struct {
+		Token string `json:"token"`
+	}{}
+	if err := json.NewDecoder(w.Body).Decode(&created); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	keyAuth := "Bearer " + created.Token
+
+	// The steps run in order on the same keys.
+	tests := []struct {
+		name          string
+		path          string
+		body          string
+		key           string
+		authorization string
+		wantStatus    int
+	}{
+		{
+			name: "Should reject the key outside of its scopes", path: "/webhooks/",
+			body: `{"url":"http://203.0.113.10/hook"}`, key: "w", authorization: keyAuth,
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name: "Should not replay the rejection to a token", path: "/webhooks/",
+			body: `{"url":"http://203.0.113.10/hook"}`, key: "w", authorization: jwtAuth,
+			wantStatus: http.StatusOK,
+		},
+		{
+			name: "Should create a key with a token", path: "/apikeys/",
+			body: `{"name":"all","scopes":["webhooks"]}`, key: "a", authorization: jwtAuth,
+			wantStatus: http.StatusOK,
+		},
+		{
+			name: "Should not replay the created key to an API key", path: "/apikeys/",
+			body: `{"name":"all","scopes":["webhooks"]}`, key: "a", authorization: keyAuth,
+			wantStatus: http.StatusForbidden,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest("POST", tt.path, strings.NewReader(tt.body))
+			r.Header.Set("Authorization", tt.authorization)
+			r.Header.Set(HeaderIdempotencyKey, tt.key)
+			h.ServeHTTP(w, r)
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("Status = %d, want %d", w.Code, tt.wantStatus)
+			}
+			if w.Header().Get(HeaderIdempotentReplayed) != "" {
+				t.Errorf("the response is replayed")
+			}
+		})
+	}
+}
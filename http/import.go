@@ -0,0 +1,300 @@
+package http
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/ortymid/t2-http/logging"
+	"github.com/ortymid/t2-http/market"
+)
+
+const (
+	// importChunkRows is how many rows are applied by a single batch.
+	importChunkRows = 100
+	// maxImportLine limits a line of NDJSON.
+	maxImportLine = 64 << 10
+)
+
+// rowError is a row of the import that was not applied.
+type rowError struct {
+	Row   int    `json:"row"`
+	Error string `json:"error"`
+}
+
+// importSummary is the last line of the import response.
+type importSummary struct {
+	Rows     int    `json:"rows"`
+	Created  int    `json:"created"`
+	Replaced int    `json:"replaced"`
+	Failed   int    `json:"failed"`
+	DryRun   bool   `json:"dry_run"`
+	Error    string `json:"error,omitempty"`
+}
+
+// importRow is the operation of a row, or the error of the row.
+type importRow struct {
+	n   int // 1-based
+	op  market.Operation
+	err error
+}
+
+// rowReader reads the import rows. next returns io.EOF after the last row,
+// any other error ends the import.
+type rowReader interface {
+	next() (importRow, error)
+}
+
+// Import handles requests to create and replace many products from CSV
+// or NDJSON, chosen by the Content-Type. The rows with an id replace
+// the products, the others create them. With dry_run=true the rows
+// are only checked.
+//
+// The response is NDJSON streamed while the rows are applied:
+// a line per failed row and the summary line at the end.
+func (h *ProductHandler) Import(w http.ResponseWriter, r *http.Request) {
+	userID := requestUserID(r)
+
+	dryRun := false
+	if v := r.URL.Query().Get("dry_run"); v != "" {
+		var err error
+		dryRun, err = strconv.ParseBool(v)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("dry_run is not a boolean: %q", v))
+			return
+		}
+	}
+
+	media, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	var rows rowReader
+	switch media {
+	case mediaCSV:
+		var err error
+		rows, err = newCSVRowReader(r.Body)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+	case mediaNDJSON, "application/ndjson":
+		rows = newNDJSONRowReader(r.Body)
+	default:
+		writeError(w, http.StatusUnsupportedMediaType, fmt.Errorf("import takes %s or %s", mediaCSV, mediaNDJSON))
+		return
+	}
+
+	w.Header().Set("Content-Type", mediaNDJSON)
+	w.WriteHeader(http.StatusOK)
+	flusher, _ := w.(http.Flusher)
+	enc := json.NewEncoder(w)
+	summary := importSummary{DryRun: dryRun}
+
+	var ops []market.Operation
+	var opRows []int
+	apply := func() error {
+		if len(ops) == 0 {
+			return nil
+		}
+		var results []market.OperationResult
+		var err error
+		if dryRun {
+			results, err = h.market.CheckBatch(r.Context(), ops, userID)
+		} else {
+			results, err = h.market.Batch(r.Context(), ops, userID, false)
+		}
+		if err != nil {
+			return err
+		}
+		for i, res := range results {
+			switch {
+			case res.Err != nil:
+				summary.Failed++
+				enc.Encode(rowError{Row: opRows[i], Error: res.Err.Error()})
+			case ops[i].Op == market.OpCreate:
+				summary.Created++
+			default:
+				summary.Replaced++
+			}
+		}
+		ops, opRows = ops[:0], opRows[:0]
+		if flusher != nil {
+			flusher.Flush()
+		}
+		return nil
+	}
+
+	var err error
+	for {
+		var row importRow
+		row, err = rows.next()
+		if err != nil {
+			break
+		}
+		summary.Rows++
+		if row.err != nil {
+			summary.Failed++
+			enc.Encode(rowError{Row: row.n, Error: row.err.Error()})
+			continue
+		}
+		ops = append(ops, row.op)
+		opRows = append(opRows, row.n)
+		if len(ops) == importChunkRows {
+			if err = apply(); err != nil {
+				break
+			}
+		}
+	}
+	if errors.Is(err, io.EOF) {
+		err = apply()
+	}
+
+	if err != nil {
+		// The status is sent already, the summary tells the import stopped.
+		err = fmt.Errorf("importing products: %w", err)
+		if rec, ok := w.(*responseRecorder); ok {
+			rec.err = err
+		} else {
+			logging.FromContext(r.Context()).Error("import failed", "error", err)
+		}
+		summary.Error = err.Error()
+	}
+	enc.Encode(struct {
+		Summary importSummary `json:"summary"`
+	}{summary})
+}
+
+// newImportRow makes the operation of the row, checking the values.
+func newImportRow(n, id int, name string, price int) importRow {
+	switch {
+	case id < 0:
+		return importRow{n: n, err: errors.New("id must not be negative")}
+	case strings.TrimSpace(name) == "":
+		return importRow{n: n, err: errors.New("name is required")}
+	case price < 0:
+		return importRow{n: n, err: errors.New("price must not be negative")}
+	}
+	op := market.Operation{Op: market.OpCreate, Product: market.Product{ID: id, Name: name, Price: price}}
+	if id != 0 {
+		op.Op = market.OpReplace
+	}
+	return importRow{n: n, op: op}
+}
+
+type csvRowReader struct {
+	r       *csv.Reader
+	row     int
+	columns map[string]int
+}
+
+// newCSVRowReader reads the header. It must name the name and the price
+// columns, the id column is optional and the seller column is ignored,
+// so the export may be imported back.
+func newCSVRowReader(r io.Reader) (*csvRowReader, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	cr.ReuseRecord = true
+	header, err := cr.Read()
+	if err == io.EOF {
+		return nil, errors.New("CSV header is missing")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading CSV header: %w", err)
+	}
+
+	columns := make(map[string]int, len(header))
+	for i, name := range header {
+		name = strings.ToLower(strings.TrimSpace(name))
+		switch name {
+		case "id", "name", "price", "seller":
+			columns[name] = i
+		default:
+			return nil, fmt.Errorf("unknown CSV column %q", name)
+		}
+	}
+	for _, name := range []string{"name", "price"} {
+		if _, ok := columns[name]; !ok {
+			return nil, fmt.Errorf("CSV column %q is missing", name)
+		}
+	}
+	return &csvRowReader{r: cr, columns: columns}, nil
+}
+
+func (cr *csvRowReader) next() (importRow, error) {
+	record, err := cr.r.Read()
+	if err != nil {
+		var perr *csv.ParseError
+		if errors.As(err, &perr) {
+			cr.row++
+			return importRow{n: cr.row, err: perr.Err}, nil
+		}
+		return importRow{}, err
+	}
+	cr.row++
+
+	field := func(name string) string {
+		i, ok := cr.columns[name]
+		if !ok || i >= len(record) {
+			return ""
+		}
+		return strings.TrimSpace(record[i])
+	}
+	id := 0
+	if s := field("id"); s != "" {
+		if id, err = strconv.Atoi(s); err != nil {
+			return importRow{n: cr.row, err: fmt.Errorf("id is not an integer: %q", s)}, nil
+		}
+	}
+	s := field("price")
+	price, err := strconv.Atoi(s)
+	if err != nil {
+		return importRow{n: cr.row, err: fmt.Errorf("price is not an integer: %q", s)}, nil
+	}
+	return newImportRow(cr.row, id, csvCellValue(field("name")), price), nil
+}
+
+type ndjsonRowReader struct {
+	s   *bufio.Scanner
+	row int
+}
+
+func newNDJSONRowReader(r io.Reader) *ndjsonRowReader {
+	s := bufio.NewScanner(r)
+	s.Buffer(make([]byte, 0, 4096), maxImportLine)
+	return &ndjsonRowReader{s: s}
+}
+
+func (nr *ndjsonRowReader) next() (importRow, error) {
+	for nr.s.Scan() {
+		line := strings.TrimSpace(nr.s.Text())
+		if line == "" {
+			continue
+		}
+		nr.row++
+
+		var rec struct {
+			ID     int    `json:"id"`
+			Name   string `json:"name"`
+			Price  *int   `json:"price"`
+			Seller string `json:"seller"`
+		}
+		dec := json.NewDecoder(strings.NewReader(line))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&rec); err != nil {
+			return importRow{n: nr.row, err: fmt.Errorf("decoding row: %w", err)}, nil
+		}
+		if rec.Price == nil {
+			return importRow{n: nr.row, err: errors.New("price is required")}, nil
+		}
+		return newImportRow(nr.row, rec.ID, rec.Name, *rec.Price), nil
+	}
+	if err := nr.s.Err(); err != nil {
+		return importRow{}, err
+	}
+	return importRow{}, io.EOF
+}
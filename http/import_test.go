@@ -0,0 +1,102 @@
+package http
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/ortymid/t2-http/market"
+	"github.com/ortymid/t2-http/service/mem"
+)
+
+func TestRouter_Import(t *testing.T) {
+	// The products of mem.NewProductService are 1 of the user 1
+	// and 2 of the user 2.
+	tests := []struct {
+		name         string
+		query        string
+		contentType  string
+		body         string
+		wantStatus   int
+		wantBody     string
+		wantProducts int
+	}{
+		{
+			name:         "Should import CSV reporting the failed rows",
+			contentType:  "text/csv",
+			body:         "name,price,id\nApple,100,\nBanana,1600,1\nCarrot,1,2\n,5,\nPear,cheap,\n",
+			wantStatus:   http.StatusOK,
+			wantBody:     `{"row":4,"error":"name is required"}` + "\n" + `{"row":5,"error":"price is not an integer: \"cheap\""}` + "\n" + `{"row":3,"error":"edit product: permission denied: product of another seller"}` + "\n" + `{"summary":{"rows":5,"created":1,"replaced":1,"failed":3,"dry_run":false}}` + "\n",
+			wantProducts: 3,
+		},
+		{
+			name:         "Should import the export",
+			contentType:  "text/csv",
+			body:         "id,name,price,seller\n1,Banana,1600,1\n",
+			wantStatus:   http.StatusOK,
+			wantBody:     `{"summary":{"rows":1,"created":0,"replaced":1,"failed":0,"dry_run":false}}` + "\n",
+			wantProducts: 2,
+		},
+		{
+			name:         "Should import NDJSON",
+			contentType:  "application/x-ndjson",
+			body:         `{"name":"Apple","price":100}` + "\n\n" + `{"name":"Pear"}` + "\n" + `{"name":"Plum","price":1,"color":"blue"}` + "\n",
+			wantStatus:   http.StatusOK,
+			wantBody:     `{"row":2,"error":"price is required"}` + "\n" + `{"row":3,"error":"decoding row: json: unknown field \"color\""}` + "\n" + `{"summary":{"rows":3,"created":1,"replaced":0,"failed":2,"dry_run":false}}` + "\n",
+			wantProducts: 3,
+		},
+		{
+			name:         "Should only check the rows of a dry run",
+			query:        "?dry_run=true",
+			contentType:  "text/csv",
+			body:         "name,price,id\nApple,100,\nBanana,1600,9\n",
+			wantStatus:   http.StatusOK,
+			wantBody:     `{"row":2,"error":"edit product: product not found"}` + "\n" + `{"summary":{"rows":2,"created":1,"replaced":0,"failed":1,"dry_run":true}}` + "\n",
+			wantProducts: 2,
+		},
+		{
+			name:         "Should reject an unknown CSV column",
+			contentType:  "text/csv",
+			body:         "name,price,color\n",
+			wantStatus:   http.StatusBadRequest,
+			wantBody:     `{"message":"unknown CSV column \"color\""}` + "\n",
+			wantProducts: 2,
+		},
+		{
+			name:         "Should reject an unknown format",
+			contentType:  "application/json",
+			body:         "[]",
+			wantStatus:   http.StatusUnsupportedMediaType,
+			wantBody:     `{"message":"import takes text/csv or application/x-ndjson"}` + "\n",
+			wantProducts: 2,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			products := mem.NewProductService()
+			h := NewRouter(RouterConfig{
+				Market: &market.Market{UserService: mem.NewUserService(), ProductService: products},
+				JWT:    verifier,
+			})
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest("POST", "/products/import"+tt.query, strings.NewReader(tt.body))
+			r.Header.Set("Authorization", "Bearer "+testToken(t, 1))
+			r.Header.Set("Content-Type", tt.contentType)
+			h.ServeHTTP(w, r)
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("Status = %d, want %d", w.Code, tt.wantStatus)
+			}
+			if w.Body.String() != tt.wantBody {
+				t.Errorf("Body = %s, want %s", w.Body.String(), tt.wantBody)
+			}
+			ps, _ := products.Products(context.Background())
+			if len(ps) != tt.wantProducts {
+				t.Errorf("products = %d, want %d", len(ps), tt.wantProducts)
+			}
+		})
+	}
+}
@@ -0,0 +1,148 @@
+package http
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/ortymid/t2-http/logging"
+)
+
+// maxRequestIDLength limits the accepted X-Request-ID values.
+const maxRequestIDLength = 128
+
+// responseRecorder remembers what the handlers did for the access log.
+// It is the ResponseWriter every handler of the Router gets.
+type responseRecorder struct {
+	http.ResponseWriter
+	status  int
+	size    int
+	route   string
+	userID  string
+	traceID string
+	err     error
+	// body, if set, gets a copy of the response body.
+	body *bytes.Buffer
+}
+
+func (rec *responseRecorder) WriteHeader(status int) {
+	if rec.status == 0 {
+		rec.status = status
+	}
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *responseRecorder) Write(b []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	n, err := rec.ResponseWriter.Write(b)
+	rec.size += n
+	if rec.body != nil {
+		rec.body.Write(b[:n])
+	}
+	return n, err
+}
+
+// Flush lets the streams flush through the recorder.
+func (rec *responseRecorder) Flush() {
+	if f, ok := rec.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack lets the WebSocket upgrades through the recorder.
+func (rec *responseRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := rec.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("hijacking is not supported")
+	}
+	rec.status = http.StatusSwitchingProtocols
+	return h.Hijack()
+}
+
+// withRequestID takes the request ID from the X-Request-ID header
+// or generates one, and puts it to the context, the response and the fields
+// of the request logger.
+func withRequestID(logger *logging.Logger) Middleware {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			id := r.Header.Get(logging.HeaderRequestID)
+			if !validRequestID(id) {
+				id = logging.NewRequestID()
+			}
+			w.Header().Set(logging.HeaderRequestID, id)
+
+			ctx := logging.WithRequestID(r.Context(), id)
+			ctx = logging.NewContext(ctx, logger.With("request_id", id))
+			h.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+func validRequestID(id string) bool {
+	if len(id) == 0 || len(id) > maxRequestIDLength {
+		return false
+	}
+	for _, c := range id {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_' || c == '.' || c == ':') {
+			return false
+		}
+	}
+	return true
+}
+
+// logAccess writes a line per request with the method, the route template,
+// the status, the latency and the user. Server errors are logged as errors.
+func logAccess(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &responseRecorder{ResponseWriter: w}
+		h.ServeHTTP(rec, r)
+
+		status := rec.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		level := logging.LevelInfo
+		if status >= http.StatusInternalServerError {
+			level = logging.LevelError
+		}
+
+		keyvals := []interface{}{
+			"method", r.Method,
+			"route", rec.route,
+			"path", r.URL.Path,
+			"status", status,
+			"latency_ms", time.Since(start),
+			"bytes", rec.size,
+		}
+		if rec.userID != "" {
+			keyvals = append(keyvals, "user_id", rec.userID)
+		}
+		if rec.traceID != "" {
+			keyvals = append(keyvals, "trace_id", rec.traceID)
+		}
+		if rec.err != nil {
+			keyvals = append(keyvals, "error", rec.err)
+		}
+		logging.FromContext(r.Context()).Log(level, "request", keyvals...)
+	})
+}
+
+// recordRoute tells the access log the template of the matched route.
+// It is a mux middleware, as only mux knows the route.
+func recordRoute(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if rec, ok := w.(*responseRecorder); ok {
+			if route := mux.CurrentRoute(r); route != nil {
+				rec.route, _ = route.GetPathTemplate()
+			}
+		}
+		h.ServeHTTP(w, r)
+	})
+}
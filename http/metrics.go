@@ -0,0 +1,42 @@
+package http
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/ortymid/t2-http/metrics"
+)
+
+// unmatchedRoute labels the requests not matching any route,
+// so the arbitrary paths do not make new series.
+const unmatchedRoute = "unmatched"
+
+// measure counts the requests and records their latency per route template
+// and status. It relies on the recorder of logAccess.
+func measure(reg *metrics.Registry) Middleware {
+	requests := reg.NewCounterVec("http_requests_total",
+		"HTTP requests by method, route template and status.", "method", "route", "status")
+	duration := reg.NewHistogramVec("http_request_duration_seconds",
+		"HTTP request latency by method, route template and status.", nil, "method", "route", "status")
+
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			h.ServeHTTP(w, r)
+
+			route, status := unmatchedRoute, http.StatusOK
+			if rec, ok := w.(*responseRecorder); ok {
+				if rec.route != "" {
+					route = rec.route
+				}
+				if rec.status != 0 {
+					status = rec.status
+				}
+			}
+			code := strconv.Itoa(status)
+			requests.Inc(r.Method, route, code)
+			duration.ObserveDuration(time.Since(start), r.Method, route, code)
+		})
+	}
+}
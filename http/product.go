@@ -8,25 +8,39 @@ import (
 	"strconv"
 
 	"github.com/gorilla/mux"
+	"github.com/ortymid/t2-http/apikey"
 	"github.com/ortymid/t2-http/market"
 )
 
 // ProductHandler forwards product requests to the business logic.
 type ProductHandler struct {
+	auth   *Authenticator
 	market market.Interface
+	stream *ProductStream
+	socket *ProductSocket
 }
 
 func (h *ProductHandler) RegisterHandlers(r *mux.Router) {
-	r.HandleFunc("/", h.List).Methods(http.MethodGet)
-	r.HandleFunc("/", h.Create).Methods(http.MethodPost)
-	r.HandleFunc("/{id}", h.Detail).Methods(http.MethodGet)
-	r.HandleFunc("/{id}", h.Edit).Methods(http.MethodPut)
-	r.HandleFunc("/{id}", h.Delete).Methods(http.MethodDelete)
+	// The fixed paths must go before "/{id}" to not be taken for a product id.
+	if h.stream != nil {
+		r.Handle("/stream", h.auth.Anonymous().Then(h.stream)).Methods(http.MethodGet)
+	}
+	if h.socket != nil {
+		r.Handle("/ws", h.auth.RequiredScope(apikey.ScopeProductsRead).Then(h.socket)).Methods(http.MethodGet)
+	}
+	r.Handle("/export", h.auth.Anonymous().ThenFunc(h.Export)).Methods(http.MethodGet)
+	r.Handle("/import", h.auth.RequiredScopeStream(apikey.ScopeProductsWrite).ThenFunc(h.Import)).Methods(http.MethodPost)
+	r.Handle("/batch", h.auth.RequiredScope(apikey.ScopeProductsWrite).ThenFunc(h.Batch)).Methods(http.MethodPost)
+	r.Handle("/", h.auth.Anonymous().ThenFunc(h.List)).Methods(http.MethodGet)
+	r.Handle("/", h.auth.RequiredScope(apikey.ScopeProductsWrite).ThenFunc(h.Create)).Methods(http.MethodPost)
+	r.Handle("/{id}", h.auth.Anonymous().ThenFunc(h.Detail)).Methods(http.MethodGet)
+	r.Handle("/{id}", h.auth.RequiredScope(apikey.ScopeProductsWrite).ThenFunc(h.Edit)).Methods(http.MethodPut)
+	r.Handle("/{id}", h.auth.RequiredScope(apikey.ScopeProductsWrite).ThenFunc(h.Delete)).Methods(http.MethodDelete)
 }
 
 // List handles requests for all products.
 func (h *ProductHandler) List(w http.ResponseWriter, r *http.Request) {
-	products, err := h.market.Products()
+	products, err := h.market.Products(r.Context())
 	if err != nil {
 		writeError(w, http.StatusInternalServerError, err)
 		return
@@ -54,10 +68,10 @@ func (h *ProductHandler) Detail(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	product, err := h.market.Product(id)
+	product, err := h.market.Product(r.Context(), id)
 	if err != nil {
 		err = fmt.Errorf("getting product: %w", err)
-		writeError(w, http.StatusInternalServerError, err)
+		writeError(w, productErrorStatus(err), err)
 		return
 	}
 	if product == nil {
@@ -75,11 +89,7 @@ func (h *ProductHandler) Detail(w http.ResponseWriter, r *http.Request) {
 
 // Create handles requests for creation of new products.
 func (h *ProductHandler) Create(w http.ResponseWriter, r *http.Request) {
-	userID, ok := r.Context().Value(KeyUserID).(string)
-	if !ok {
-		writeError(w, http.StatusForbidden, errors.New("authorization required"))
-		return
-	}
+	userID := requestUserID(r)
 
 	data := struct {
 		Name  string `json:"name"`
@@ -93,13 +103,9 @@ func (h *ProductHandler) Create(w http.ResponseWriter, r *http.Request) {
 	}
 
 	product := &market.Product{Name: data.Name, Price: data.Price, Seller: userID}
-	product, err = h.market.AddProduct(product, userID)
+	product, err = h.market.AddProduct(r.Context(), product, userID)
 	if err != nil {
-		status := http.StatusInternalServerError
-		if errors.Is(err, &market.ErrPermission{}) {
-			status = http.StatusBadRequest
-		}
-		writeError(w, status, err)
+		writeError(w, productErrorStatus(err), err)
 		return
 	}
 	if product == nil {
@@ -117,19 +123,15 @@ func (h *ProductHandler) Create(w http.ResponseWriter, r *http.Request) {
 
 // Edit handles product edit requests.
 func (h *ProductHandler) Edit(w http.ResponseWriter, r *http.Request) {
-	userID, ok := r.Context().Value(KeyUserID).(string)
-	if !ok {
-		writeError(w, http.StatusForbidden, errors.New("authorization required"))
-		return
-	}
+	userID := requestUserID(r)
 
-	id, err := getVarProductID(r)
+	id, err := getVarID(r)
 	if err != nil {
 		writeError(w, http.StatusBadRequest, err)
 		return
 	}
 
-	product := &market.Product{ID: id}
+	product := &market.Product{ID: id, Seller: userID}
 
 	data := struct {
 		Name  string `json:"name"`
@@ -145,13 +147,9 @@ func (h *ProductHandler) Edit(w http.ResponseWriter, r *http.Request) {
 	product.Name = data.Name
 	product.Price = data.Price
 
-	product, err = h.market.ReplaceProduct(product, userID)
+	product, err = h.market.ReplaceProduct(r.Context(), product, userID)
 	if err != nil {
-		status := http.StatusInternalServerError
-		if errors.Is(err, &market.ErrPermission{}) || errors.Is(err, market.ErrProductNotFound) {
-			status = http.StatusBadRequest
-		}
-		writeError(w, status, err)
+		writeError(w, productErrorStatus(err), err)
 		return
 	}
 	if product == nil {
@@ -169,19 +167,15 @@ func (h *ProductHandler) Edit(w http.ResponseWriter, r *http.Request) {
 
 // Delete handles product delete requests.
 func (h *ProductHandler) Delete(w http.ResponseWriter, r *http.Request) {
-	userID, ok := r.Context().Value(KeyUserID).(string)
-	if !ok {
-		writeError(w, http.StatusForbidden, errors.New("authorization: token required"))
-		return
-	}
+	userID := requestUserID(r)
 
-	id, err := getVarProductID(r)
+	id, err := getVarID(r)
 	if err != nil {
 		writeError(w, http.StatusBadRequest, err)
 		return
 	}
 
-	// product, err := h.market.Product(id)
+	// product, err := h.market.Product(r.Context(), id)
 	// if err != nil {
 	// 	writeError(w, http.StatusInternalServerError, err)
 	// 	return
@@ -191,19 +185,95 @@ func (h *ProductHandler) Delete(w http.ResponseWriter, r *http.Request) {
 	// 	return
 	// }
 
-	err = h.market.DeleteProduct(id, userID)
+	err = h.market.DeleteProduct(r.Context(), id, userID)
 	if err != nil {
-		status := http.StatusInternalServerError
-		if errors.Is(err, &market.ErrPermission{}) || errors.Is(err, market.ErrProductNotFound) {
-			status = http.StatusBadRequest
-		}
-		writeError(w, status, err)
+		writeError(w, productErrorStatus(err), err)
 		return
 	}
 	w.WriteHeader(http.StatusNoContent)
 }
 
-func getVarProductID(r *http.Request) (int, error) {
+// maxBatchOperations limits the operations of a batch request.
+const maxBatchOperations = 1000
+
+// Batch handles requests for many product changes at once. The atomic batch
+// failing gets the status of the failed operation, the other one gets 200
+// with the status of every operation.
+func (h *ProductHandler) Batch(w http.ResponseWriter, r *http.Request) {
+	userID := requestUserID(r)
+
+	data := struct {
+		Atomic     bool `json:"atomic"`
+		Operations []struct {
+			Op    string `json:"op"`
+			ID    int    `json:"id"`
+			Name  string `json:"name"`
+			Price int    `json:"price"`
+		} `json:"operations"`
+	}{}
+	err := json.NewDecoder(r.Body).Decode(&data)
+	if err != nil {
+		err = fmt.Errorf("decoding batch request: %w", err)
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if len(data.Operations) == 0 || len(data.Operations) > maxBatchOperations {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("batch must have 1 to %d operations", maxBatchOperations))
+		return
+	}
+
+	ops := make([]market.Operation, len(data.Operations))
+	for i, op := range data.Operations {
+		if op.Op != market.OpCreate && op.Op != market.OpReplace && op.Op != market.OpDelete {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("operation %d: %w %q", i, market.ErrUnknownOperation, op.Op))
+			return
+		}
+		ops[i] = market.Operation{
+			Op:      op.Op,
+			Product: market.Product{ID: op.ID, Name: op.Name, Price: op.Price},
+		}
+	}
+
+	results, err := h.market.Batch(r.Context(), ops, userID, data.Atomic)
+	if err != nil {
+		writeError(w, productErrorStatus(err), err)
+		return
+	}
+
+	resp := batchResponse{Results: results}
+	status := http.StatusOK
+	if i := market.BatchFailed(results); data.Atomic && i >= 0 {
+		err = fmt.Errorf("operation %d: %w", i, results[i].Err)
+		if rec, ok := w.(*responseRecorder); ok {
+			rec.err = err
+		}
+		resp.Message = err.Error()
+		status = productErrorStatus(results[i].Err)
+	}
+	w.WriteHeader(status)
+	err = json.NewEncoder(w).Encode(resp)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+}
+
+func productErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, &market.ErrPermission{}):
+		return http.StatusForbidden
+	case errors.Is(err, market.ErrProductNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, market.ErrUnknownOperation):
+		return http.StatusBadRequest
+	case errors.Is(err, &market.ErrUnavailable{}):
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+func getVarID(r *http.Request) (int, error) {
 	vars := mux.Vars(r)
 	idString, ok := vars["id"]
 	if !ok {
@@ -277,3 +347,40 @@ func (r productEditReponse) MarshalJSON() ([]byte, error) {
 
 	return json.Marshal(respProduct(r))
 }
+
+type batchResponse struct {
+	Message string
+	Results []market.OperationResult
+}
+
+func (r batchResponse) MarshalJSON() ([]byte, error) {
+	type respProduct struct {
+		ID     int    `json:"id"`
+		Name   string `json:"name"`
+		Price  int    `json:"price"`
+		Seller string `json:"seller"`
+	}
+	type respResult struct {
+		Status  int          `json:"status"`
+		Product *respProduct `json:"product,omitempty"`
+		Error   string       `json:"error,omitempty"`
+	}
+
+	results := make([]respResult, len(r.Results))
+	for i, res := range r.Results {
+		switch {
+		case res.Err == nil:
+			p := respProduct(*res.Product)
+			results[i] = respResult{Status: http.StatusOK, Product: &p}
+		case errors.Is(res.Err, market.ErrBatchAborted):
+			results[i] = respResult{Status: http.StatusFailedDependency, Error: res.Err.Error()}
+		default:
+			results[i] = respResult{Status: productErrorStatus(res.Err), Error: res.Err.Error()}
+		}
+	}
+
+	return json.Marshal(struct {
+		Message string       `json:"message,omitempty"`
+		Results []respResult `json:"results"`
+	}{r.Message, results})
+}
@@ -0,0 +1,138 @@
+package http
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/ortymid/t2-http/logging"
+	"github.com/ortymid/t2-http/ratelimit"
+)
+
+var errTooManyRequests = errors.New("too many requests")
+
+// RateLimiter limits the requests of every client IP and of every user.
+// The reading and the writing requests have separate buckets.
+type RateLimiter struct {
+	Store ratelimit.Store
+	// Read limits the GET, HEAD and OPTIONS requests, Write the others.
+	// A zero limit lets everything in.
+	Read  ratelimit.Limit
+	Write ratelimit.Limit
+	// TrustedProxies are the networks of the proxies whose
+	// X-Forwarded-For header tells the client IP.
+	TrustedProxies []*net.IPNet
+}
+
+// limitIP rejects the requests of the client IP over the limit with 429.
+// It goes before the authentication, so the requests with bad credentials
+// are limited too. A nil RateLimiter lets everything in.
+func (l *RateLimiter) limitIP(h http.Handler) http.Handler {
+	if l == nil {
+		return h
+	}
+	return l.limit(h, func(r *http.Request) string {
+		return "ip:" + l.clientIP(r)
+	})
+}
+
+// limitUser rejects the requests of the user over the limit with 429.
+// It goes after the authentication and lets the anonymous requests in.
+// A nil RateLimiter lets everything in.
+func (l *RateLimiter) limitUser(h http.Handler) http.Handler {
+	if l == nil {
+		return h
+	}
+	return l.limit(h, func(r *http.Request) string {
+		if userID := requestUserID(r); userID != "" {
+			return "user:" + userID
+		}
+		return ""
+	})
+}
+
+// limit takes the request from the bucket of the key, no key lets it in.
+func (l *RateLimiter) limit(h http.Handler, key func(r *http.Request) string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		class, limit := "write", l.Write
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			class, limit = "read", l.Read
+		}
+		k := key(r)
+		if limit.Unlimited() || k == "" {
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		res, err := l.Store.Take(r.Context(), class+":"+k, limit)
+		if err != nil {
+			// Better serve too much than nothing while the store is down.
+			logging.FromContext(r.Context()).Warn("rate limit store failed", "error", err)
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		// The tighter of the IP and the user limits is reported.
+		header := w.Header()
+		if prev, err := strconv.Atoi(header.Get("RateLimit-Remaining")); err != nil || res.Remaining <= prev {
+			header.Set("RateLimit-Limit", strconv.Itoa(limit.Requests))
+			header.Set("RateLimit-Remaining", strconv.Itoa(res.Remaining))
+			header.Set("RateLimit-Reset", strconv.Itoa(ratelimit.Seconds(res.Reset)))
+		}
+		if !res.Allowed {
+			header.Set("Retry-After", strconv.Itoa(ratelimit.Seconds(res.RetryAfter)))
+			writeError(w, http.StatusTooManyRequests, errTooManyRequests)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// clientIP returns the address the request came from. Behind the trusted
+// proxies it is the last address of X-Forwarded-For not of a trusted proxy,
+// the earlier ones could be made up by the client.
+func (l *RateLimiter) clientIP(r *http.Request) string {
+	ip := remoteIP(r.RemoteAddr)
+	if !l.trusted(ip) {
+		return ip
+	}
+
+	forwarded := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		addr := strings.TrimSpace(forwarded[i])
+		if net.ParseIP(addr) == nil {
+			// Nothing before a malformed address can be trusted.
+			break
+		}
+		ip = addr
+		if !l.trusted(addr) {
+			break
+		}
+	}
+	return ip
+}
+
+func (l *RateLimiter) trusted(ip string) bool {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false
+	}
+	for _, n := range l.TrustedProxies {
+		if n.Contains(parsed) {
+			return true
+		}
+	}
+	return false
+}
+
+// remoteIP strips the port of the connection address.
+func remoteIP(addr string) string {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	return host
+}
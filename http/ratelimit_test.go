@@ -0,0 +1,132 @@
+package http
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ortymid/t2-http/health"
+	"github.com/ortymid/t2-http/market"
+	"github.com/ortymid/t2-http/ratelimit"
+	"github.com/ortymid/t2-http/service/mem"
+)
+
+func TestRouter_RateLimit(t *testing.T) {
+	p := &market.Product{ID: 1, Name: "p1", Price: 100, Seller: "1"}
+	h := NewRouter(RouterConfig{
+		Market: MockMarket{ProductRet: p, ReplaceProductRet: p},
+		JWT:    verifier,
+		Health: health.NewChecker(time.Second),
+		RateLimit: &RateLimiter{
+			Store: mem.NewRateLimitStore(),
+			Read:  ratelimit.Limit{Requests: 2, Period: time.Minute},
+			Write: ratelimit.Limit{Requests: 1, Period: time.Minute},
+		},
+	})
+	user1, user2 := "Bearer "+testToken(t, 1), "Bearer "+testToken(t, 2)
+
+	tests := []struct {
+		name          string
+		method        string
+		path          string
+		authorization string
+		remoteAddr    string
+		wantStatus    int
+		wantRemaining string
+	}{
+		{name: "Should count the anonymous reads", method: "GET", path: "/products/1", wantStatus: http.StatusOK, wantRemaining: "1"},
+		{name: "Should count the reads of the same IP", method: "GET", path: "/products/1", wantStatus: http.StatusOK, wantRemaining: "0"},
+		{name: "Should reject the reads over the limit", method: "GET", path: "/products/1", wantStatus: http.StatusTooManyRequests, wantRemaining: "0"},
+		{name: "Should count another IP apart", method: "GET", path: "/products/1", remoteAddr: "192.0.2.2:1234", wantStatus: http.StatusOK, wantRemaining: "1"},
+		{name: "Should not limit the health endpoints", method: "GET", path: "/healthz", wantStatus: http.StatusOK},
+		{name: "Should count the writes apart from the reads", method: "PUT", path: "/products/1", authorization: user1, wantStatus: http.StatusOK, wantRemaining: "0"},
+		{name: "Should reject the writes over the limit", method: "PUT", path: "/products/1", authorization: user1, wantStatus: http.StatusTooManyRequests, wantRemaining: "0"},
+		{name: "Should count the users apart", method: "PUT", path: "/products/1", authorization: user2, remoteAddr: "192.0.2.2:1234", wantStatus: http.StatusOK, wantRemaining: "0"},
+		{name: "Should limit the user from another IP", method: "PUT", path: "/products/1", authorization: user1, remoteAddr: "192.0.2.3:1234", wantStatus: http.StatusTooManyRequests, wantRemaining: "0"},
+		{name: "Should count the bad credentials", method: "PUT", path: "/products/1", authorization: "Bearer bad", remoteAddr: "192.0.2.4:1234", wantStatus: http.StatusUnauthorized, wantRemaining: "0"},
+		{name: "Should limit the bad credentials per IP", method: "PUT", path: "/products/1", authorization: "Bearer bad", remoteAddr: "192.0.2.4:1234", wantStatus: http.StatusTooManyRequests, wantRemaining: "0"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(tt.method, tt.path, strings.NewReader("{\"name\":\"p2\",\"price\":200}\n"))
+			if tt.authorization != "" {
+				r.Header.Add("Authorization", tt.authorization)
+			}
+			if tt.remoteAddr != "" {
+				r.RemoteAddr = tt.remoteAddr
+			}
+			h.ServeHTTP(w, r)
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("Status = %d, want %d", w.Code, tt.wantStatus)
+			}
+			if got := w.Header().Get("RateLimit-Remaining"); got != tt.wantRemaining {
+				t.Errorf("RateLimit-Remaining = %q, want %q", got, tt.wantRemaining)
+			}
+			wantRetry := ""
+			if tt.wantStatus == http.StatusTooManyRequests {
+				wantRetry = "30"
+				if tt.method == "PUT" {
+					wantRetry = "60"
+				}
+			}
+			if got := w.Header().Get("Retry-After"); got != wantRetry {
+				t.Errorf("Retry-After = %q, want %q", got, wantRetry)
+			}
+		})
+	}
+}
+
+func TestRateLimiter_clientIP(t *testing.T) {
+	_, proxies, _ := net.ParseCIDR("10.0.0.0/8")
+	l := &RateLimiter{TrustedProxies: []*net.IPNet{proxies}}
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		forwarded  []string
+		want       string
+	}{
+		{
+			name:       "Should take the connection address",
+			remoteAddr: "192.0.2.1:1234",
+			want:       "192.0.2.1",
+		},
+		{
+			name:       "Should ignore X-Forwarded-For of an untrusted peer",
+			remoteAddr: "192.0.2.1:1234",
+			forwarded:  []string{"198.51.100.1"},
+			want:       "192.0.2.1",
+		},
+		{
+			name:       "Should take the client behind the trusted proxies",
+			remoteAddr: "10.0.0.1:1234",
+			forwarded:  []string{"203.0.113.9, 198.51.100.1", "10.0.0.2"},
+			want:       "198.51.100.1",
+		},
+		{
+			name:       "Should stop at a malformed address",
+			remoteAddr: "10.0.0.1:1234",
+			forwarded:  []string{"198.51.100.1, garbage, 10.0.0.2"},
+			want:       "10.0.0.2",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/", nil)
+			r.RemoteAddr = tt.remoteAddr
+			for _, f := range tt.forwarded {
+				r.Header.Add("X-Forwarded-For", f)
+			}
+			if got := l.clientIP(r); got != tt.want {
+				t.Errorf("clientIP() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
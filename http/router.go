@@ -1,112 +1,182 @@
 package http
 
 import (
-	"context"
 	"encoding/json"
-	"errors"
 	"fmt"
-	"log"
 	"net/http"
-	"strconv"
-	"strings"
 
 	"github.com/gorilla/mux"
+	"github.com/ortymid/t2-http/apikey"
+	"github.com/ortymid/t2-http/health"
 	"github.com/ortymid/t2-http/jwt"
+	"github.com/ortymid/t2-http/logging"
 	"github.com/ortymid/t2-http/market"
+	"github.com/ortymid/t2-http/metrics"
+	"github.com/ortymid/t2-http/trace"
+	"github.com/ortymid/t2-http/user"
+	"github.com/ortymid/t2-http/webhook"
 )
 
 // type ProductsRequest struct{}
 
 type contextKey int
 
-const KeyUserID contextKey = 0
+const (
+	KeyUserID contextKey = iota
+	// KeyScopes holds the scopes of the API key the request is made with.
+	// Requests with a JWT do not have it.
+	KeyScopes
+)
+
+// HeaderAPIKey is an alternative to passing the API key as a bearer token.
+const HeaderAPIKey = "X-API-Key"
+
+// RouterConfig lists the services behind the endpoints.
+type RouterConfig struct {
+	Market market.Interface
+	// JWT verifies the bearer tokens.
+	JWT *jwt.Verifier
+
+	// Users enables the /users endpoints. May be nil.
+	Users user.Interface
+	// APIKeys enables the /apikeys endpoints and the API key authorization.
+	// May be nil.
+	APIKeys apikey.Interface
+	// Webhooks enables the /webhooks endpoints. May be nil.
+	Webhooks webhook.Interface
+	// Stream enables the /products/stream endpoint. May be nil.
+	Stream *ProductStream
+	// Socket enables the /products/ws endpoint. May be nil.
+	Socket *ProductSocket
+
+	// Logger writes the access log. Defaults to logging.Default().
+	Logger *logging.Logger
+	// Metrics enables the request metrics and the /metrics endpoint.
+	// May be nil.
+	Metrics *metrics.Registry
+	// Tracer starts the request spans. Defaults to trace.Default().
+	Tracer *trace.Tracer
+	// Health enables the /healthz and /readyz endpoints. May be nil.
+	Health *health.Checker
+	// RateLimit limits the requests of the routes. The /metrics
+	// and the health endpoints are not limited. May be nil.
+	RateLimit *RateLimiter
+	// Idempotency enables the Idempotency-Key of the POST requests.
+	// May be nil.
+	Idempotency *Idempotency
+}
+
+// HandlerGroup registers its handlers on the subrouter of its path prefix.
+type HandlerGroup interface {
+	RegisterHandlers(r *mux.Router)
+}
 
 // Router implements standard library http.Handler interface.
 // It acts as an entry point to the request handling.
 type Router struct {
-	Market    market.Interface
-	JWTAlg    string
-	JWTSecret interface{}
+	mux     *mux.Router
+	auth    *Authenticator
+	handler http.Handler
 }
 
-// ServeHTTP dispatches incoming http requests to specific handlers.
-func (rt *Router) ServeHTTP(w http.ResponseWriter, req *http.Request) {
-	// All responses are JSON-encoded.
-	w.Header().Add("Content-Type", "application/json")
-
-	// Leverage gorilla/mux.
-	router := mux.NewRouter()
-	rt.registerHandlers(router)
-
-	// Try to get a user id and set it to the request context.
-	// No user id means anonymous request.
-	req, err := rt.withUserID(req)
-	if err != nil {
-		err = fmt.Errorf("authorization: %w", err)
-		writeError(w, http.StatusForbidden, err)
-		return
+// NewRouter builds the routes of the configured services.
+func NewRouter(c RouterConfig) *Router {
+	logger := c.Logger
+	if logger == nil {
+		logger = logging.Default()
 	}
 
-	// Pass the request to the gorilla/mux router.
-	router.ServeHTTP(w, req)
-}
-
-func (rt *Router) registerHandlers(r *mux.Router) {
-	productHandler := &ProductHandler{
-		market: rt.Market,
+	rt := &Router{
+		// Leverage gorilla/mux.
+		mux: mux.NewRouter(),
+		auth: &Authenticator{
+			JWT:         c.JWT,
+			APIKeys:     c.APIKeys,
+			Limiter:     c.RateLimit,
+			Idempotency: c.Idempotency,
+		},
+	}
+	tracer := c.Tracer
+	if tracer == nil {
+		tracer = trace.Default()
 	}
-	s := r.PathPrefix("/products").Subrouter()
-	productHandler.RegisterHandlers(s)
-}
 
-// withUserID attaches a user ID obtained from the JWT to the request context.
-// getToken function defines where is the JWT expected to be found.
-func (rt *Router) withUserID(req *http.Request) (*http.Request, error) {
-	tokenString, err := getTokenString(req)
-	if err != nil {
-		return nil, err
+	rt.mux.Use(recordRoute)
+	mw := Chain(withRequestID(logger), logAccess, traceRequest(tracer))
+	// The operational endpoints take no credentials and are not rate limited,
+	// the probes and the scrapes must not be turned away.
+	if c.Metrics != nil {
+		mw = Chain(mw, measure(c.Metrics))
+		rt.mux.Handle("/metrics", c.Metrics).Methods(http.MethodGet)
 	}
-	if len(tokenString) == 0 {
-		return req, nil // ok, no token
+	if c.Health != nil {
+		live := func(r *http.Request) health.Report { return c.Health.Live(r.Context()) }
+		ready := func(r *http.Request) health.Report { return c.Health.Ready(r.Context()) }
+		rt.mux.Handle("/healthz", healthHandler(live)).Methods(http.MethodGet)
+		rt.mux.Handle("/readyz", healthHandler(ready)).Methods(http.MethodGet)
+	}
+	rt.handler = mw.Then(rt.mux)
+
+	rt.AddGroup("/products", &ProductHandler{
+		auth:   rt.auth,
+		market: c.Market,
+		stream: c.Stream,
+		socket: c.Socket,
+	})
+
+	if c.Users != nil {
+		rt.AddGroup("/users", &UserHandler{
+			auth:  rt.auth,
+			users: c.Users,
+		})
 	}
 
-	claims, err := jwt.Parse(tokenString, rt.JWTAlg, rt.JWTSecret)
-	if err != nil {
-		return nil, fmt.Errorf("parsing request token: %w", err)
+	if c.APIKeys != nil {
+		rt.AddGroup("/apikeys", &APIKeyHandler{
+			auth: rt.auth,
+			keys: c.APIKeys,
+		})
 	}
 
-	userID := strconv.Itoa(claims.UserID)
+	if c.Webhooks != nil {
+		rt.AddGroup("/webhooks", &WebhookHandler{
+			auth:     rt.auth,
+			webhooks: c.Webhooks,
+		})
+	}
 
-	ctx := req.Context()
-	ctx = context.WithValue(ctx, KeyUserID, userID)
-	return req.WithContext(ctx), nil
+	return rt
 }
 
-// getTokenString looks for the JWT in the Authorization header.
-// Absence of the token cosidered a normal case.
-func getTokenString(req *http.Request) (string, error) {
-	auth := req.Header.Get("Authorization")
-	if len(auth) == 0 {
-		return "", nil // ok, no token
-	}
+// AddGroup registers further handlers under the path prefix.
+// It must not be called once the router serves requests.
+func (rt *Router) AddGroup(prefix string, g HandlerGroup) {
+	g.RegisterHandlers(rt.mux.PathPrefix(prefix).Subrouter())
+}
 
-	authFields := strings.Fields(auth)
-	if len(authFields) != 2 {
-		return "", errors.New("malformed Authorization header")
-	}
+// Authenticator returns the middleware for the routes of the added groups.
+func (rt *Router) Authenticator() *Authenticator {
+	return rt.auth
+}
 
-	typ := authFields[0]
-	if !strings.EqualFold(typ, "Bearer") {
-		return "", errors.New("Authorization type is not Bearer")
-	}
+// ServeHTTP dispatches incoming http requests to specific handlers.
+func (rt *Router) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	// All responses are JSON-encoded.
+	w.Header().Add("Content-Type", "application/json")
 
-	token := authFields[1]
-	return token, nil
+	// Pass the request to the gorilla/mux router through the logging.
+	// The routes authenticate the requests as they need.
+	rt.handler.ServeHTTP(w, req)
 }
 
 // writeError writes an error to the response as a JSON-encoded string.
+// The error goes to the access log of the request.
 func writeError(w http.ResponseWriter, status int, err error) {
-	log.Println("ERROR:", err)
+	if rec, ok := w.(*responseRecorder); ok {
+		rec.err = err
+	} else {
+		logging.Default().Error("request failed", "status", status, "error", err)
+	}
 
 	payload := struct {
 		Message string `json:"message"`
@@ -118,6 +188,6 @@ func writeError(w http.ResponseWriter, status int, err error) {
 	err = json.NewEncoder(w).Encode(payload)
 	if err != nil {
 		err = fmt.Errorf("encoding error: %w", err)
-		log.Println("ERROR:", err)
+		logging.Default().Error("writing error response", "error", err)
 	}
 }
@@ -2,22 +2,40 @@ package http
 
 import (
 	"bytes"
+	"context"
 	"crypto/rand"
 	"crypto/rsa"
+	"encoding/json"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/dgrijalva/jwt-go"
+	"github.com/ortymid/t2-http/apikey"
+	"github.com/ortymid/t2-http/health"
+	jwtverifier "github.com/ortymid/t2-http/jwt"
+	"github.com/ortymid/t2-http/logging"
 	"github.com/ortymid/t2-http/market"
+	"github.com/ortymid/t2-http/metrics"
+	"github.com/ortymid/t2-http/service/mem"
+	traceservice "github.com/ortymid/t2-http/service/trace"
+	"github.com/ortymid/t2-http/trace"
+	"github.com/ortymid/t2-http/user"
+	"github.com/ortymid/t2-http/webhook"
+	"golang.org/x/crypto/bcrypt"
 )
 
-var key *rsa.PrivateKey
+var (
+	key      *rsa.PrivateKey
+	verifier *jwtverifier.Verifier
+)
 
 func init() {
 	key, _ = rsa.GenerateKey(rand.Reader, 2048)
+	verifier, _ = jwtverifier.NewVerifier(map[string]interface{}{"RS256": &key.PublicKey})
 }
 
 type MockMarket struct {
@@ -30,33 +48,42 @@ type MockMarket struct {
 	ReplaceProductRet *market.Product
 	ReplaceProductErr error
 	DeleteProductErr  error
+	BatchRet          []market.OperationResult
+	BatchErr          error
 }
 
-func (m MockMarket) Products() ([]*market.Product, error) {
+func (m MockMarket) Products(ctx context.Context) ([]*market.Product, error) {
 	return m.ProductsRet, m.ProductsErr
 }
 
-func (m MockMarket) Product(id int) (*market.Product, error) {
+func (m MockMarket) Product(ctx context.Context, id int) (*market.Product, error) {
 	return m.ProductRet, m.ProductErr
 }
 
-func (m MockMarket) AddProduct(p *market.Product, userID string) (*market.Product, error) {
+func (m MockMarket) AddProduct(ctx context.Context, p *market.Product, userID string) (*market.Product, error) {
 	return m.AddProductRet, m.AddProductErr
 }
 
-func (m MockMarket) ReplaceProduct(p *market.Product, userID string) (*market.Product, error) {
+func (m MockMarket) ReplaceProduct(ctx context.Context, p *market.Product, userID string) (*market.Product, error) {
 	return m.ReplaceProductRet, m.ReplaceProductErr
 }
 
-func (m MockMarket) DeleteProduct(id int, userID string) error {
+func (m MockMarket) DeleteProduct(ctx context.Context, id int, userID string) error {
 	return m.DeleteProductErr
 }
 
+func (m MockMarket) Batch(ctx context.Context, ops []market.Operation, userID string, atomic bool) ([]market.OperationResult, error) {
+	return m.BatchRet, m.BatchErr
+}
+
+func (m MockMarket) CheckBatch(ctx context.Context, ops []market.Operation, userID string) ([]market.OperationResult, error) {
+	return m.BatchRet, m.BatchErr
+}
+
 func TestRouter_ServeHTTP(t *testing.T) {
 	type fields struct {
-		Market    market.Interface
-		JWTAlg    string
-		JWTSecret interface{}
+		Market market.Interface
+		JWT    *jwtverifier.Verifier
 	}
 	tests := []struct {
 		name       string
@@ -73,8 +100,7 @@ func TestRouter_ServeHTTP(t *testing.T) {
 						{ID: 1, Name: "p1", Price: 100, Seller: "1"},
 					},
 				},
-				JWTAlg:    "RS256",
-				JWTSecret: key.Public(),
+				JWT: verifier,
 			},
 			req: func() *http.Request {
 				return httptest.NewRequest("GET", "/products/", nil)
@@ -88,8 +114,7 @@ func TestRouter_ServeHTTP(t *testing.T) {
 				Market: MockMarket{
 					ProductRet: &market.Product{ID: 1, Name: "p1", Price: 100, Seller: "1"},
 				},
-				JWTAlg:    "RS256",
-				JWTSecret: key.Public(),
+				JWT: verifier,
 			},
 			req: func() *http.Request {
 				return httptest.NewRequest("GET", "/products/1", nil)
@@ -103,8 +128,7 @@ func TestRouter_ServeHTTP(t *testing.T) {
 				Market: MockMarket{
 					AddProductRet: &market.Product{ID: 1, Name: "p1", Price: 100, Seller: "1"},
 				},
-				JWTAlg:    "RS256",
-				JWTSecret: key.Public(),
+				JWT: verifier,
 			},
 			req: func() *http.Request {
 				r := httptest.NewRequest("POST", "/products/", strings.NewReader("{\"id\":1,\"name\":\"p1\",\"price\":100}\n"))
@@ -120,8 +144,7 @@ func TestRouter_ServeHTTP(t *testing.T) {
 				Market: MockMarket{
 					ReplaceProductRet: &market.Product{ID: 1, Name: "p2", Price: 200, Seller: "1"},
 				},
-				JWTAlg:    "RS256",
-				JWTSecret: key.Public(),
+				JWT: verifier,
 			},
 			req: func() *http.Request {
 				r := httptest.NewRequest("PUT", "/products/1", strings.NewReader("{\"name\":\"p2\",\"price\":200}\n"))
@@ -137,8 +160,7 @@ func TestRouter_ServeHTTP(t *testing.T) {
 				Market: MockMarket{
 					ProductRet: &market.Product{ID: 1, Name: "p1", Price: 100, Seller: "1"},
 				},
-				JWTAlg:    "RS256",
-				JWTSecret: key.Public(),
+				JWT: verifier,
 			},
 			req: func() *http.Request {
 				r := httptest.NewRequest("DELETE", "/products/1", nil)
@@ -151,11 +173,10 @@ func TestRouter_ServeHTTP(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			h := &Router{
-				Market:    tt.fields.Market,
-				JWTAlg:    tt.fields.JWTAlg,
-				JWTSecret: tt.fields.JWTSecret,
-			}
+			h := NewRouter(RouterConfig{
+				Market: tt.fields.Market,
+				JWT:    tt.fields.JWT,
+			})
 
 			w := httptest.NewRecorder()
 			r := tt.req()
@@ -181,3 +202,701 @@ func testToken(t *testing.T, userID int) string {
 	}
 	return tokenString
 }
+
+func TestRouter_Webhooks(t *testing.T) {
+	h := NewRouter(RouterConfig{
+		Market:   MockMarket{},
+		JWT:      verifier,
+		Webhooks: &webhook.Manager{Store: mem.NewWebhookStore()},
+	})
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("POST", "/webhooks/", strings.NewReader("{\"url\":\"http://203.0.113.10/hook\",\"secret\":\"s\"}\n"))
+	r.Header.Add("Authorization", "Bearer "+testToken(t, 1))
+	h.ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Errorf("%s Status = %d, want %d", r.URL.Path, w.Code, http.StatusOK)
+	}
+
+	w = httptest.NewRecorder()
+	r = httptest.NewRequest("GET", "/webhooks/", nil)
+	r.Header.Add("Authorization", "Bearer "+testToken(t, 2))
+	h.ServeHTTP(w, r)
+	if body := w.Body.String(); body != "[]\n" {
+		t.Errorf("%s Body = %q, want %q", r.URL.Path, body, "[]\n")
+	}
+
+	w = httptest.NewRecorder()
+	r = httptest.NewRequest("GET", "/webhooks/1", nil)
+	r.Header.Add("Authorization", "Bearer "+testToken(t, 2))
+	h.ServeHTTP(w, r)
+	if w.Code != http.StatusForbidden {
+		t.Errorf("%s Status = %d, want %d", r.URL.Path, w.Code, http.StatusForbidden)
+	}
+}
+
+func TestRouter_APIKeys(t *testing.T) {
+	h := NewRouter(RouterConfig{
+		Market:   MockMarket{},
+		JWT:      verifier,
+		APIKeys:  &apikey.Manager{Store: mem.NewAPIKeyStore()},
+		Webhooks: &webhook.Manager{Store: mem.NewWebhookStore()},
+	})
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("POST", "/apikeys/", strings.NewReader("{\"name\":\"batch\",\"scopes\":[\"products:write\"]}\n"))
+	r.Header.Add("Authorization", "Bearer "+testToken(t, 1))
+	h.ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("%s Status = %d, want %d", r.URL.Path, w.Code, http.StatusOK)
+	}
+	created := struct {
+		Token string `json:"token"`
+	}{}
+	if err := json.NewDecoder(w.Body).Decode(&created); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		header string
+		value  string
+		want   int
+	}{
+		{
+			name:   "Should accept the key as a bearer token",
+			method: "DELETE", path: "/products/1",
+			header: "Authorization", value: "Bearer " + created.Token,
+			want: http.StatusNoContent,
+		},
+		{
+			name:   "Should accept the key in X-API-Key",
+			method: "DELETE", path: "/products/1",
+			header: HeaderAPIKey, value: created.Token,
+			want: http.StatusNoContent,
+		},
+		{
+			name:   "Should reject an unknown key",
+			method: "DELETE", path: "/products/1",
+			header: HeaderAPIKey, value: created.Token + "x",
+			want: http.StatusUnauthorized,
+		},
+		{
+			name:   "Should reject a request outside of the key scopes",
+			method: "GET", path: "/webhooks/",
+			header: HeaderAPIKey, value: created.Token,
+			want: http.StatusForbidden,
+		},
+		{
+			name:   "Should not let the key manage the keys",
+			method: "GET", path: "/apikeys/",
+			header: HeaderAPIKey, value: created.Token,
+			want: http.StatusForbidden,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(tt.method, tt.path, nil)
+			r.Header.Add(tt.header, tt.value)
+			h.ServeHTTP(w, r)
+			if w.Code != tt.want {
+				t.Errorf("%s Status = %d, want %d", r.URL.Path, w.Code, tt.want)
+			}
+		})
+	}
+
+	w = httptest.NewRecorder()
+	r = httptest.NewRequest("GET", "/apikeys/", nil)
+	r.Header.Add("Authorization", "Bearer "+testToken(t, 1))
+	h.ServeHTTP(w, r)
+	if body := w.Body.String(); !strings.Contains(body, "\"last_used_at\":\"") {
+		t.Errorf("%s Body = %q, want the last use recorded", r.URL.Path, body)
+	}
+}
+
+func TestRouter_Users(t *testing.T) {
+	h := NewRouter(RouterConfig{
+		Market: MockMarket{},
+		JWT:    verifier,
+		Users: &user.Service{
+			Store:      mem.NewAccountStore(),
+			Issuer:     &jwtverifier.Issuer{Alg: "RS256", Key: key},
+			BcryptCost: bcrypt.MinCost,
+		},
+	})
+
+	// The steps run in order on the same accounts.
+	tests := []struct {
+		name       string
+		method     string
+		path       string
+		userID     int // the token of the user, none if 0
+		body       string
+		wantStatus int
+		wantBody   string // a part of the body
+	}{
+		{
+			name:   "Should register an account",
+			method: "POST", path: "/users/",
+			body:       `{"username":"alice","password":"password1"}`,
+			wantStatus: http.StatusOK,
+			wantBody:   `"id":"1","username":"alice"`,
+		},
+		{
+			name:   "Should not register a taken username",
+			method: "POST", path: "/users/",
+			body:       `{"username":"ALICE","password":"password1"}`,
+			wantStatus: http.StatusConflict,
+		},
+		{
+			name:   "Should not register a short password",
+			method: "POST", path: "/users/",
+			body:       `{"username":"bob","password":"short"}`,
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:   "Should reject a malformed register request",
+			method: "POST", path: "/users/",
+			body:       `{"username":`,
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:   "Should not log in with a wrong password",
+			method: "POST", path: "/users/login",
+			body:       `{"username":"alice","password":"password2"}`,
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:   "Should log in",
+			method: "POST", path: "/users/login",
+			body:       `{"username":"alice","password":"password1"}`,
+			wantStatus: http.StatusOK,
+			wantBody:   `{"token":"`,
+		},
+		{
+			name:   "Should not show the profile without a token",
+			method: "GET", path: "/users/me",
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:   "Should show the profile",
+			method: "GET", path: "/users/me",
+			userID:     1,
+			wantStatus: http.StatusOK,
+			wantBody:   `"id":"1","username":"alice"`,
+		},
+		{
+			name:   "Should not show the profile of an unknown user",
+			method: "GET", path: "/users/me",
+			userID:     9,
+			wantStatus: http.StatusNotFound,
+		},
+		{
+			name:   "Should edit the profile",
+			method: "PUT", path: "/users/me",
+			userID:     1,
+			body:       `{"username":"alicia"}`,
+			wantStatus: http.StatusOK,
+			wantBody:   `"id":"1","username":"alicia"`,
+		},
+		{
+			name:   "Should not edit the profile with an invalid username",
+			method: "PUT", path: "/users/me",
+			userID:     1,
+			body:       `{"username":"a!"}`,
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:   "Should log in with the new username",
+			method: "POST", path: "/users/login",
+			body:       `{"username":"alicia","password":"password1"}`,
+			wantStatus: http.StatusOK,
+			wantBody:   `{"token":"`,
+		},
+		{
+			name:   "Should not change the password without the current one",
+			method: "PUT", path: "/users/me",
+			userID:     1,
+			body:       `{"password":"password2"}`,
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:   "Should not change the password with a wrong current one",
+			method: "PUT", path: "/users/me",
+			userID:     1,
+			body:       `{"password":"password2","current_password":"password3"}`,
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name:   "Should change the password with the current one",
+			method: "PUT", path: "/users/me",
+			userID:     1,
+			body:       `{"password":"password2","current_password":"password1"}`,
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:   "Should log in with the new password",
+			method: "POST", path: "/users/login",
+			body:       `{"username":"alicia","password":"password2"}`,
+			wantStatus: http.StatusOK,
+			wantBody:   `{"token":"`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+			if tt.userID != 0 {
+				r.Header.Add("Authorization", "Bearer "+testToken(t, tt.userID))
+			}
+			h.ServeHTTP(w, r)
+			if w.Code != tt.wantStatus {
+				t.Errorf("%s Status = %d, want %d", r.URL.Path, w.Code, tt.wantStatus)
+			}
+			if !strings.Contains(w.Body.String(), tt.wantBody) {
+				t.Errorf("%s Body = %q, want it to contain %q", r.URL.Path, w.Body.String(), tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestRouter_InvalidToken(t *testing.T) {
+	v, _ := jwtverifier.NewVerifier(map[string]interface{}{"RS256": &key.PublicKey})
+	v.Audience = "market"
+	h := NewRouter(RouterConfig{
+		Market: MockMarket{},
+		JWT:    v,
+	})
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("DELETE", "/products/1", nil)
+	r.Header.Add("Authorization", "Bearer "+testToken(t, 1))
+	h.ServeHTTP(w, r)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("%s Status = %d, want %d", r.URL.Path, w.Code, http.StatusUnauthorized)
+	}
+	if body := w.Body.String(); !strings.Contains(body, "aud check failed") {
+		t.Errorf("%s Body = %q, want the failed check named", r.URL.Path, body)
+	}
+}
+
+func TestRouter_AuthStatus(t *testing.T) {
+	keys := &apikey.Manager{Store: mem.NewAPIKeyStore()}
+	_, readToken, err := keys.AddKey(context.Background(), &apikey.Key{Scopes: []string{apikey.ScopeProductsRead}}, "1")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expired := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{"id": 1, "exp": 1})
+	expiredToken, _ := expired.SignedString(key)
+
+	tests := []struct {
+		name          string
+		market        MockMarket
+		method        string
+		path          string
+		authorization string
+		wantStatus    int
+		wantChallenge string
+	}{
+		{
+			name:          "Should challenge a request without a token",
+			method:        "POST",
+			path:          "/products/",
+			wantStatus:    http.StatusUnauthorized,
+			wantChallenge: `Bearer realm="market"`,
+		},
+		{
+			name:          "Should reject an invalid token",
+			method:        "DELETE",
+			path:          "/products/1",
+			authorization: "Bearer " + testToken(t, 1) + "x",
+			wantStatus:    http.StatusUnauthorized,
+			wantChallenge: `Bearer realm="market", error="invalid_token"`,
+		},
+		{
+			name:          "Should reject an expired token",
+			method:        "DELETE",
+			path:          "/products/1",
+			authorization: "Bearer " + expiredToken,
+			wantStatus:    http.StatusUnauthorized,
+			wantChallenge: `Bearer realm="market", error="invalid_token", error_description="parsing token: invalid token claims: exp check failed: token is expired"`,
+		},
+		{
+			name:          "Should reject a malformed Authorization header",
+			method:        "DELETE",
+			path:          "/products/1",
+			authorization: "Basic dXNlcjpwYXNz",
+			wantStatus:    http.StatusBadRequest,
+			wantChallenge: `Bearer realm="market", error="invalid_request"`,
+		},
+		{
+			name:          "Should ignore the Authorization header on anonymous routes",
+			method:        "GET",
+			path:          "/products/",
+			authorization: "Basic dXNlcjpwYXNz",
+			wantStatus:    http.StatusOK,
+		},
+		{
+			name:          "Should reject an API key without the scope",
+			method:        "DELETE",
+			path:          "/products/1",
+			authorization: "Bearer " + readToken,
+			wantStatus:    http.StatusForbidden,
+			wantChallenge: `Bearer realm="market", error="insufficient_scope"`,
+		},
+		{
+			name:          "Should forbid changing another user's product",
+			market:        MockMarket{DeleteProductErr: &market.ErrPermission{}},
+			method:        "DELETE",
+			path:          "/products/1",
+			authorization: "Bearer " + testToken(t, 2),
+			wantStatus:    http.StatusForbidden,
+		},
+		{
+			name:          "Should report a missing product",
+			market:        MockMarket{DeleteProductErr: market.ErrProductNotFound},
+			method:        "DELETE",
+			path:          "/products/1",
+			authorization: "Bearer " + testToken(t, 1),
+			wantStatus:    http.StatusNotFound,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := NewRouter(RouterConfig{
+				Market:  tt.market,
+				JWT:     verifier,
+				APIKeys: keys,
+			})
+
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(tt.method, tt.path, nil)
+			if tt.authorization != "" {
+				r.Header.Add("Authorization", tt.authorization)
+			}
+			h.ServeHTTP(w, r)
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("%s Status = %d, want %d", r.URL.Path, w.Code, tt.wantStatus)
+			}
+			challenge := w.Header().Get("WWW-Authenticate")
+			if !strings.HasPrefix(challenge, tt.wantChallenge) || (tt.wantChallenge == "" && challenge != "") {
+				t.Errorf("%s WWW-Authenticate = %q, want %q", r.URL.Path, challenge, tt.wantChallenge)
+			}
+		})
+	}
+}
+
+func TestAuthenticator_Optional(t *testing.T) {
+	a := &Authenticator{JWT: verifier}
+	h := a.Optional().ThenFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(requestUserID(r)))
+	})
+
+	tests := []struct {
+		name          string
+		authorization string
+		wantStatus    int
+		wantBody      string
+	}{
+		{name: "Should let anonymous requests through", wantStatus: http.StatusOK, wantBody: ""},
+		{name: "Should authenticate the user", authorization: "Bearer " + testToken(t, 7), wantStatus: http.StatusOK, wantBody: "7"},
+		{name: "Should reject invalid credentials", authorization: "Bearer x", wantStatus: http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest("GET", "/", nil)
+			if tt.authorization != "" {
+				r.Header.Add("Authorization", tt.authorization)
+			}
+			h.ServeHTTP(w, r)
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("Status = %d, want %d", w.Code, tt.wantStatus)
+			}
+			if tt.wantStatus == http.StatusOK && w.Body.String() != tt.wantBody {
+				t.Errorf("Body = %q, want %q", w.Body.String(), tt.wantBody)
+			}
+		})
+	}
+}
+
+func BenchmarkRouter_ServeHTTP(b *testing.B) {
+	config := RouterConfig{
+		Market: MockMarket{
+			ProductRet: &market.Product{ID: 1, Name: "p1", Price: 100, Seller: "1"},
+		},
+		JWT:      verifier,
+		APIKeys:  &apikey.Manager{Store: mem.NewAPIKeyStore()},
+		Webhooks: &webhook.Manager{Store: mem.NewWebhookStore()},
+		Logger:   logging.New(ioutil.Discard, logging.LevelInfo),
+	}
+
+	// The routes used to be built for every request.
+	b.Run("built per request", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest("GET", "/products/1", nil)
+			NewRouter(config).ServeHTTP(w, r)
+		}
+	})
+
+	b.Run("built once", func(b *testing.B) {
+		h := NewRouter(config)
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest("GET", "/products/1", nil)
+			h.ServeHTTP(w, r)
+		}
+	})
+}
+
+func TestRouter_AccessLog(t *testing.T) {
+	var buf bytes.Buffer
+	h := NewRouter(RouterConfig{
+		Market: MockMarket{},
+		JWT:    verifier,
+		Logger: logging.New(&buf, logging.LevelInfo),
+	})
+
+	t.Run("Should propagate the request ID", func(t *testing.T) {
+		buf.Reset()
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("DELETE", "/products/1", nil)
+		r.Header.Add("Authorization", "Bearer "+testToken(t, 1))
+		r.Header.Add(logging.HeaderRequestID, "req-1")
+		h.ServeHTTP(w, r)
+
+		if id := w.Header().Get(logging.HeaderRequestID); id != "req-1" {
+			t.Errorf("%s = %q, want %q", logging.HeaderRequestID, id, "req-1")
+		}
+
+		entry := map[string]interface{}{}
+		if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+			t.Fatalf("access log %q: %v", buf.String(), err)
+		}
+		want := map[string]interface{}{
+			"msg":        "request",
+			"request_id": "req-1",
+			"method":     "DELETE",
+			"route":      "/products/{id}",
+			"status":     float64(http.StatusNoContent),
+			"user_id":    "1",
+		}
+		for k, v := range want {
+			if entry[k] != v {
+				t.Errorf("access log %s = %v, want %v", k, entry[k], v)
+			}
+		}
+	})
+
+	t.Run("Should generate a request ID", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", "/products/", nil)
+		r.Header.Add(logging.HeaderRequestID, "bad id\n")
+		h.ServeHTTP(w, r)
+
+		if id := w.Header().Get(logging.HeaderRequestID); len(id) != 32 {
+			t.Errorf("%s = %q, want a generated one", logging.HeaderRequestID, id)
+		}
+	})
+
+	t.Run("Should log the errors", func(t *testing.T) {
+		buf.Reset()
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("POST", "/products/", nil)
+		h.ServeHTTP(w, r)
+
+		if !strings.Contains(buf.String(), `"error":"authorization required"`) {
+			t.Errorf("access log = %s, want the error", buf.String())
+		}
+	})
+}
+
+func TestRouter_Metrics(t *testing.T) {
+	h := NewRouter(RouterConfig{
+		Market:  MockMarket{},
+		JWT:     verifier,
+		Logger:  logging.Nop(),
+		Metrics: metrics.NewRegistry(),
+	})
+
+	for _, path := range []string{"/products/", "/products/", "/nowhere"} {
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", path, nil))
+	}
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Status = %d, want %d", w.Code, http.StatusOK)
+	}
+	for _, want := range []string{
+		`http_requests_total{method="GET",route="/products/",status="200"} 2`,
+		`http_requests_total{method="GET",route="unmatched",status="404"} 1`,
+		`http_request_duration_seconds_count{method="GET",route="/products/",status="200"} 2`,
+	} {
+		if !strings.Contains(w.Body.String(), want) {
+			t.Errorf("Body = %s, want %s", w.Body.String(), want)
+		}
+	}
+}
+
+func TestRouter_Trace(t *testing.T) {
+	exporter := &trace.MemoryExporter{}
+	var buf bytes.Buffer
+	h := NewRouter(RouterConfig{
+		Market: &market.Market{
+			UserService:    traceservice.NewUserService(mem.NewUserService()),
+			ProductService: traceservice.NewProductService(mem.NewProductService()),
+			Logger:         logging.Nop(),
+		},
+		JWT:    verifier,
+		Logger: logging.New(&buf, logging.LevelInfo),
+		Tracer: trace.NewTracer(exporter),
+	})
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("POST", "/products/", strings.NewReader("{\"name\":\"p1\",\"price\":100}\n"))
+	r.Header.Add("Authorization", "Bearer "+testToken(t, 1))
+	r.Header.Add(trace.HeaderTraceparent, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	h.ServeHTTP(w, r)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Status = %d, want %d", w.Code, http.StatusOK)
+	}
+
+	// The spans end from the innermost one.
+	spans := exporter.Spans()
+	want := []struct {
+		name   string
+		parent int
+	}{
+		{name: "UserService.User", parent: 2},
+		{name: "ProductService.AddProduct", parent: 2},
+		{name: "Market.AddProduct", parent: 3},
+		{name: "POST /products/", parent: -1},
+	}
+	if len(spans) != len(want) {
+		t.Fatalf("spans = %d, want %d", len(spans), len(want))
+	}
+	for i, w := range want {
+		s := spans[i]
+		if s.Name != w.name {
+			t.Errorf("span %d name = %q, want %q", i, s.Name, w.name)
+		}
+		if s.TraceID.String() != "4bf92f3577b34da6a3ce929d0e0e4736" {
+			t.Errorf("span %q trace = %s, want the one of the traceparent", s.Name, s.TraceID)
+		}
+		parent := "00f067aa0ba902b7"
+		if w.parent >= 0 {
+			parent = spans[w.parent].SpanID.String()
+		}
+		if s.ParentID == nil || s.ParentID.String() != parent {
+			t.Errorf("span %q parent = %v, want %s", s.Name, s.ParentID, parent)
+		}
+	}
+	if got := spans[3].Attributes["http.status_code"]; got != http.StatusOK {
+		t.Errorf("http.status_code = %v, want %d", got, http.StatusOK)
+	}
+
+	if !strings.Contains(buf.String(), `"trace_id":"4bf92f3577b34da6a3ce929d0e0e4736"`) {
+		t.Errorf("access log = %s, want the trace ID", buf.String())
+	}
+}
+
+func TestRouter_Health(t *testing.T) {
+	checker := health.NewChecker(time.Second)
+	checker.Register("products", func(ctx context.Context) error { return nil })
+	h := NewRouter(RouterConfig{
+		Market: MockMarket{},
+		JWT:    verifier,
+		Logger: logging.Nop(),
+		Health: checker,
+	})
+
+	probe := func(path string) (int, health.Report) {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		var rep health.Report
+		if err := json.Unmarshal(w.Body.Bytes(), &rep); err != nil {
+			t.Fatalf("%s body %q: %v", path, w.Body.String(), err)
+		}
+		return w.Code, rep
+	}
+
+	if code, rep := probe("/readyz"); code != http.StatusOK || rep.Components["products"].Status != health.StatusOK {
+		t.Errorf("/readyz = %d %+v, want ready", code, rep)
+	}
+
+	checker.Shutdown()
+
+	if code, rep := probe("/readyz"); code != http.StatusServiceUnavailable || rep.Status != health.StatusFail {
+		t.Errorf("/readyz = %d %+v, want not ready during shutdown", code, rep)
+	}
+	if code, rep := probe("/healthz"); code != http.StatusOK || rep.Status != health.StatusOK {
+		t.Errorf("/healthz = %d %+v, want alive during shutdown", code, rep)
+	}
+}
+
+func TestRouter_Batch(t *testing.T) {
+	tests := []struct {
+		name       string
+		body       string
+		wantStatus int
+		wantBody   string
+	}{
+		{
+			name:       "Should respond with the result of every operation",
+			body:       `{"operations": [{"op": "create", "name": "Apple", "price": 100}, {"op": "delete", "id": 2}]}`,
+			wantStatus: http.StatusOK,
+			wantBody:   `{"results":[{"status":200,"product":{"id":3,"name":"Apple","price":100,"seller":"1"}},{"status":403,"error":"delete product: permission denied: product of another seller"}]}` + "\n",
+		},
+		{
+			name:       "Should respond with the status of the failed atomic operation",
+			body:       `{"atomic": true, "operations": [{"op": "replace", "id": 1, "name": "Banana", "price": 1}, {"op": "delete", "id": 9}]}`,
+			wantStatus: http.StatusNotFound,
+			wantBody:   `{"message":"operation 1: delete product: product not found","results":[{"status":424,"error":"not applied, another operation of the batch failed"},{"status":404,"error":"delete product: product not found"}]}` + "\n",
+		},
+		{
+			name:       "Should reject an unknown operation",
+			body:       `{"operations": [{"op": "update", "id": 1}]}`,
+			wantStatus: http.StatusBadRequest,
+			wantBody:   `{"message":"operation 0: unknown operation \"update\""}` + "\n",
+		},
+		{
+			name:       "Should reject an empty batch",
+			body:       `{"operations": []}`,
+			wantStatus: http.StatusBadRequest,
+			wantBody:   `{"message":"batch must have 1 to 1000 operations"}` + "\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := NewRouter(RouterConfig{
+				Market: &market.Market{UserService: mem.NewUserService(), ProductService: mem.NewProductService()},
+				JWT:    verifier,
+			})
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest("POST", "/products/batch", strings.NewReader(tt.body))
+			r.Header.Add("Authorization", "Bearer "+testToken(t, 1))
+			h.ServeHTTP(w, r)
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("Status = %d, want %d", w.Code, tt.wantStatus)
+			}
+			if w.Body.String() != tt.wantBody {
+				t.Errorf("Body = %s, want %s", w.Body.String(), tt.wantBody)
+			}
+		})
+	}
+}
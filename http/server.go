@@ -2,48 +2,138 @@ package http
 
 import (
 	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
+	"time"
 
-	"github.com/ortymid/t2-http/market"
+	"github.com/ortymid/t2-http/logging"
 )
 
-// Run is a convenient function to start an http server with graceful shotdown.
-func Run(port int, jwtAlg string, jwtSecret interface{}, m market.Interface) {
-	handler := &Router{
-		Market:    m,
-		JWTAlg:    jwtAlg,
-		JWTSecret: jwtSecret,
+// ServerConfig limits the connections of the server.
+// The zero timeouts mean no limit.
+type ServerConfig struct {
+	// Addr is the address to listen on, e.g. ":8080".
+	Addr string
+
+	ReadHeaderTimeout time.Duration
+	// ReadTimeout limits reading the whole request including the body.
+	ReadTimeout time.Duration
+	// WriteTimeout limits the whole response, so it cuts the product stream.
+	WriteTimeout time.Duration
+	IdleTimeout  time.Duration
+	// MaxHeaderBytes limits the request headers, the default is 1 MB.
+	MaxHeaderBytes int
+
+	// ShutdownTimeout limits waiting for the requests in flight
+	// on shutdown, then the connections are closed.
+	ShutdownTimeout time.Duration
+
+	// TLS enables HTTPS. May be nil.
+	TLS *TLSConfig
+	// DisableHTTP2 makes the HTTPS server speak HTTP/1.1 only.
+	// HTTP/2 is not used without TLS anyway.
+	DisableHTTP2 bool
+}
+
+// Run listens on the address and serves the handler until the context is done
+// or the process gets SIGINT or SIGTERM, see Serve.
+func Run(ctx context.Context, c ServerConfig, handler http.Handler, onShutdown ...func()) error {
+	ln, err := net.Listen("tcp", c.Addr)
+	if err != nil {
+		return fmt.Errorf("listening: %w", err)
 	}
+	return Serve(ctx, ln, c, handler, onShutdown...)
+}
 
+// Serve is a convenient function to serve the handler with graceful shutdown.
+// The shutdown begins when the context is done or the process gets SIGINT
+// or SIGTERM. The onShutdown functions are called as soon as the shutdown
+// begins, before the server stops accepting requests. They should fail
+// the readiness and make long-lived requests such as streams return.
+//
+// Serve returns nil after the graceful shutdown. It returns an error
+// if the server fails or the requests are not done within ShutdownTimeout.
+func Serve(ctx context.Context, ln net.Listener, c ServerConfig, handler http.Handler, onShutdown ...func()) error {
 	srv := &http.Server{
-		Addr:    fmt.Sprintf(":%d", port),
-		Handler: handler,
+		Handler:           handler,
+		ReadHeaderTimeout: c.ReadHeaderTimeout,
+		ReadTimeout:       c.ReadTimeout,
+		WriteTimeout:      c.WriteTimeout,
+		IdleTimeout:       c.IdleTimeout,
+		MaxHeaderBytes:    c.MaxHeaderBytes,
+		ErrorLog:          log.New(serverLogWriter{}, "", 0),
 	}
-
-	idle := make(chan struct{})
-	go func() {
-		done := make(chan os.Signal, 1)
-		signal.Notify(done, os.Interrupt, syscall.SIGINT, syscall.SIGTERM)
-		<-done
-		log.Println("Gracefully stopping...")
-		if err := srv.Shutdown(context.Background()); err != nil {
-			log.Println("server Shutdown:", err)
+	if c.TLS != nil {
+		tlsConfig, err := c.TLS.config()
+		if err != nil {
+			ln.Close()
+			return err
 		}
-		close(idle)
-		log.Println("Server stopped")
-	}()
+		srv.TLSConfig = tlsConfig
+		if c.DisableHTTP2 {
+			srv.TLSNextProto = make(map[string]func(*http.Server, *tls.Conn, http.Handler))
+		}
+	}
 
+	served := make(chan error, 1)
 	go func() {
-		if err := srv.ListenAndServe(); err != http.ErrServerClosed {
-			log.Fatalln("server ListenAndServe:", err)
+		if c.TLS != nil {
+			// The certificate comes from the TLS config.
+			served <- srv.ServeTLS(ln, "", "")
+			return
 		}
+		served <- srv.Serve(ln)
 	}()
-	log.Print("Server started at ", srv.Addr)
+	logging.Default().Info("server started", "addr", ln.Addr().String(), "tls", c.TLS != nil)
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(signals)
+
+	select {
+	case err := <-served:
+		return fmt.Errorf("serving: %w", err)
+	case <-ctx.Done():
+	case <-signals:
+	}
+
+	logging.Default().Info("gracefully stopping")
+	for _, f := range onShutdown {
+		f()
+	}
+
+	shutdownCtx := context.Background()
+	if c.ShutdownTimeout > 0 {
+		var cancel context.CancelFunc
+		shutdownCtx, cancel = context.WithTimeout(shutdownCtx, c.ShutdownTimeout)
+		defer cancel()
+	}
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		// Drop the requests still in flight.
+		srv.Close()
+		return fmt.Errorf("shutting down: %w", err)
+	}
+	if err := <-served; !errors.Is(err, http.ErrServerClosed) {
+		return fmt.Errorf("serving: %w", err)
+	}
+
+	logging.Default().Info("server stopped")
+	return nil
+}
+
+// serverLogWriter passes the errors of the http.Server, e.g. failed
+// TLS handshakes, to the default logger.
+type serverLogWriter struct{}
 
-	<-idle
+func (serverLogWriter) Write(p []byte) (int, error) {
+	logging.Default().Warn("http server error", "error", strings.TrimSpace(string(p)))
+	return len(p), nil
 }
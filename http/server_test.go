@@ -0,0 +1,311 @@
+package http
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testCert is a certificate with its key, signed by the parent or self-signed.
+type testCert struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	der  []byte
+}
+
+func newTestCert(t *testing.T, name string, parent *testCert, isCA bool) *testCert {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  isCA,
+	}
+	signer, signerKey := tmpl, key
+	if parent != nil {
+		signer, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCert{cert: cert, key: key, der: der}
+}
+
+// write saves the certificate and the key as PEM files in the directory.
+func (c *testCert) write(t *testing.T, dir, name string) (certFile, keyFile string) {
+	t.Helper()
+	keyDER, err := x509.MarshalECPrivateKey(c.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile = filepath.Join(dir, name+".crt")
+	keyFile = filepath.Join(dir, name+".key")
+	if err := ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return certFile, keyFile
+}
+
+func (c *testCert) tlsCertificate() tls.Certificate {
+	return tls.Certificate{Certificate: [][]byte{c.der}, PrivateKey: c.key}
+}
+
+func tempDir(t *testing.T) string {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "server")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	return dir
+}
+
+// serve starts Serve on a random port and returns its URL
+// and the channel receiving its result.
+func serve(ctx context.Context, t *testing.T, c ServerConfig, h http.Handler) (string, <-chan error) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error, 1)
+	go func() { done <- Serve(ctx, ln, c, h) }()
+
+	scheme := "http"
+	if c.TLS != nil {
+		scheme = "https"
+	}
+	return scheme + "://" + ln.Addr().String(), done
+}
+
+var okHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	w.Write([]byte(r.Proto))
+})
+
+func TestServe_TLS(t *testing.T) {
+	dir := tempDir(t)
+	ca := newTestCert(t, "ca", nil, true)
+	server := newTestCert(t, "server", ca, false)
+	client := newTestCert(t, "client", ca, false)
+	stranger := newTestCert(t, "stranger", nil, false)
+	certFile, keyFile := server.write(t, dir, "server")
+	caFile, _ := ca.write(t, dir, "ca")
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+
+	tests := []struct {
+		name       string
+		config     TLSConfig
+		http2      bool
+		clientCert *testCert
+		wantProto  string
+		wantErr    bool
+	}{
+		{
+			name:      "Should serve HTTP/2 over TLS",
+			config:    TLSConfig{CertFile: certFile, KeyFile: keyFile},
+			http2:     true,
+			wantProto: "HTTP/2.0",
+		},
+		{
+			name:      "Should serve HTTP/1.1 with HTTP/2 disabled",
+			config:    TLSConfig{CertFile: certFile, KeyFile: keyFile},
+			wantProto: "HTTP/1.1",
+		},
+		{
+			name:       "Should accept the client certificate of the CA",
+			config:     TLSConfig{CertFile: certFile, KeyFile: keyFile, ClientCAFile: caFile},
+			clientCert: client,
+			wantProto:  "HTTP/1.1",
+		},
+		{
+			name:    "Should require the client certificate",
+			config:  TLSConfig{CertFile: certFile, KeyFile: keyFile, ClientCAFile: caFile},
+			wantErr: true,
+		},
+		{
+			name:       "Should reject the client certificate of another CA",
+			config:     TLSConfig{CertFile: certFile, KeyFile: keyFile, ClientCAFile: caFile},
+			clientCert: stranger,
+			wantErr:    true,
+		},
+		{
+			name:      "Should let the clients without a certificate in when asked",
+			config:    TLSConfig{CertFile: certFile, KeyFile: keyFile, ClientCAFile: caFile, ClientAuth: ClientAuthVerifyIfGiven},
+			wantProto: "HTTP/1.1",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			tlsConfig := tt.config
+			url, done := serve(ctx, t, ServerConfig{TLS: &tlsConfig, DisableHTTP2: !tt.http2}, okHandler)
+
+			clientTLS := &tls.Config{RootCAs: roots}
+			if tt.clientCert != nil {
+				clientTLS.Certificates = []tls.Certificate{tt.clientCert.tlsCertificate()}
+			}
+			client := &http.Client{Transport: &http.Transport{TLSClientConfig: clientTLS, ForceAttemptHTTP2: true}}
+
+			resp, err := client.Get(url)
+			if tt.wantErr {
+				if err == nil {
+					resp.Body.Close()
+					t.Errorf("Get() succeeded, want a handshake error")
+				}
+			} else if err != nil {
+				t.Errorf("Get() unexpected error: %v", err)
+			} else {
+				body, _ := ioutil.ReadAll(resp.Body)
+				resp.Body.Close()
+				if string(body) != tt.wantProto {
+					t.Errorf("protocol = %s, want %s", body, tt.wantProto)
+				}
+			}
+
+			cancel()
+			if err := <-done; err != nil {
+				t.Errorf("Serve() error = %v", err)
+			}
+		})
+	}
+}
+
+func TestCertificateReloader(t *testing.T) {
+	dir := tempDir(t)
+	first := newTestCert(t, "first", nil, false)
+	certFile, keyFile := first.write(t, dir, "server")
+
+	r, err := NewCertificateReloader(certFile, keyFile)
+	if err != nil {
+		t.Fatalf("NewCertificateReloader() unexpected error: %v", err)
+	}
+	now := time.Now()
+	r.now = func() time.Time { return now }
+
+	subject := func() string {
+		cert, err := r.GetCertificate(nil)
+		if err != nil {
+			t.Fatalf("GetCertificate() unexpected error: %v", err)
+		}
+		parsed, _ := x509.ParseCertificate(cert.Certificate[0])
+		return parsed.Subject.CommonName
+	}
+	if got := subject(); got != "first" {
+		t.Fatalf("certificate = %s, want first", got)
+	}
+
+	second := newTestCert(t, "second", nil, false)
+	second.write(t, dir, "server")
+	later := time.Now().Add(time.Minute)
+	for _, name := range []string{certFile, keyFile} {
+		if err := os.Chtimes(name, later, later); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if got := subject(); got != "first" {
+		t.Errorf("certificate = %s, want first until the next check", got)
+	}
+	now = now.Add(certCheckInterval)
+	if got := subject(); got != "second" {
+		t.Errorf("certificate = %s, want the reloaded second", got)
+	}
+}
+
+func TestServe_Shutdown(t *testing.T) {
+	t.Run("Should call onShutdown and return nil", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		called := false
+		done := make(chan error, 1)
+		go func() { done <- Serve(ctx, ln, ServerConfig{}, okHandler, func() { called = true }) }()
+
+		cancel()
+		if err := <-done; err != nil {
+			t.Errorf("Serve() error = %v, want nil", err)
+		}
+		if !called {
+			t.Errorf("onShutdown was not called")
+		}
+	})
+
+	t.Run("Should give up after the shutdown timeout", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		stuck := make(chan struct{})
+		defer close(stuck)
+		started := make(chan struct{})
+		h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			close(started)
+			<-stuck
+		})
+		url, done := serve(ctx, t, ServerConfig{ShutdownTimeout: 50 * time.Millisecond}, h)
+
+		go func() {
+			resp, err := http.Get(url)
+			if err == nil {
+				resp.Body.Close()
+			}
+		}()
+		<-started
+		cancel()
+
+		select {
+		case err := <-done:
+			if !errors.Is(err, context.DeadlineExceeded) {
+				t.Errorf("Serve() error = %v, want %v", err, context.DeadlineExceeded)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Serve() did not return after the shutdown timeout")
+		}
+	})
+
+	t.Run("Should return the listening error", func(t *testing.T) {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer ln.Close()
+
+		err = Run(context.Background(), ServerConfig{Addr: ln.Addr().String()}, okHandler)
+		if err == nil {
+			t.Errorf("Run() error = nil, want the address in use")
+		}
+	})
+}
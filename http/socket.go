@@ -0,0 +1,323 @@
+package http
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/ortymid/t2-http/logging"
+	"github.com/ortymid/t2-http/market"
+)
+
+// Socket connection timings.
+const (
+	socketWriteWait  = 10 * time.Second
+	socketPongWait   = 60 * time.Second
+	socketPingPeriod = socketPongWait * 9 / 10
+)
+
+// Socket client limits.
+const (
+	socketMaxMessageSize   = 4096
+	socketMaxSubscriptions = 1000
+)
+
+// socketMessage is a message from a WebSocket client.
+type socketMessage struct {
+	Action     string   `json:"action"`
+	ProductIDs []int    `json:"product_ids"`
+	Sellers    []string `json:"sellers"`
+}
+
+// socketClient is a connected WebSocket client with its subscriptions.
+// The fields are guarded by the ProductSocket mutex.
+type socketClient struct {
+	userID     string
+	productIDs map[int]bool
+	sellers    map[string]bool
+	queue      chan []byte
+	// overflowed tells the queue closed for the client being too slow
+	// from the one closed on its disconnect.
+	overflowed bool
+}
+
+func (c *socketClient) wants(p market.Product) bool {
+	return c.productIDs[p.ID] || c.sellers[p.Seller]
+}
+
+// ProductSocket serves WebSocket clients subscribing to the changes
+// of specific products or products of specific sellers.
+//
+// A client sends {"action": "subscribe" | "unsubscribe", "product_ids": [...], "sellers": [...]}
+// and receives {"type": "subscribed", ...} with its current subscriptions
+// followed by the matching events. A client that cannot keep up with
+// its events is disconnected.
+type ProductSocket struct {
+	mu      sync.Mutex
+	clients map[*socketClient]struct{}
+	closed  bool
+	done    chan struct{}
+
+	upgrader     websocket.Upgrader
+	clientBuffer int
+}
+
+// NewProductSocket creates a socket queueing up to clientBuffer messages per client.
+func NewProductSocket(clientBuffer int) *ProductSocket {
+	return &ProductSocket{
+		clients:      make(map[*socketClient]struct{}),
+		done:         make(chan struct{}),
+		clientBuffer: clientBuffer,
+	}
+}
+
+// Handle passes the market event to the subscribed clients.
+// It never blocks, so it may be subscribed to the event bus synchronously.
+func (s *ProductSocket) Handle(e market.Event) {
+	msg := struct {
+		Type string `json:"type"`
+		streamProduct
+	}{
+		Type:          e.EventType(),
+		streamProduct: newStreamProduct(e),
+	}
+	data, err := json.Marshal(msg)
+	if err != nil {
+		err = fmt.Errorf("socket: encoding event: %w", err)
+		logging.Default().Error("socket failed", "error", err)
+		return
+	}
+
+	p := eventProduct(e)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for c := range s.clients {
+		if c.wants(p) {
+			s.send(c, data)
+		}
+	}
+}
+
+// Close disconnects all clients. It is meant to be called on server shutdown.
+func (s *ProductSocket) Close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return
+	}
+	s.closed = true
+	close(s.done)
+}
+
+// ServeHTTP upgrades the authorized request to a WebSocket connection
+// and serves the client until it disconnects. The route must require
+// the authentication.
+func (s *ProductSocket) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	userID := requestUserID(r)
+
+	c, err := s.connect(userID)
+	if err != nil {
+		writeError(w, http.StatusServiceUnavailable, err)
+		return
+	}
+
+	conn, err := s.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// The upgrader has already responded.
+		s.disconnect(c)
+		logging.FromContext(r.Context()).Error("socket upgrade failed", "error", err)
+		return
+	}
+
+	go s.writeLoop(conn, c)
+	s.readLoop(conn, c)
+}
+
+// readLoop handles the client messages until the connection breaks.
+func (s *ProductSocket) readLoop(conn *websocket.Conn, c *socketClient) {
+	defer func() {
+		s.disconnect(c)
+		conn.Close()
+	}()
+
+	conn.SetReadLimit(socketMaxMessageSize)
+	_ = conn.SetReadDeadline(time.Now().Add(socketPongWait))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(socketPongWait))
+	})
+
+	for {
+		var msg socketMessage
+		if err := conn.ReadJSON(&msg); err != nil {
+			var syntaxErr *json.SyntaxError
+			if errors.As(err, &syntaxErr) {
+				s.reply(c, socketError(errors.New("malformed message")))
+				continue
+			}
+			return
+		}
+		s.reply(c, s.subscribe(c, msg))
+	}
+}
+
+// writeLoop sends the queued messages and keepalive pings to the client.
+func (s *ProductSocket) writeLoop(conn *websocket.Conn, c *socketClient) {
+	ping := time.NewTicker(socketPingPeriod)
+	defer func() {
+		ping.Stop()
+		conn.Close()
+	}()
+
+	for {
+		select {
+		case data, ok := <-c.queue:
+			_ = conn.SetWriteDeadline(time.Now().Add(socketWriteWait))
+			if !ok {
+				if s.overflowed(c) {
+					_ = conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseTryAgainLater, "too slow"))
+				}
+				// Otherwise the client is gone already.
+				return
+			}
+			if err := conn.WriteMessage(websocket.TextMessage, data); err != nil {
+				return
+			}
+		case <-ping.C:
+			_ = conn.SetWriteDeadline(time.Now().Add(socketWriteWait))
+			if err := conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
+		case <-s.done:
+			_ = conn.SetWriteDeadline(time.Now().Add(socketWriteWait))
+			_ = conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseGoingAway, "server shutdown"))
+			return
+		}
+	}
+}
+
+// subscribe applies the client message and returns the reply to it.
+func (s *ProductSocket) subscribe(c *socketClient, msg socketMessage) interface{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch msg.Action {
+	case "subscribe":
+		if len(c.productIDs)+len(c.sellers)+len(msg.ProductIDs)+len(msg.Sellers) > socketMaxSubscriptions {
+			return socketError(fmt.Errorf("too many subscriptions, max %d", socketMaxSubscriptions))
+		}
+		for _, id := range msg.ProductIDs {
+			c.productIDs[id] = true
+		}
+		for _, seller := range msg.Sellers {
+			c.sellers[seller] = true
+		}
+	case "unsubscribe":
+		for _, id := range msg.ProductIDs {
+			delete(c.productIDs, id)
+		}
+		for _, seller := range msg.Sellers {
+			delete(c.sellers, seller)
+		}
+	default:
+		return socketError(fmt.Errorf("unknown action %q", msg.Action))
+	}
+
+	resp := struct {
+		Type       string   `json:"type"`
+		ProductIDs []int    `json:"product_ids"`
+		Sellers    []string `json:"sellers"`
+	}{
+		Type:       "subscribed",
+		ProductIDs: make([]int, 0, len(c.productIDs)),
+		Sellers:    make([]string, 0, len(c.sellers)),
+	}
+	for id := range c.productIDs {
+		resp.ProductIDs = append(resp.ProductIDs, id)
+	}
+	for seller := range c.sellers {
+		resp.Sellers = append(resp.Sellers, seller)
+	}
+	return resp
+}
+
+func (s *ProductSocket) reply(c *socketClient, msg interface{}) {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		err = fmt.Errorf("socket: encoding reply: %w", err)
+		logging.Default().Error("socket failed", "error", err)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.send(c, data)
+}
+
+// send queues the message for the client disconnecting it on overflow.
+// The caller must hold the mutex.
+func (s *ProductSocket) send(c *socketClient, data []byte) {
+	if _, ok := s.clients[c]; !ok {
+		return
+	}
+	select {
+	case c.queue <- data:
+	default:
+		delete(s.clients, c)
+		c.overflowed = true
+		close(c.queue)
+	}
+}
+
+// overflowed reports whether the client was disconnected for being too slow.
+func (s *ProductSocket) overflowed(c *socketClient) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return c.overflowed
+}
+
+func (s *ProductSocket) connect(userID string) (*socketClient, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return nil, errors.New("socket closed")
+	}
+
+	c := &socketClient{
+		userID:     userID,
+		productIDs: make(map[int]bool),
+		sellers:    make(map[string]bool),
+		queue:      make(chan []byte, s.clientBuffer),
+	}
+	s.clients[c] = struct{}{}
+	return c, nil
+}
+
+func (s *ProductSocket) disconnect(c *socketClient) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.clients[c]; ok {
+		delete(s.clients, c)
+		close(c.queue)
+	}
+}
+
+func socketError(err error) interface{} {
+	return struct {
+		Type    string `json:"type"`
+		Message string `json:"message"`
+	}{
+		Type:    "error",
+		Message: err.Error(),
+	}
+}
@@ -0,0 +1,108 @@
+package http
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/ortymid/t2-http/market"
+)
+
+func TestProductSocket(t *testing.T) {
+	socket := NewProductSocket(10)
+	defer socket.Close()
+
+	rt := NewRouter(RouterConfig{
+		Market: MockMarket{},
+		JWT:    verifier,
+		Socket: socket,
+	})
+	srv := httptest.NewServer(rt)
+	defer srv.Close()
+
+	url := "ws" + strings.TrimPrefix(srv.URL, "http") + "/products/ws"
+
+	t.Run("Should reject anonymous clients", func(t *testing.T) {
+		_, resp, err := websocket.DefaultDialer.Dial(url, nil)
+		if err == nil {
+			t.Fatalf("Dial() succeeded, want error")
+		}
+		if resp.StatusCode != http.StatusUnauthorized {
+			t.Errorf("Status = %d, want %d", resp.StatusCode, http.StatusUnauthorized)
+		}
+	})
+
+	t.Run("Should notify about subscribed products only", func(t *testing.T) {
+		header := http.Header{}
+		header.Set("Authorization", "Bearer "+testToken(t, 1))
+		conn, _, err := websocket.DefaultDialer.Dial(url, header)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		defer conn.Close()
+		_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+		err = conn.WriteJSON(map[string]interface{}{"action": "subscribe", "product_ids": []int{1}})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		var reply struct {
+			Type       string `json:"type"`
+			ProductIDs []int  `json:"product_ids"`
+		}
+		if err := conn.ReadJSON(&reply); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if reply.Type != "subscribed" || len(reply.ProductIDs) != 1 || reply.ProductIDs[0] != 1 {
+			t.Errorf("got reply %+v, want subscribed to [1]", reply)
+		}
+
+		socket.Handle(market.ProductAdded{Product: market.Product{ID: 2, Seller: "2"}})
+		socket.Handle(market.ProductReplaced{Product: market.Product{ID: 1, Seller: "2"}})
+
+		var msg struct {
+			Type    string `json:"type"`
+			Product struct {
+				ID int `json:"id"`
+			} `json:"product"`
+		}
+		if err := conn.ReadJSON(&msg); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if msg.Type != market.EventProductReplaced || msg.Product.ID != 1 {
+			t.Errorf("got message %+v, want product.replaced of product 1", msg)
+		}
+	})
+
+	t.Run("Should accept the token from the query", func(t *testing.T) {
+		conn, _, err := websocket.DefaultDialer.Dial(url+"?access_token="+testToken(t, 1), nil)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		conn.Close()
+	})
+
+	t.Run("Should tell the overflow from the disconnect", func(t *testing.T) {
+		s := NewProductSocket(1)
+		defer s.Close()
+
+		slow, _ := s.connect("1")
+		gone, _ := s.connect("2")
+		s.subscribe(slow, socketMessage{Action: "subscribe", ProductIDs: []int{1}})
+		s.disconnect(gone)
+
+		s.Handle(market.ProductAdded{Product: market.Product{ID: 1}})
+		s.Handle(market.ProductReplaced{Product: market.Product{ID: 1}})
+
+		if !s.overflowed(slow) {
+			t.Errorf("overflowed() of the slow client = false, want true")
+		}
+		if s.overflowed(gone) {
+			t.Errorf("overflowed() of the disconnected client = true, want false")
+		}
+	})
+}
@@ -0,0 +1,261 @@
+package http
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ortymid/t2-http/logging"
+	"github.com/ortymid/t2-http/market"
+)
+
+// streamHeartbeat is how often an idle stream sends a comment
+// to keep proxies from closing the connection.
+const streamHeartbeat = 15 * time.Second
+
+// streamEvent is a market event encoded for the Server-Sent Events stream.
+type streamEvent struct {
+	id   uint64
+	typ  string
+	data []byte
+}
+
+// streamClient is a connected stream reader.
+// Its queue is closed when the client cannot keep up with the events.
+type streamClient struct {
+	queue chan streamEvent
+}
+
+// ProductStream fans out market events to the Server-Sent Events clients.
+// The latest events are kept to let the reconnecting clients resume
+// from the Last-Event-ID. The event IDs are "<epoch>-<n>", the epoch
+// tells the IDs of this process from the ones sent before a restart.
+type ProductStream struct {
+	mu      sync.Mutex
+	epoch   string
+	lastID  uint64
+	replay  []streamEvent
+	clients map[*streamClient]struct{}
+	closed  bool
+	done    chan struct{}
+
+	replaySize   int
+	clientBuffer int
+}
+
+// NewProductStream creates a stream keeping replaySize latest events for resuming
+// and queueing up to clientBuffer events per client. A client which queue
+// overflows is disconnected and expected to reconnect with Last-Event-ID.
+// The negative sizes are taken for zero.
+func NewProductStream(replaySize, clientBuffer int) *ProductStream {
+	if replaySize < 0 {
+		replaySize = 0
+	}
+	if clientBuffer < 0 {
+		clientBuffer = 0
+	}
+	return &ProductStream{
+		epoch:        strconv.FormatInt(time.Now().UnixNano(), 36),
+		clients:      make(map[*streamClient]struct{}),
+		done:         make(chan struct{}),
+		replaySize:   replaySize,
+		clientBuffer: clientBuffer,
+	}
+}
+
+// Handle passes the market event to the connected clients.
+// It never blocks, so it may be subscribed to the event bus synchronously.
+func (s *ProductStream) Handle(e market.Event) {
+	data, err := json.Marshal(newStreamProduct(e))
+	if err != nil {
+		err = fmt.Errorf("stream: encoding event: %w", err)
+		logging.Default().Error("stream failed", "error", err)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return
+	}
+
+	s.lastID++
+	se := streamEvent{id: s.lastID, typ: e.EventType(), data: data}
+
+	s.replay = append(s.replay, se)
+	if len(s.replay) > s.replaySize {
+		s.replay = s.replay[len(s.replay)-s.replaySize:]
+	}
+
+	for c := range s.clients {
+		select {
+		case c.queue <- se:
+		default:
+			// The client is too slow, let it reconnect and resume.
+			delete(s.clients, c)
+			close(c.queue)
+		}
+	}
+}
+
+// Close disconnects all clients. It is meant to be called on server shutdown.
+func (s *ProductStream) Close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return
+	}
+	s.closed = true
+	close(s.done)
+}
+
+// ServeHTTP streams the events to the client until it disconnects.
+func (s *ProductStream) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, errors.New("streaming unsupported"))
+		return
+	}
+
+	c, missed, reset, err := s.connect(r.Header.Get("Last-Event-ID"))
+	if err != nil {
+		writeError(w, http.StatusServiceUnavailable, err)
+		return
+	}
+	defer s.disconnect(c)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+
+	if reset {
+		// Some events are gone from the replay buffer, the client should refetch.
+		fmt.Fprint(w, "event: reset\ndata: {}\n\n")
+	}
+	for _, se := range missed {
+		s.writeEvent(w, se)
+	}
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(streamHeartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case se, ok := <-c.queue:
+			if !ok {
+				return
+			}
+			s.writeEvent(w, se)
+			flusher.Flush()
+		case <-heartbeat.C:
+			fmt.Fprint(w, ": ping\n\n")
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// connect registers a new client and returns the events it missed since
+// the lastEventID. No lastEventID means a fresh client that does not need
+// a replay. reset reports that some of the missed events are no longer
+// available or the lastEventID is unknown to the stream.
+func (s *ProductStream) connect(lastEventID string) (c *streamClient, missed []streamEvent, reset bool, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return nil, nil, false, errors.New("stream closed")
+	}
+
+	lastID, known := s.parseID(lastEventID)
+	if lastEventID != "" && (!known || lastID > s.lastID) {
+		// The ID is from another process, e.g. from before a restart.
+		reset = true
+	} else if lastID > 0 && lastID < s.lastID {
+		if len(s.replay) == 0 || s.replay[0].id > lastID+1 {
+			reset = true
+		}
+		for _, se := range s.replay {
+			if se.id > lastID {
+				missed = append(missed, se)
+			}
+		}
+	}
+
+	c = &streamClient{queue: make(chan streamEvent, s.clientBuffer)}
+	s.clients[c] = struct{}{}
+	return c, missed, reset, nil
+}
+
+func (s *ProductStream) disconnect(c *streamClient) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.clients[c]; ok {
+		delete(s.clients, c)
+		close(c.queue)
+	}
+}
+
+// parseID returns the number of the event ID of the stream epoch.
+func (s *ProductStream) parseID(eventID string) (uint64, bool) {
+	prefix := s.epoch + "-"
+	if !strings.HasPrefix(eventID, prefix) {
+		return 0, false
+	}
+	id, err := strconv.ParseUint(strings.TrimPrefix(eventID, prefix), 10, 64)
+	return id, err == nil
+}
+
+func (s *ProductStream) writeEvent(w http.ResponseWriter, se streamEvent) {
+	fmt.Fprintf(w, "id: %s-%d\nevent: %s\ndata: %s\n\n", s.epoch, se.id, se.typ, se.data)
+}
+
+type streamProduct struct {
+	EventID    string    `json:"event_id"`
+	OccurredAt time.Time `json:"occurred_at"`
+	Product    struct {
+		ID     int    `json:"id"`
+		Name   string `json:"name"`
+		Price  int    `json:"price"`
+		Seller string `json:"seller"`
+	} `json:"product"`
+}
+
+func newStreamProduct(e market.Event) streamProduct {
+	p := eventProduct(e)
+
+	var sp streamProduct
+	sp.EventID = e.Metadata().ID
+	sp.OccurredAt = e.Metadata().OccurredAt
+	sp.Product.ID = p.ID
+	sp.Product.Name = p.Name
+	sp.Product.Price = p.Price
+	sp.Product.Seller = p.Seller
+	return sp
+}
+
+// eventProduct returns the product the event is about.
+func eventProduct(e market.Event) market.Product {
+	switch e := e.(type) {
+	case market.ProductAdded:
+		return e.Product
+	case market.ProductReplaced:
+		return e.Product
+	case market.ProductDeleted:
+		return e.Product
+	}
+	return market.Product{}
+}
@@ -0,0 +1,225 @@
+package http
+
+import (
+	"bufio"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ortymid/t2-http/market"
+)
+
+// readStreamEvents reads n events from the stream returning their "id event" lines.
+func readStreamEvents(t *testing.T, r *bufio.Reader, n int) []string {
+	var got []string
+	var id, typ string
+	for len(got) < n {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatalf("reading stream: %v", err)
+		}
+		line = strings.TrimSuffix(line, "\n")
+		switch {
+		case strings.HasPrefix(line, "id: "):
+			id = strings.TrimPrefix(line, "id: ")
+		case strings.HasPrefix(line, "event: "):
+			typ = strings.TrimPrefix(line, "event: ")
+		case line == "":
+			got = append(got, strings.TrimSpace(id+" "+typ))
+			id, typ = "", ""
+		}
+	}
+	return got
+}
+
+func TestProductStream(t *testing.T) {
+	added := market.ProductAdded{Product: market.Product{ID: 1}}
+	replaced := market.ProductReplaced{Product: market.Product{ID: 1}}
+	deleted := market.ProductDeleted{Product: market.Product{ID: 1}}
+
+	t.Run("Should stream live events", func(t *testing.T) {
+		s := NewProductStream(10, 10)
+		srv := httptest.NewServer(s)
+		defer srv.Close()
+		defer s.Close()
+
+		resp, err := http.Get(srv.URL)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		defer resp.Body.Close()
+		if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+			t.Errorf("Content-Type = %s, want text/event-stream", ct)
+		}
+
+		s.Handle(added)
+		s.Handle(deleted)
+
+		got := readStreamEvents(t, bufio.NewReader(resp.Body), 2)
+		want := []string{s.epoch + "-1 product.added", s.epoch + "-2 product.deleted"}
+		if strings.Join(got, ",") != strings.Join(want, ",") {
+			t.Errorf("got events %v, want %v", got, want)
+		}
+	})
+
+	t.Run("Should resume from Last-Event-ID", func(t *testing.T) {
+		s := NewProductStream(10, 10)
+		srv := httptest.NewServer(s)
+		defer srv.Close()
+		defer s.Close()
+
+		s.Handle(added)
+		s.Handle(replaced)
+		s.Handle(deleted)
+
+		req, _ := http.NewRequest(http.MethodGet, srv.URL, nil)
+		req.Header.Set("Last-Event-ID", s.epoch+"-1")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		defer resp.Body.Close()
+
+		got := readStreamEvents(t, bufio.NewReader(resp.Body), 2)
+		want := []string{s.epoch + "-2 product.replaced", s.epoch + "-3 product.deleted"}
+		if strings.Join(got, ",") != strings.Join(want, ",") {
+			t.Errorf("got events %v, want %v", got, want)
+		}
+	})
+
+	t.Run("Should ask to reset when the replay is gone", func(t *testing.T) {
+		s := NewProductStream(1, 10)
+		srv := httptest.NewServer(s)
+		defer srv.Close()
+		defer s.Close()
+
+		s.Handle(added)
+		s.Handle(replaced)
+		s.Handle(deleted)
+
+		req, _ := http.NewRequest(http.MethodGet, srv.URL, nil)
+		req.Header.Set("Last-Event-ID", s.epoch+"-1")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		defer resp.Body.Close()
+
+		got := readStreamEvents(t, bufio.NewReader(resp.Body), 2)
+		want := []string{"reset", s.epoch + "-3 product.deleted"}
+		if strings.Join(got, ",") != strings.Join(want, ",") {
+			t.Errorf("got events %v, want %v", got, want)
+		}
+	})
+
+	t.Run("Should ask to reset after an unknown Last-Event-ID", func(t *testing.T) {
+		s := NewProductStream(10, 10)
+		srv := httptest.NewServer(s)
+		defer srv.Close()
+		defer s.Close()
+
+		s.Handle(added)
+
+		req, _ := http.NewRequest(http.MethodGet, srv.URL, nil)
+		req.Header.Set("Last-Event-ID", s.epoch+"-5")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		defer resp.Body.Close()
+
+		r := bufio.NewReader(resp.Body)
+		got := readStreamEvents(t, r, 1)
+		s.Handle(deleted)
+		got = append(got, readStreamEvents(t, r, 1)...)
+		want := []string{"reset", s.epoch + "-2 product.deleted"}
+		if strings.Join(got, ",") != strings.Join(want, ",") {
+			t.Errorf("got events %v, want %v", got, want)
+		}
+	})
+
+	t.Run("Should ask to reset after an ID from before a restart", func(t *testing.T) {
+		before := NewProductStream(10, 10)
+		before.Handle(added)
+		before.Close()
+
+		s := NewProductStream(10, 10)
+		defer s.Close()
+		s.Handle(added)
+		s.Handle(replaced)
+
+		// The event 1 of the new process is not the one the client saw.
+		_, missed, reset, err := s.connect(before.epoch + "-1")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !reset || len(missed) != 0 {
+			t.Errorf("connect() = %d missed, reset %v, want a reset without a replay", len(missed), reset)
+		}
+	})
+
+	t.Run("Should take a negative replay size for zero", func(t *testing.T) {
+		s := NewProductStream(-1, 10)
+		defer s.Close()
+
+		s.Handle(added)
+		s.Handle(replaced)
+		_, missed, reset, err := s.connect(s.epoch + "-1")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !reset || len(missed) != 0 {
+			t.Errorf("connect() = %d missed, reset %v, want a reset without a replay", len(missed), reset)
+		}
+	})
+
+	t.Run("Should disconnect a lagging client", func(t *testing.T) {
+		s := NewProductStream(10, 1)
+		defer s.Close()
+
+		c, _, _, err := s.connect("")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		s.Handle(added)
+		s.Handle(replaced)
+
+		<-c.queue
+		select {
+		case _, ok := <-c.queue:
+			if ok {
+				t.Errorf("got an event after the overflow, want closed queue")
+			}
+		case <-time.After(time.Second):
+			t.Errorf("queue is not closed")
+		}
+	})
+
+	t.Run("Should end streams on close", func(t *testing.T) {
+		s := NewProductStream(10, 10)
+		srv := httptest.NewServer(s)
+		defer srv.Close()
+
+		resp, err := http.Get(srv.URL)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		defer resp.Body.Close()
+
+		s.Close()
+
+		done := make(chan struct{})
+		go func() {
+			_, _ = bufio.NewReader(resp.Body).ReadString(0)
+			close(done)
+		}()
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+			t.Errorf("stream is not closed")
+		}
+	})
+}
@@ -0,0 +1,152 @@
+package http
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/ortymid/t2-http/logging"
+)
+
+// certCheckInterval is how often the certificate files are checked for changes.
+const certCheckInterval = 10 * time.Second
+
+// Client certificate policies of TLSConfig.ClientAuth.
+const (
+	ClientAuthRequire       = "require"
+	ClientAuthVerifyIfGiven = "verify_if_given"
+)
+
+// TLSConfig enables HTTPS and, with ClientCAFile, the client certificates.
+type TLSConfig struct {
+	// CertFile and KeyFile are PEM files. They are loaded again
+	// when they change, so the certificate is renewed without a restart.
+	CertFile string
+	KeyFile  string
+
+	// ClientCAFile is the PEM bundle of the CAs issuing the client certificates.
+	// Without it the client certificates are not requested.
+	ClientCAFile string
+	// ClientAuth is ClientAuthRequire (the default) or ClientAuthVerifyIfGiven.
+	ClientAuth string
+}
+
+func (c *TLSConfig) config() (*tls.Config, error) {
+	certs, err := NewCertificateReloader(c.CertFile, c.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+	conf := &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: certs.GetCertificate,
+	}
+
+	if c.ClientCAFile == "" {
+		return conf, nil
+	}
+	pem, err := ioutil.ReadFile(c.ClientCAFile)
+	if err != nil {
+		return nil, fmt.Errorf("loading client CAs: %w", err)
+	}
+	conf.ClientCAs = x509.NewCertPool()
+	if !conf.ClientCAs.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("loading client CAs: no certificates in %s", c.ClientCAFile)
+	}
+	switch c.ClientAuth {
+	case "", ClientAuthRequire:
+		conf.ClientAuth = tls.RequireAndVerifyClientCert
+	case ClientAuthVerifyIfGiven:
+		conf.ClientAuth = tls.VerifyClientCertIfGiven
+	default:
+		return nil, fmt.Errorf("unknown client auth %q", c.ClientAuth)
+	}
+	return conf, nil
+}
+
+// CertificateReloader serves the certificate from the files,
+// loading it again when the files change.
+type CertificateReloader struct {
+	certFile string
+	keyFile  string
+
+	mu      sync.Mutex
+	cert    *tls.Certificate
+	modTime time.Time
+	checked time.Time
+	now     func() time.Time
+}
+
+// NewCertificateReloader loads the certificate and its key from the PEM files.
+func NewCertificateReloader(certFile, keyFile string) (*CertificateReloader, error) {
+	r := &CertificateReloader{certFile: certFile, keyFile: keyFile, now: time.Now}
+	if err := r.Reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Reload loads the certificate from the files.
+// On failure the previous certificate stays.
+func (r *CertificateReloader) Reload() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.load()
+}
+
+// GetCertificate returns the current certificate. It is meant to be
+// tls.Config.GetCertificate. At most every certCheckInterval it checks
+// the files and loads them if they changed.
+func (r *CertificateReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := r.now()
+	if now.Sub(r.checked) >= certCheckInterval {
+		r.checked = now
+		if modTime, err := r.filesModTime(); err == nil && !modTime.Equal(r.modTime) {
+			if err := r.load(); err != nil {
+				// A half-written pair is likely, the next check will see it complete.
+				logging.Default().Warn("certificate reload failed", "error", err)
+			}
+		}
+	}
+	return r.cert, nil
+}
+
+// load reads the files. The caller must hold the mutex.
+func (r *CertificateReloader) load() error {
+	modTime, err := r.filesModTime()
+	if err != nil {
+		return fmt.Errorf("loading certificate: %w", err)
+	}
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return fmt.Errorf("loading certificate: %w", err)
+	}
+	if r.cert != nil {
+		logging.Default().Info("certificate reloaded", "file", r.certFile)
+	}
+	r.cert = &cert
+	r.modTime = modTime
+	return nil
+}
+
+// filesModTime returns the time of the latest change of the files.
+func (r *CertificateReloader) filesModTime() (time.Time, error) {
+	var latest time.Time
+	for _, name := range []string{r.certFile, r.keyFile} {
+		fi, err := os.Stat(name)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if fi.ModTime().After(latest) {
+			latest = fi.ModTime()
+		}
+	}
+	return latest, nil
+}
@@ -0,0 +1,54 @@
+package http
+
+import (
+	"net/http"
+
+	"github.com/ortymid/t2-http/logging"
+	"github.com/ortymid/t2-http/trace"
+)
+
+// traceRequest starts the server span of the request continuing the trace
+// of the traceparent header. The span is named after the route template
+// once the handler is done. It relies on the recorder of logAccess.
+func traceRequest(tracer *trace.Tracer) Middleware {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := trace.Extract(r.Context(), r.Header)
+			ctx, span := tracer.Start(ctx, r.Method, "http.method", r.Method, "http.path", r.URL.Path)
+			if span == nil {
+				h.ServeHTTP(w, r)
+				return
+			}
+			defer span.End()
+
+			traceID := span.TraceID.String()
+			ctx = logging.NewContext(ctx, logging.FromContext(ctx).With("trace_id", traceID))
+			rec, _ := w.(*responseRecorder)
+			if rec != nil {
+				rec.traceID = traceID
+			}
+
+			h.ServeHTTP(w, r.WithContext(ctx))
+
+			if rec == nil {
+				return
+			}
+			route := rec.route
+			if route == "" {
+				route = unmatchedRoute
+			}
+			status := rec.status
+			if status == 0 {
+				status = http.StatusOK
+			}
+			span.Name = r.Method + " " + route
+			span.SetAttributes("http.route", route, "http.status_code", status)
+			if rec.userID != "" {
+				span.SetAttributes("user_id", rec.userID)
+			}
+			if status >= http.StatusInternalServerError {
+				span.SetError(rec.err)
+			}
+		})
+	}
+}
@@ -0,0 +1,191 @@
+package http
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/ortymid/t2-http/apikey"
+	"github.com/ortymid/t2-http/market"
+	"github.com/ortymid/t2-http/user"
+)
+
+// UserHandler forwards user account requests to the user service.
+type UserHandler struct {
+	auth  *Authenticator
+	users user.Interface
+}
+
+func (h *UserHandler) RegisterHandlers(r *mux.Router) {
+	r.Handle("/", h.auth.Anonymous().ThenFunc(h.Register)).Methods(http.MethodPost)
+	r.Handle("/login", h.auth.Anonymous().ThenFunc(h.Login)).Methods(http.MethodPost)
+	r.Handle("/me", h.auth.RequiredScope(apikey.ScopeProfile).ThenFunc(h.Profile)).Methods(http.MethodGet)
+	r.Handle("/me", h.auth.RequiredScope(apikey.ScopeProfile).ThenFunc(h.EditProfile)).Methods(http.MethodPut)
+	r.Handle("/{id}", h.auth.Anonymous().ThenFunc(h.Detail)).Methods(http.MethodGet)
+}
+
+// Register handles requests for new accounts.
+func (h *UserHandler) Register(w http.ResponseWriter, r *http.Request) {
+	data := credentialsRequest{}
+	err := json.NewDecoder(r.Body).Decode(&data)
+	if err != nil {
+		err = fmt.Errorf("decoding register request: %w", err)
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	a, err := h.users.Register(r.Context(), data.Username, data.Password)
+	if err != nil {
+		writeError(w, userErrorStatus(err), err)
+		return
+	}
+
+	err = json.NewEncoder(w).Encode(newAccountResponse(a))
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+}
+
+// Login handles requests for access tokens.
+func (h *UserHandler) Login(w http.ResponseWriter, r *http.Request) {
+	data := credentialsRequest{}
+	err := json.NewDecoder(r.Body).Decode(&data)
+	if err != nil {
+		err = fmt.Errorf("decoding login request: %w", err)
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	token, err := h.users.Login(r.Context(), data.Username, data.Password)
+	if err != nil {
+		writeError(w, userErrorStatus(err), err)
+		return
+	}
+
+	resp := struct {
+		Token string `json:"token"`
+	}{
+		Token: token,
+	}
+	err = json.NewEncoder(w).Encode(resp)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+}
+
+// Profile handles requests for the account of the authorized user.
+func (h *UserHandler) Profile(w http.ResponseWriter, r *http.Request) {
+	userID := requestUserID(r)
+
+	a, err := h.users.Profile(r.Context(), userID)
+	if err != nil {
+		writeError(w, userErrorStatus(err), err)
+		return
+	}
+
+	err = json.NewEncoder(w).Encode(newAccountResponse(a))
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+}
+
+// EditProfile handles requests changing the username or the password
+// of the authorized user.
+func (h *UserHandler) EditProfile(w http.ResponseWriter, r *http.Request) {
+	userID := requestUserID(r)
+
+	data := profileRequest{}
+	err := json.NewDecoder(r.Body).Decode(&data)
+	if err != nil {
+		err = fmt.Errorf("decoding edit profile request: %w", err)
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	u := user.ProfileUpdate{Username: data.Username, Password: data.Password, CurrentPassword: data.CurrentPassword}
+	a, err := h.users.UpdateProfile(r.Context(), userID, u)
+	if err != nil {
+		writeError(w, userErrorStatus(err), err)
+		return
+	}
+
+	err = json.NewEncoder(w).Encode(newAccountResponse(a))
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+}
+
+// Detail handles requests for the public user information.
+func (h *UserHandler) Detail(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	a, err := h.users.Profile(r.Context(), id)
+	if err != nil {
+		writeError(w, userErrorStatus(err), err)
+		return
+	}
+
+	resp := struct {
+		ID       string `json:"id"`
+		Username string `json:"username"`
+	}{
+		ID:       a.ID,
+		Username: a.Username,
+	}
+	err = json.NewEncoder(w).Encode(resp)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+}
+
+func userErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, user.ErrInvalidCredentials):
+		return http.StatusUnauthorized
+	case errors.Is(err, user.ErrWrongPassword):
+		return http.StatusForbidden
+	case errors.Is(err, &market.ErrUserNotFound{}):
+		return http.StatusNotFound
+	case errors.Is(err, user.ErrUsernameTaken):
+		return http.StatusConflict
+	case errors.Is(err, user.ErrInvalidAccount):
+		return http.StatusBadRequest
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+type credentialsRequest struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+type profileRequest struct {
+	Username        string `json:"username"`
+	Password        string `json:"password"`
+	CurrentPassword string `json:"current_password"`
+}
+
+type accountResponse struct {
+	ID        string    `json:"id"`
+	Username  string    `json:"username"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+func newAccountResponse(a *user.Account) accountResponse {
+	return accountResponse{
+		ID:        a.ID,
+		Username:  a.Username,
+		CreatedAt: a.CreatedAt,
+		UpdatedAt: a.UpdatedAt,
+	}
+}
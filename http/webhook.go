@@ -0,0 +1,282 @@
+package http
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/ortymid/t2-http/apikey"
+	"github.com/ortymid/t2-http/market"
+	"github.com/ortymid/t2-http/webhook"
+)
+
+// WebhookHandler forwards webhook subscription requests to the webhook manager.
+// All the requests require authorization.
+type WebhookHandler struct {
+	auth     *Authenticator
+	webhooks webhook.Interface
+}
+
+func (h *WebhookHandler) RegisterHandlers(r *mux.Router) {
+	auth := h.auth.RequiredScope(apikey.ScopeWebhooks)
+	r.Handle("/", auth.ThenFunc(h.List)).Methods(http.MethodGet)
+	r.Handle("/", auth.ThenFunc(h.Create)).Methods(http.MethodPost)
+	r.Handle("/dead-letters", auth.ThenFunc(h.DeadLetters)).Methods(http.MethodGet)
+	r.Handle("/{id}", auth.ThenFunc(h.Detail)).Methods(http.MethodGet)
+	r.Handle("/{id}", auth.ThenFunc(h.Edit)).Methods(http.MethodPut)
+	r.Handle("/{id}", auth.ThenFunc(h.Delete)).Methods(http.MethodDelete)
+	r.Handle("/{id}/deliveries", auth.ThenFunc(h.Deliveries)).Methods(http.MethodGet)
+}
+
+// List handles requests for all subscriptions of the user.
+func (h *WebhookHandler) List(w http.ResponseWriter, r *http.Request) {
+	userID := requestUserID(r)
+
+	subs, err := h.webhooks.Subscriptions(r.Context(), userID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	resp := make([]subscriptionResponse, len(subs))
+	for i, s := range subs {
+		resp[i] = newSubscriptionResponse(s, false)
+	}
+	err = json.NewEncoder(w).Encode(resp)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+}
+
+// Detail handles requests for the specific subscription detail.
+func (h *WebhookHandler) Detail(w http.ResponseWriter, r *http.Request) {
+	userID := requestUserID(r)
+
+	id, err := getVarID(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	s, err := h.webhooks.Subscription(r.Context(), id, userID)
+	if err != nil {
+		writeError(w, webhookErrorStatus(err), err)
+		return
+	}
+
+	err = json.NewEncoder(w).Encode(newSubscriptionResponse(s, false))
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+}
+
+// Create handles requests for new subscriptions.
+// The response is the only place the generated secret is shown.
+func (h *WebhookHandler) Create(w http.ResponseWriter, r *http.Request) {
+	userID := requestUserID(r)
+
+	data := subscriptionRequest{}
+	err := json.NewDecoder(r.Body).Decode(&data)
+	if err != nil {
+		err = fmt.Errorf("decoding create subscription request: %w", err)
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	s := &webhook.Subscription{URL: data.URL, Secret: data.Secret, Events: data.Events}
+	s, err = h.webhooks.AddSubscription(r.Context(), s, userID)
+	if err != nil {
+		writeError(w, webhookErrorStatus(err), err)
+		return
+	}
+
+	err = json.NewEncoder(w).Encode(newSubscriptionResponse(s, true))
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+}
+
+// Edit handles subscription edit requests.
+func (h *WebhookHandler) Edit(w http.ResponseWriter, r *http.Request) {
+	userID := requestUserID(r)
+
+	id, err := getVarID(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	data := subscriptionRequest{}
+	err = json.NewDecoder(r.Body).Decode(&data)
+	if err != nil {
+		err = fmt.Errorf("decoding edit subscription request: %w", err)
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	s := &webhook.Subscription{ID: id, URL: data.URL, Secret: data.Secret, Events: data.Events}
+	s, err = h.webhooks.ReplaceSubscription(r.Context(), s, userID)
+	if err != nil {
+		writeError(w, webhookErrorStatus(err), err)
+		return
+	}
+
+	err = json.NewEncoder(w).Encode(newSubscriptionResponse(s, false))
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+}
+
+// Delete handles subscription delete requests.
+func (h *WebhookHandler) Delete(w http.ResponseWriter, r *http.Request) {
+	userID := requestUserID(r)
+
+	id, err := getVarID(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	err = h.webhooks.DeleteSubscription(r.Context(), id, userID)
+	if err != nil {
+		writeError(w, webhookErrorStatus(err), err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Deliveries handles requests for the delivery log of the subscription.
+func (h *WebhookHandler) Deliveries(w http.ResponseWriter, r *http.Request) {
+	userID := requestUserID(r)
+
+	id, err := getVarID(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	ds, err := h.webhooks.Deliveries(r.Context(), id, userID)
+	if err != nil {
+		writeError(w, webhookErrorStatus(err), err)
+		return
+	}
+
+	type respDelivery struct {
+		ID         int       `json:"id"`
+		EventID    string    `json:"event_id"`
+		EventType  string    `json:"event_type"`
+		Attempt    int       `json:"attempt"`
+		StatusCode int       `json:"status_code,omitempty"`
+		Error      string    `json:"error,omitempty"`
+		DurationMS int64     `json:"duration_ms"`
+		At         time.Time `json:"at"`
+	}
+	resp := make([]respDelivery, len(ds))
+	for i, d := range ds {
+		resp[i] = respDelivery{
+			ID:         d.ID,
+			EventID:    d.EventID,
+			EventType:  d.EventType,
+			Attempt:    d.Attempt,
+			StatusCode: d.StatusCode,
+			Error:      d.Error,
+			DurationMS: d.Duration.Milliseconds(),
+			At:         d.At,
+		}
+	}
+	err = json.NewEncoder(w).Encode(resp)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+}
+
+// DeadLetters handles requests for the events that could not be delivered to the user.
+func (h *WebhookHandler) DeadLetters(w http.ResponseWriter, r *http.Request) {
+	userID := requestUserID(r)
+
+	dls, err := h.webhooks.DeadLetters(r.Context(), userID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	type respDeadLetter struct {
+		ID             int             `json:"id"`
+		SubscriptionID int             `json:"subscription_id"`
+		EventID        string          `json:"event_id"`
+		EventType      string          `json:"event_type"`
+		Payload        json.RawMessage `json:"payload"`
+		Attempts       int             `json:"attempts"`
+		LastError      string          `json:"last_error"`
+		FailedAt       time.Time       `json:"failed_at"`
+	}
+	resp := make([]respDeadLetter, len(dls))
+	for i, dl := range dls {
+		resp[i] = respDeadLetter{
+			ID:             dl.ID,
+			SubscriptionID: dl.SubscriptionID,
+			EventID:        dl.EventID,
+			EventType:      dl.EventType,
+			Payload:        dl.Payload,
+			Attempts:       dl.Attempts,
+			LastError:      dl.LastError,
+			FailedAt:       dl.FailedAt,
+		}
+	}
+	err = json.NewEncoder(w).Encode(resp)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+}
+
+func webhookErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, &market.ErrPermission{}):
+		return http.StatusForbidden
+	case errors.Is(err, webhook.ErrSubscriptionNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, webhook.ErrInvalidSubscription):
+		return http.StatusBadRequest
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+type subscriptionRequest struct {
+	URL    string   `json:"url"`
+	Secret string   `json:"secret"`
+	Events []string `json:"events"`
+}
+
+type subscriptionResponse struct {
+	ID        int       `json:"id"`
+	URL       string    `json:"url"`
+	Secret    string    `json:"secret,omitempty"`
+	Events    []string  `json:"events"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func newSubscriptionResponse(s *webhook.Subscription, withSecret bool) subscriptionResponse {
+	resp := subscriptionResponse{
+		ID:        s.ID,
+		URL:       s.URL,
+		Events:    s.Events,
+		CreatedAt: s.CreatedAt,
+	}
+	if resp.Events == nil {
+		resp.Events = []string{}
+	}
+	if withSecret {
+		resp.Secret = s.Secret
+	}
+	return resp
+}
@@ -0,0 +1,37 @@
+// Package idempotency remembers the responses to the requests made
+// with an Idempotency-Key, so a retried request is not done twice.
+package idempotency
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+)
+
+var (
+	// ErrInProgress is returned while the first request with the key is served.
+	ErrInProgress = errors.New("request with the idempotency key is in progress")
+	// ErrMismatch is returned for the key used with another request.
+	ErrMismatch = errors.New("idempotency key was used with another request")
+)
+
+// Response is the remembered response to the first request.
+type Response struct {
+	Status int
+	Header http.Header
+	Body   []byte
+}
+
+// Store remembers the responses per user and key.
+type Store interface {
+	// Begin reserves the key of the user for the request with the hash
+	// for the ttl. If the key has the response already, it is returned
+	// to be replayed. A reserved key without the response is ErrInProgress,
+	// a key reserved for another hash is ErrMismatch.
+	Begin(ctx context.Context, userID, key string, hash []byte, ttl time.Duration) (*Response, error)
+	// Finish saves the response to the reserved key.
+	Finish(ctx context.Context, userID, key string, resp *Response) error
+	// Abort releases the reserved key, so the request may be retried.
+	Abort(ctx context.Context, userID, key string) error
+}
@@ -1,8 +1,74 @@
 package jwt
 
-import "github.com/dgrijalva/jwt-go"
+import (
+	"encoding/json"
+	"errors"
+	"time"
+)
 
+// Claims are the token claims. The registered claims follow RFC 7519,
+// the audience may be a single string or an array of them.
 type Claims struct {
 	UserID int `json:"id"`
-	jwt.StandardClaims
+
+	Audience  Audience `json:"aud,omitempty"`
+	ExpiresAt int64    `json:"exp,omitempty"`
+	ID        string   `json:"jti,omitempty"`
+	IssuedAt  int64    `json:"iat,omitempty"`
+	Issuer    string   `json:"iss,omitempty"`
+	NotBefore int64    `json:"nbf,omitempty"`
+	Subject   string   `json:"sub,omitempty"`
+}
+
+// Valid implements jwt.Claims. It checks the time claims without any leeway,
+// Verifier skips it in favour of its own checks.
+func (c *Claims) Valid() error {
+	now := time.Now().Unix()
+	if c.ExpiresAt != 0 && now > c.ExpiresAt {
+		return errors.New("token is expired")
+	}
+	if c.NotBefore != 0 && now < c.NotBefore {
+		return errors.New("token is not valid yet")
+	}
+	if c.IssuedAt != 0 && now < c.IssuedAt {
+		return errors.New("token used before issued")
+	}
+	return nil
+}
+
+// Audience is the "aud" claim.
+type Audience []string
+
+// Contains reports whether the audience includes aud.
+func (a Audience) Contains(aud string) bool {
+	for _, s := range a {
+		if s == aud {
+			return true
+		}
+	}
+	return false
+}
+
+// UnmarshalJSON accepts both a string and an array of strings.
+func (a *Audience) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err == nil {
+		*a = Audience{s}
+		return nil
+	}
+
+	var ss []string
+	if err := json.Unmarshal(data, &ss); err != nil {
+		return errors.New("aud must be a string or an array of strings")
+	}
+	*a = ss
+	return nil
+}
+
+// MarshalJSON writes a single audience as a string.
+func (a Audience) MarshalJSON() ([]byte, error) {
+	if len(a) == 1 {
+		return json.Marshal(a[0])
+	}
+	return json.Marshal([]string(a))
 }
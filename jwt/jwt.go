@@ -1,22 +1,59 @@
 package jwt
 
 import (
-	"errors"
 	"fmt"
+	"strconv"
+	"time"
 
 	"github.com/dgrijalva/jwt-go"
 )
 
+// Parse verifies the token signed with the single algorithm.
+// Use Verifier to accept several algorithms or to check the issuer and the audience.
 func Parse(tokenString string, alg string, key interface{}) (*Claims, error) {
-	var claims Claims
-	_, err := jwt.ParseWithClaims(tokenString, &claims, func(token *jwt.Token) (interface{}, error) {
-		if token.Header["alg"] != alg {
-			return nil, errors.New("unexpected token algorithm")
-		}
-		return key, nil
-	})
+	v, err := NewVerifier(map[string]interface{}{alg: key})
 	if err != nil {
 		return nil, fmt.Errorf("parsing token: %w", err)
 	}
-	return &claims, nil
+	return v.Verify(tokenString)
+}
+
+// Sign creates a token string with the claims signed by the key.
+func Sign(claims *Claims, alg string, key interface{}) (string, error) {
+	method := jwt.GetSigningMethod(alg)
+	if method == nil {
+		return "", fmt.Errorf("signing token: unknown algorithm %s", alg)
+	}
+	tokenString, err := jwt.NewWithClaims(method, claims).SignedString(key)
+	if err != nil {
+		return "", fmt.Errorf("signing token: %w", err)
+	}
+	return tokenString, nil
+}
+
+// Issuer issues tokens for the users.
+type Issuer struct {
+	Alg string
+	Key interface{}
+	// TTL is the token lifetime, zero means the tokens never expire.
+	TTL time.Duration
+	// Name and Audience are put to the "iss" and "aud" claims if set.
+	Name     string
+	Audience []string
+}
+
+// IssueToken creates a token for the user with the given ID.
+func (iss *Issuer) IssueToken(userID string) (string, error) {
+	id, err := strconv.Atoi(userID)
+	if err != nil {
+		return "", fmt.Errorf("issuing token: user id is not an integer: %w", err)
+	}
+
+	now := time.Now()
+	claims := &Claims{UserID: id, Issuer: iss.Name, Audience: iss.Audience}
+	claims.IssuedAt = now.Unix()
+	if iss.TTL > 0 {
+		claims.ExpiresAt = now.Add(iss.TTL).Unix()
+	}
+	return Sign(claims, iss.Alg, iss.Key)
 }
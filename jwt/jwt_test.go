@@ -5,6 +5,7 @@ import (
 	"crypto/rsa"
 	"reflect"
 	"testing"
+	"time"
 
 	"github.com/dgrijalva/jwt-go"
 )
@@ -34,3 +35,34 @@ func TestParse(t *testing.T) {
 		}
 	})
 }
+
+func TestIssuer_IssueToken(t *testing.T) {
+	secret := []byte("secret")
+	iss := &Issuer{Alg: "HS256", Key: secret, TTL: time.Hour}
+
+	t.Run("Should issue a token for the user", func(t *testing.T) {
+		token, err := iss.IssueToken("42")
+		if err != nil {
+			t.Errorf("IssueToken() unexpected error: %v", err)
+			return
+		}
+
+		got, err := Parse(token, "HS256", secret)
+		if err != nil {
+			t.Errorf("Parse() unexpected error: %v", err)
+			return
+		}
+		if got.UserID != 42 {
+			t.Errorf("UserID = %d, want 42", got.UserID)
+		}
+		if got.ExpiresAt-got.IssuedAt != int64(time.Hour/time.Second) {
+			t.Errorf("token lifetime = %ds, want %ds", got.ExpiresAt-got.IssuedAt, int64(time.Hour/time.Second))
+		}
+	})
+
+	t.Run("Should reject a non-integer user id", func(t *testing.T) {
+		if _, err := iss.IssueToken("alice"); err == nil {
+			t.Errorf("IssueToken() expected an error")
+		}
+	})
+}
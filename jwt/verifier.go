@@ -0,0 +1,244 @@
+package jwt
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+var (
+	// ErrAlgorithm is returned for the tokens signed with an algorithm
+	// that is not accepted, including "none".
+	ErrAlgorithm = errors.New("token algorithm not accepted")
+	// ErrClaims is returned when the signature is fine but the claims are not.
+	// The error is a *ClaimError naming the failed check.
+	ErrClaims = errors.New("invalid token claims")
+)
+
+// Claim checks reported by ClaimError.
+const (
+	CheckExpiry    = "exp"
+	CheckNotBefore = "nbf"
+	CheckIssuedAt  = "iat"
+	CheckMaxAge    = "max_age"
+	CheckIssuer    = "iss"
+	CheckAudience  = "aud"
+)
+
+// ClaimError tells which claim check the token failed.
+type ClaimError struct {
+	Check  string
+	Reason string
+}
+
+func (e *ClaimError) Error() string {
+	return fmt.Sprintf("%v: %s check failed: %s", ErrClaims, e.Check, e.Reason)
+}
+
+func (e *ClaimError) Is(target error) bool {
+	return target == ErrClaims
+}
+
+// Verifier checks the tokens signed with any of the accepted algorithms.
+type Verifier struct {
+	keys map[string]interface{}
+
+	// Issuer, when set, must be equal to the "iss" claim.
+	// The tokens without the claim are rejected.
+	Issuer string
+	// Audience, when set, must be one of the "aud" claim values.
+	// The tokens without the claim are rejected.
+	Audience string
+	// Leeway is the tolerated clock skew for the "exp", "nbf" and "iat" claims.
+	Leeway time.Duration
+	// MaxAge, when set, limits the time since the "iat" claim,
+	// even if the token is not expired. The tokens without "iat" are rejected.
+	MaxAge time.Duration
+
+	mu  sync.RWMutex // guards Leeway and MaxAge changed by SetTimeLimits
+	now func() time.Time
+}
+
+// NewVerifier creates a verifier accepting the algorithms that are the keys
+// of the map. Every algorithm has its own key of the matching type:
+// []byte for HS*, *rsa.PublicKey for RS* and PS*, *ecdsa.PublicKey
+// on the matching curve for ES* and ed25519.PublicKey for EdDSA.
+func NewVerifier(keys map[string]interface{}) (*Verifier, error) {
+	if len(keys) == 0 {
+		return nil, errors.New("creating verifier: no algorithms")
+	}
+
+	v := &Verifier{keys: make(map[string]interface{}, len(keys)), now: time.Now}
+	for alg, key := range keys {
+		if err := checkKey(alg, key); err != nil {
+			return nil, fmt.Errorf("creating verifier: %w", err)
+		}
+		v.keys[alg] = key
+	}
+	return v, nil
+}
+
+// Algorithms returns the accepted algorithms.
+func (v *Verifier) Algorithms() []string {
+	algs := make([]string, 0, len(v.keys))
+	for alg := range v.keys {
+		algs = append(algs, alg)
+	}
+	return algs
+}
+
+// SetTimeLimits changes Leeway and MaxAge. Unlike setting the fields,
+// it is safe while the verifier is in use.
+func (v *Verifier) SetTimeLimits(leeway, maxAge time.Duration) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	v.Leeway = leeway
+	v.MaxAge = maxAge
+}
+
+// Verify checks the signature and the claims of the token.
+func (v *Verifier) Verify(tokenString string) (*Claims, error) {
+	var claims Claims
+	parser := &jwt.Parser{SkipClaimsValidation: true}
+	_, err := parser.ParseWithClaims(tokenString, &claims, v.key)
+	if err != nil {
+		var verr *jwt.ValidationError
+		if errors.As(err, &verr) && verr.Inner != nil {
+			err = verr.Inner
+		}
+		return nil, fmt.Errorf("parsing token: %w", err)
+	}
+
+	if err := v.validate(&claims); err != nil {
+		return nil, fmt.Errorf("parsing token: %w", err)
+	}
+	return &claims, nil
+}
+
+// key picks the key by the token algorithm.
+// The algorithm must be accepted and the key must be of the algorithm type,
+// so an RSA public key is never used as an HMAC secret.
+func (v *Verifier) key(token *jwt.Token) (interface{}, error) {
+	alg, _ := token.Header["alg"].(string)
+	if alg == "" || strings.EqualFold(alg, "none") {
+		return nil, fmt.Errorf("%w: unsigned tokens are not accepted", ErrAlgorithm)
+	}
+
+	key, ok := v.keys[alg]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrAlgorithm, alg)
+	}
+	if token.Method == nil || token.Method.Alg() != alg {
+		return nil, fmt.Errorf("%w: %s", ErrAlgorithm, alg)
+	}
+	if err := checkKey(alg, key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+func (v *Verifier) validate(c *Claims) error {
+	now := v.now()
+	v.mu.RLock()
+	leeway := int64(v.Leeway / time.Second)
+	maxAge := v.MaxAge
+	v.mu.RUnlock()
+
+	if c.ExpiresAt != 0 && now.Unix() > c.ExpiresAt+leeway {
+		return &ClaimError{Check: CheckExpiry, Reason: "token is expired"}
+	}
+	if c.NotBefore != 0 && now.Unix() < c.NotBefore-leeway {
+		return &ClaimError{Check: CheckNotBefore, Reason: "token is not valid yet"}
+	}
+	if c.IssuedAt != 0 && now.Unix() < c.IssuedAt-leeway {
+		return &ClaimError{Check: CheckIssuedAt, Reason: "token is issued in the future"}
+	}
+	if maxAge > 0 {
+		if c.IssuedAt == 0 {
+			return &ClaimError{Check: CheckMaxAge, Reason: "token has no issue time"}
+		}
+		if now.Unix() > c.IssuedAt+int64(maxAge/time.Second)+leeway {
+			return &ClaimError{Check: CheckMaxAge, Reason: "token is too old"}
+		}
+	}
+	if v.Issuer != "" && c.Issuer != v.Issuer {
+		return &ClaimError{Check: CheckIssuer, Reason: fmt.Sprintf("unexpected issuer %q", c.Issuer)}
+	}
+	if v.Audience != "" && !c.Audience.Contains(v.Audience) {
+		return &ClaimError{Check: CheckAudience, Reason: fmt.Sprintf("token is not meant for %q", v.Audience)}
+	}
+	return nil
+}
+
+// checkKey reports whether the key fits the algorithm.
+func checkKey(alg string, key interface{}) error {
+	if strings.EqualFold(alg, "none") {
+		return fmt.Errorf("%w: none", ErrAlgorithm)
+	}
+	if jwt.GetSigningMethod(alg) == nil {
+		return fmt.Errorf("%w: unknown algorithm %s", ErrAlgorithm, alg)
+	}
+
+	ok := false
+	switch {
+	case strings.HasPrefix(alg, "HS"):
+		k, isBytes := key.([]byte)
+		ok = isBytes && len(k) > 0
+	case strings.HasPrefix(alg, "RS"), strings.HasPrefix(alg, "PS"):
+		_, ok = key.(*rsa.PublicKey)
+	case strings.HasPrefix(alg, "ES"):
+		if k, isECDSA := key.(*ecdsa.PublicKey); isECDSA {
+			ok = k.Curve == curves[alg]
+		}
+	case alg == "EdDSA":
+		k, isEd25519 := key.(ed25519.PublicKey)
+		ok = isEd25519 && len(k) == ed25519.PublicKeySize
+	}
+	if !ok {
+		return fmt.Errorf("%w: %T is not a %s key", ErrAlgorithm, key, alg)
+	}
+	return nil
+}
+
+var curves = map[string]elliptic.Curve{
+	"ES256": elliptic.P256(),
+	"ES384": elliptic.P384(),
+	"ES512": elliptic.P521(),
+}
+
+// ParsePublicKeyPEM reads an RSA, ECDSA or Ed25519 public key
+// in the PKIX or the PKCS #1 PEM encoding.
+func ParsePublicKeyPEM(data []byte) (interface{}, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("parsing public key: no PEM data")
+	}
+
+	switch block.Type {
+	case "PUBLIC KEY":
+		key, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("parsing public key: %w", err)
+		}
+		return key, nil
+	case "RSA PUBLIC KEY":
+		key, err := x509.ParsePKCS1PublicKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("parsing public key: %w", err)
+		}
+		return key, nil
+	default:
+		return nil, fmt.Errorf("parsing public key: unexpected PEM block %s", block.Type)
+	}
+}
@@ -0,0 +1,162 @@
+package jwt
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+func TestVerifier_Verify(t *testing.T) {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	edPublic, edKey, _ := ed25519.GenerateKey(rand.Reader)
+	secret := []byte("secret")
+
+	v, err := NewVerifier(map[string]interface{}{
+		"RS256": &rsaKey.PublicKey,
+		"PS256": &rsaKey.PublicKey,
+		"ES256": &ecKey.PublicKey,
+		"EdDSA": edPublic,
+		"HS256": secret,
+	})
+	if err != nil {
+		t.Fatalf("NewVerifier() unexpected error: %v", err)
+	}
+
+	sign := func(alg string, key interface{}, claims *Claims) string {
+		s, err := Sign(claims, alg, key)
+		if err != nil {
+			t.Fatalf("Sign() unexpected error: %v", err)
+		}
+		return s
+	}
+
+	// The HMAC of an RSA public key used as the secret.
+	rsaPEM := pem.EncodeToMemory(&pem.Block{Type: "RSA PUBLIC KEY", Bytes: x509.MarshalPKCS1PublicKey(&rsaKey.PublicKey)})
+	unsigned, _ := jwt.NewWithClaims(jwt.SigningMethodNone, &Claims{UserID: 1}).SignedString(jwt.UnsafeAllowNoneSignatureType)
+
+	tests := []struct {
+		name    string
+		token   string
+		wantErr error
+	}{
+		{name: "Should accept RS256", token: sign("RS256", rsaKey, &Claims{UserID: 1})},
+		{name: "Should accept PS256", token: sign("PS256", rsaKey, &Claims{UserID: 1})},
+		{name: "Should accept ES256", token: sign("ES256", ecKey, &Claims{UserID: 1})},
+		{name: "Should accept EdDSA", token: sign("EdDSA", edKey, &Claims{UserID: 1})},
+		{name: "Should accept HS256", token: sign("HS256", secret, &Claims{UserID: 1})},
+		{name: "Should reject none", token: unsigned, wantErr: ErrAlgorithm},
+		{name: "Should reject a not accepted algorithm", token: sign("HS512", secret, &Claims{UserID: 1}), wantErr: ErrAlgorithm},
+		{name: "Should reject the public key used as an HMAC secret", token: sign("HS256", rsaPEM, &Claims{UserID: 1}), wantErr: jwt.ErrSignatureInvalid},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := v.Verify(tt.token)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("Verify() error = %v, want %v", err, tt.wantErr)
+				return
+			}
+			if err == nil && got.UserID != 1 {
+				t.Errorf("Verify() UserID = %d, want 1", got.UserID)
+			}
+		})
+	}
+}
+
+func TestVerifier_Claims(t *testing.T) {
+	secret := []byte("secret")
+	v, _ := NewVerifier(map[string]interface{}{"HS256": secret})
+	v.Issuer = "users"
+	v.Audience = "market"
+	v.Leeway = 30 * time.Second
+	v.MaxAge = time.Hour
+	now := time.Now()
+	v.now = func() time.Time { return now }
+
+	valid := func() *Claims {
+		return &Claims{UserID: 1, Issuer: "users", Audience: Audience{"other", "market"}, IssuedAt: now.Unix()}
+	}
+
+	tests := []struct {
+		name      string
+		claims    func(c *Claims)
+		wantCheck string
+	}{
+		{name: "Should accept valid claims", claims: func(c *Claims) {}},
+		{name: "Should tolerate the clock skew", claims: func(c *Claims) {
+			c.ExpiresAt = now.Add(-20 * time.Second).Unix()
+			c.NotBefore = now.Add(20 * time.Second).Unix()
+		}},
+		{name: "Should reject an expired token", claims: func(c *Claims) {
+			c.ExpiresAt = now.Add(-time.Minute).Unix()
+		}, wantCheck: CheckExpiry},
+		{name: "Should reject a token before nbf", claims: func(c *Claims) {
+			c.NotBefore = now.Add(time.Minute).Unix()
+		}, wantCheck: CheckNotBefore},
+		{name: "Should reject another issuer", claims: func(c *Claims) {
+			c.Issuer = "someone"
+		}, wantCheck: CheckIssuer},
+		{name: "Should reject another audience", claims: func(c *Claims) {
+			c.Audience = Audience{"other"}
+		}, wantCheck: CheckAudience},
+		{name: "Should reject a missing audience", claims: func(c *Claims) {
+			c.Audience = nil
+		}, wantCheck: CheckAudience},
+		{name: "Should reject a token older than the max age", claims: func(c *Claims) {
+			c.IssuedAt = now.Add(-2 * time.Hour).Unix()
+		}, wantCheck: CheckMaxAge},
+		{name: "Should reject a token without the issue time", claims: func(c *Claims) {
+			c.IssuedAt = 0
+		}, wantCheck: CheckMaxAge},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := valid()
+			tt.claims(c)
+			token, _ := Sign(c, "HS256", secret)
+
+			_, err := v.Verify(token)
+			if tt.wantCheck == "" {
+				if err != nil {
+					t.Errorf("Verify() unexpected error: %v", err)
+				}
+				return
+			}
+			var claimErr *ClaimError
+			if !errors.As(err, &claimErr) || claimErr.Check != tt.wantCheck {
+				t.Errorf("Verify() error = %v, want the %s check to fail", err, tt.wantCheck)
+			}
+		})
+	}
+}
+
+func TestNewVerifier(t *testing.T) {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+
+	tests := []struct {
+		name string
+		keys map[string]interface{}
+	}{
+		{name: "Should reject none", keys: map[string]interface{}{"none": jwt.UnsafeAllowNoneSignatureType}},
+		{name: "Should reject an RSA key for HS256", keys: map[string]interface{}{"HS256": &rsaKey.PublicKey}},
+		{name: "Should reject a secret for RS256", keys: map[string]interface{}{"RS256": []byte("secret")}},
+		{name: "Should reject a P-384 key for ES256", keys: map[string]interface{}{"ES256": &ecKey.PublicKey}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewVerifier(tt.keys); !errors.Is(err, ErrAlgorithm) {
+				t.Errorf("NewVerifier() error = %v, want %v", err, ErrAlgorithm)
+			}
+		})
+	}
+}
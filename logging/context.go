@@ -0,0 +1,48 @@
+package logging
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+)
+
+// HeaderRequestID carries the request ID between the services.
+const HeaderRequestID = "X-Request-ID"
+
+type contextKey int
+
+const (
+	keyLogger contextKey = iota
+	keyRequestID
+)
+
+// NewContext returns a context carrying the logger.
+func NewContext(ctx context.Context, l *Logger) context.Context {
+	return context.WithValue(ctx, keyLogger, l)
+}
+
+// FromContext returns the logger of the context or the default one.
+func FromContext(ctx context.Context) *Logger {
+	if l, ok := ctx.Value(keyLogger).(*Logger); ok {
+		return l
+	}
+	return Default()
+}
+
+// WithRequestID returns a context carrying the request ID.
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, keyRequestID, id)
+}
+
+// RequestID returns the request ID of the context, if any.
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(keyRequestID).(string)
+	return id
+}
+
+// NewRequestID generates a random request ID.
+func NewRequestID() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
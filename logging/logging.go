@@ -0,0 +1,202 @@
+// Package logging provides a structured logger writing JSON lines
+// and the request-scoped context helpers.
+package logging
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Level is the severity of a log entry.
+type Level int
+
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+var levelNames = []string{"debug", "info", "warn", "error"}
+
+func (l Level) String() string {
+	if l < LevelDebug || l > LevelError {
+		return fmt.Sprintf("level(%d)", int(l))
+	}
+	return levelNames[l]
+}
+
+// ParseLevel reads the level name as returned by Level.String.
+func ParseLevel(s string) (Level, error) {
+	for i, name := range levelNames {
+		if strings.EqualFold(s, name) {
+			return Level(i), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown log level %q", s)
+}
+
+// Logger writes every entry as a JSON object on its own line:
+// the time, the level, the message and the fields in the order they were added.
+// The zero value is not usable, use New.
+type Logger struct {
+	mu     *sync.Mutex // shared with the derived loggers
+	out    io.Writer
+	level  *int32 // shared with the derived loggers
+	fields []field
+	now    func() time.Time
+}
+
+type field struct {
+	key   string
+	value interface{}
+}
+
+// New creates a logger writing the entries of the level and above to out.
+func New(out io.Writer, level Level) *Logger {
+	lvl := int32(level)
+	return &Logger{mu: &sync.Mutex{}, out: out, level: &lvl, now: time.Now}
+}
+
+// Nop creates a logger discarding everything.
+func Nop() *Logger {
+	return New(ioutil.Discard, LevelError+1)
+}
+
+var (
+	defaultMu     sync.RWMutex
+	defaultLogger = New(os.Stderr, LevelInfo)
+)
+
+// Default returns the logger used where no logger is given.
+// It writes the info entries and above to the standard error.
+func Default() *Logger {
+	defaultMu.RLock()
+	defer defaultMu.RUnlock()
+	return defaultLogger
+}
+
+// SetDefault replaces the logger returned by Default.
+func SetDefault(l *Logger) {
+	defaultMu.Lock()
+	defer defaultMu.Unlock()
+	defaultLogger = l
+}
+
+// With returns a logger adding the fields to every entry.
+// The fields are given as alternating keys and values.
+func (l *Logger) With(keyvals ...interface{}) *Logger {
+	derived := *l
+	derived.fields = make([]field, len(l.fields), len(l.fields)+len(keyvals)/2)
+	copy(derived.fields, l.fields)
+	derived.fields = appendFields(derived.fields, keyvals)
+	return &derived
+}
+
+// Enabled reports whether the entries of the level are written.
+func (l *Logger) Enabled(level Level) bool {
+	return level >= l.Level()
+}
+
+// Level returns the lowest level written.
+func (l *Logger) Level() Level {
+	return Level(atomic.LoadInt32(l.level))
+}
+
+// SetLevel changes the lowest level written by the logger
+// and all the loggers derived from it. It is safe to call while logging.
+func (l *Logger) SetLevel(level Level) {
+	atomic.StoreInt32(l.level, int32(level))
+}
+
+func (l *Logger) Debug(msg string, keyvals ...interface{}) { l.log(LevelDebug, msg, keyvals) }
+func (l *Logger) Info(msg string, keyvals ...interface{})  { l.log(LevelInfo, msg, keyvals) }
+func (l *Logger) Warn(msg string, keyvals ...interface{})  { l.log(LevelWarn, msg, keyvals) }
+func (l *Logger) Error(msg string, keyvals ...interface{}) { l.log(LevelError, msg, keyvals) }
+
+// Log writes the entry with the given level.
+func (l *Logger) Log(level Level, msg string, keyvals ...interface{}) {
+	l.log(level, msg, keyvals)
+}
+
+func (l *Logger) log(level Level, msg string, keyvals []interface{}) {
+	if !l.Enabled(level) {
+		return
+	}
+
+	fields := make([]field, 0, 3+len(l.fields)+len(keyvals)/2)
+	fields = append(fields,
+		field{"time", l.now().UTC().Format(time.RFC3339Nano)},
+		field{"level", level.String()},
+		field{"msg", msg},
+	)
+	fields = append(fields, l.fields...)
+	fields = appendFields(fields, keyvals)
+
+	line := encode(fields)
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	_, _ = l.out.Write(line)
+}
+
+// appendFields pairs up the keys and the values.
+// A missing value or a non-string key is reported in the entry
+// rather than dropped.
+func appendFields(fields []field, keyvals []interface{}) []field {
+	for i := 0; i < len(keyvals); i += 2 {
+		key, ok := keyvals[i].(string)
+		if !ok {
+			key = fmt.Sprint(keyvals[i])
+		}
+		if i+1 == len(keyvals) {
+			fields = append(fields, field{"!BADKEY", key})
+			break
+		}
+		fields = append(fields, field{key, keyvals[i+1]})
+	}
+	return fields
+}
+
+func encode(fields []field) []byte {
+	var b strings.Builder
+	b.WriteByte('{')
+	for i, f := range fields {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		key, _ := json.Marshal(f.key)
+		b.Write(key)
+		b.WriteByte(':')
+		b.Write(encodeValue(f.value))
+	}
+	b.WriteString("}\n")
+	return []byte(b.String())
+}
+
+func encodeValue(v interface{}) []byte {
+	switch v := v.(type) {
+	case error:
+		data, _ := json.Marshal(v.Error())
+		return data
+	case time.Duration:
+		// Durations are written in milliseconds to be easy to aggregate.
+		data, _ := json.Marshal(float64(v) / float64(time.Millisecond))
+		return data
+	case fmt.Stringer:
+		data, _ := json.Marshal(v.String())
+		return data
+	}
+
+	data, err := json.Marshal(v)
+	if err != nil {
+		data, _ = json.Marshal(fmt.Sprint(v))
+	}
+	return data
+}
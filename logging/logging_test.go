@@ -0,0 +1,83 @@
+package logging
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestLogger(t *testing.T) {
+	now := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	tests := []struct {
+		name string
+		log  func(l *Logger)
+		want string
+	}{
+		{
+			name: "Should write the fields in order",
+			log: func(l *Logger) {
+				l.With("request_id", "r1").Info("request", "status", 200, "latency_ms", 1500*time.Microsecond)
+			},
+			want: `{"time":"2020-01-02T03:04:05Z","level":"info","msg":"request","request_id":"r1","status":200,"latency_ms":1.5}` + "\n",
+		},
+		{
+			name: "Should write errors as strings",
+			log: func(l *Logger) {
+				l.Error("failed", "error", errors.New("boom"))
+			},
+			want: `{"time":"2020-01-02T03:04:05Z","level":"error","msg":"failed","error":"boom"}` + "\n",
+		},
+		{
+			name: "Should skip the entries below the level",
+			log: func(l *Logger) {
+				l.Debug("details")
+			},
+			want: "",
+		},
+		{
+			name: "Should report a key without a value",
+			log: func(l *Logger) {
+				l.Warn("odd", "key")
+			},
+			want: `{"time":"2020-01-02T03:04:05Z","level":"warn","msg":"odd","!BADKEY":"key"}` + "\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			l := New(&buf, LevelInfo)
+			l.now = func() time.Time { return now }
+
+			tt.log(l)
+			if got := buf.String(); got != tt.want {
+				t.Errorf("output = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLogger_With(t *testing.T) {
+	var buf bytes.Buffer
+	base := New(&buf, LevelInfo)
+	_ = base.With("a", 1)
+	base.Info("m")
+
+	if bytes.Contains(buf.Bytes(), []byte(`"a"`)) {
+		t.Errorf("With() changed the base logger: %s", buf.String())
+	}
+}
+
+func TestLogger_SetLevel(t *testing.T) {
+	var buf bytes.Buffer
+	base := New(&buf, LevelInfo)
+	derived := base.With("a", 1)
+
+	base.SetLevel(LevelDebug)
+	derived.Debug("m")
+
+	if buf.Len() == 0 {
+		t.Errorf("SetLevel() did not change the level of the derived logger")
+	}
+}
@@ -0,0 +1,222 @@
+package market
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/ortymid/t2-http/trace"
+)
+
+// Operations of a batch.
+const (
+	OpCreate  = "create"
+	OpReplace = "replace"
+	OpDelete  = "delete"
+)
+
+var (
+	// ErrBatchAborted is the result of the operations not applied
+	// because another operation of the atomic batch failed.
+	ErrBatchAborted = errors.New("not applied, another operation of the batch failed")
+	// ErrUnknownOperation is the result of an operation of an unknown kind.
+	ErrUnknownOperation = errors.New("unknown operation")
+	// ErrNotSeller is the reason of the permission error of a change
+	// of the product sold by another user.
+	ErrNotSeller = errors.New("product of another seller")
+)
+
+// Operation is a single change of a batch. The product ID is ignored
+// by OpCreate, OpDelete needs nothing else.
+type Operation struct {
+	Op      string
+	Product Product
+	// Seller, if set, must sell the replaced or the deleted product
+	// when the operation is applied, otherwise it fails with ErrPermission.
+	Seller string
+}
+
+// OperationResult is the product created, replaced or deleted by the operation,
+// or its error.
+type OperationResult struct {
+	Product *Product
+	Err     error
+}
+
+// BatchFailed returns the index of the first failed operation, -1 if none.
+func BatchFailed(results []OperationResult) int {
+	for i, r := range results {
+		if r.Err != nil && !errors.Is(r.Err, ErrBatchAborted) {
+			return i
+		}
+	}
+	return -1
+}
+
+// Batch applies the operations on the products of the user. The atomic batch
+// applies all of them or, if any fails, none, the other one applies every
+// operation it can. The results are in the order of the operations.
+// The error is returned only if the batch could not be tried at all.
+//
+// The user must exist to create or replace, the replaced and the deleted
+// products must be sold by the user.
+func (m *Market) Batch(ctx context.Context, ops []Operation, userID string, atomic bool) (_ []OperationResult, err error) {
+	ctx, span := trace.Start(ctx, "Market.Batch", "operations", len(ops), "atomic", atomic, "user_id", userID)
+	defer span.EndWith(&err)
+
+	results, checked, indexes, err := m.checkBatch(ctx, ops, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if atomic && len(checked) < len(ops) {
+		for i := range results {
+			if results[i].Err == nil {
+				results[i].Err = ErrBatchAborted
+			}
+		}
+		return results, nil
+	}
+
+	applied, err := m.ProductService.Batch(ctx, checked, atomic)
+	if err != nil {
+		err = fmt.Errorf("batch: %w", err)
+		return nil, err
+	}
+	done := 0
+	for j, r := range applied {
+		op := checked[j]
+		if r.Err == nil {
+			done++
+			m.publish(operationEvent(op.Op, *r.Product))
+		} else if !errors.Is(r.Err, ErrBatchAborted) {
+			r.Err = fmt.Errorf("%s: %w", opName(op.Op), r.Err)
+		}
+		results[indexes[j]] = r
+	}
+
+	span.SetAttributes("applied", done)
+	m.logger(ctx).Info("product batch applied", "operations", len(ops), "applied", done, "atomic", atomic, "user_id", userID)
+	return results, nil
+}
+
+// CheckBatch checks the operations the way Batch does without applying them.
+// The results of the operations passing the checks have the products
+// to be written, without the IDs of the new ones.
+func (m *Market) CheckBatch(ctx context.Context, ops []Operation, userID string) (_ []OperationResult, err error) {
+	ctx, span := trace.Start(ctx, "Market.CheckBatch", "operations", len(ops), "user_id", userID)
+	defer span.EndWith(&err)
+
+	results, checked, indexes, err := m.checkBatch(ctx, ops, userID)
+	if err != nil {
+		return nil, err
+	}
+	for j, op := range checked {
+		p := op.Product
+		results[indexes[j]].Product = &p
+	}
+	return results, nil
+}
+
+// checkBatch checks every operation before the changes. It returns
+// the results with the errors of the failed operations, the operations
+// passing the checks and their indexes in ops.
+func (m *Market) checkBatch(ctx context.Context, ops []Operation, userID string) (results []OperationResult, checked []Operation, indexes []int, err error) {
+	results = make([]OperationResult, len(ops))
+	var userErr error
+	for _, op := range ops {
+		if op.Op == OpCreate || op.Op == OpReplace {
+			userErr = m.checkUser(ctx, userID)
+			break
+		}
+	}
+	if userErr != nil && !errors.Is(userErr, &ErrPermission{}) {
+		return nil, nil, nil, fmt.Errorf("batch: %w", userErr)
+	}
+
+	checked = make([]Operation, 0, len(ops))
+	indexes = make([]int, 0, len(ops))
+	for i, op := range ops {
+		op.Product.Seller = userID
+		if op.Op != OpCreate {
+			// The product service checks it again with the change.
+			op.Seller = userID
+		}
+		var err error
+		switch op.Op {
+		case OpCreate:
+			err = userErr
+		case OpReplace:
+			if err = userErr; err == nil {
+				err = m.checkSeller(ctx, op.Product.ID, userID)
+			}
+		case OpDelete:
+			err = m.checkSeller(ctx, op.Product.ID, userID)
+		default:
+			err = fmt.Errorf("%w %q", ErrUnknownOperation, op.Op)
+		}
+		if err != nil && !operationError(err) {
+			return nil, nil, nil, fmt.Errorf("batch: %w", err)
+		}
+		if err != nil {
+			results[i].Err = fmt.Errorf("%s: %w", opName(op.Op), err)
+			continue
+		}
+		checked = append(checked, op)
+		indexes = append(indexes, i)
+	}
+	return results, checked, indexes, nil
+}
+
+// operationError reports whether the error fails only the operation,
+// not the whole batch.
+func operationError(err error) bool {
+	return errors.Is(err, &ErrPermission{}) || errors.Is(err, ErrProductNotFound) || errors.Is(err, ErrUnknownOperation)
+}
+
+// checkUser checks the user may change the products. Only the existence
+// of the user counts yet.
+func (m *Market) checkUser(ctx context.Context, userID string) error {
+	_, err := m.UserService.User(ctx, userID)
+	if errors.Is(err, &ErrUserNotFound{}) {
+		return &ErrPermission{Reason: err}
+	}
+	return err
+}
+
+// checkSeller checks the product is sold by the user.
+func (m *Market) checkSeller(ctx context.Context, id int, userID string) error {
+	p, err := m.ProductService.Product(ctx, id)
+	if err != nil {
+		return err
+	}
+	if p.Seller != userID {
+		return &ErrPermission{Reason: ErrNotSeller}
+	}
+	return nil
+}
+
+// opName names the operation in the errors the way the single changes do.
+func opName(op string) string {
+	switch op {
+	case OpCreate:
+		return "add product"
+	case OpReplace:
+		return "edit product"
+	case OpDelete:
+		return "delete product"
+	default:
+		return "batch"
+	}
+}
+
+func operationEvent(op string, p Product) Event {
+	switch op {
+	case OpCreate:
+		return ProductAdded{Meta: NewEventMeta(), Product: p}
+	case OpReplace:
+		return ProductReplaced{Meta: NewEventMeta(), Product: p}
+	default:
+		return ProductDeleted{Meta: NewEventMeta(), Product: p}
+	}
+}
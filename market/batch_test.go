@@ -0,0 +1,150 @@
+package market_test
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/ortymid/t2-http/market"
+	"github.com/ortymid/t2-http/market/mock"
+	"github.com/ortymid/t2-http/service/mem"
+)
+
+func TestMarket_Batch(t *testing.T) {
+	// The products of mem.NewProductService are 1 of the user 1
+	// and 2 of the user 2.
+	ops := []market.Operation{
+		{Op: market.OpCreate, Product: market.Product{Name: "Apple", Price: 100}},
+		{Op: market.OpReplace, Product: market.Product{ID: 1, Name: "Banana", Price: 1600}},
+		{Op: market.OpDelete, Product: market.Product{ID: 2}},
+		{Op: market.OpDelete, Product: market.Product{ID: 9}},
+	}
+
+	tests := []struct {
+		name         string
+		userID       string
+		atomic       bool
+		wantErrs     []error
+		wantProducts []market.Product
+	}{
+		{
+			name:     "Applies the permitted operations",
+			userID:   "1",
+			wantErrs: []error{nil, nil, &market.ErrPermission{}, market.ErrProductNotFound},
+			wantProducts: []market.Product{
+				{ID: 1, Name: "Banana", Price: 1600, Seller: "1"},
+				{ID: 2, Name: "Carrot", Price: 1400, Seller: "2"},
+				{ID: 3, Name: "Apple", Price: 100, Seller: "1"},
+			},
+		},
+		{
+			name:     "Applies nothing of the failed atomic batch",
+			userID:   "1",
+			atomic:   true,
+			wantErrs: []error{market.ErrBatchAborted, market.ErrBatchAborted, &market.ErrPermission{}, market.ErrProductNotFound},
+			wantProducts: []market.Product{
+				{ID: 1, Name: "Banana", Price: 1500, Seller: "1"},
+				{ID: 2, Name: "Carrot", Price: 1400, Seller: "2"},
+			},
+		},
+		{
+			name:     "Rejects the changes of an unknown user",
+			userID:   "9",
+			wantErrs: []error{&market.ErrPermission{}, &market.ErrPermission{}, &market.ErrPermission{}, market.ErrProductNotFound},
+			wantProducts: []market.Product{
+				{ID: 1, Name: "Banana", Price: 1500, Seller: "1"},
+				{ID: 2, Name: "Carrot", Price: 1400, Seller: "2"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			products := mem.NewProductService()
+			m := &market.Market{UserService: mem.NewUserService(), ProductService: products}
+
+			results, err := m.Batch(context.Background(), ops, tt.userID, tt.atomic)
+			if err != nil {
+				t.Fatalf("Market.Batch() unexpected error: %v", err)
+			}
+			if len(results) != len(ops) {
+				t.Fatalf("Market.Batch() = %d results, want %d", len(results), len(ops))
+			}
+			for i, r := range results {
+				if tt.wantErrs[i] == nil && r.Err != nil || tt.wantErrs[i] != nil && !errors.Is(r.Err, tt.wantErrs[i]) {
+					t.Errorf("result %d error = %v, want %v", i, r.Err, tt.wantErrs[i])
+				}
+				if (r.Err == nil) != (r.Product != nil) {
+					t.Errorf("result %d = %+v, want either a product or an error", i, r)
+				}
+			}
+
+			ps, _ := products.Products(context.Background())
+			got := make([]market.Product, len(ps))
+			for i, p := range ps {
+				got[i] = *p
+			}
+			if !reflect.DeepEqual(got, tt.wantProducts) {
+				t.Errorf("products = %v, want %v", got, tt.wantProducts)
+			}
+		})
+	}
+}
+
+func TestMarket_BatchServiceError(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	ps := mock.NewMockProductService(ctrl)
+	ps.EXPECT().Product(gomock.Any(), 1).Return(&market.Product{ID: 1, Seller: "1"}, nil)
+	ps.EXPECT().Batch(gomock.Any(), gomock.Any(), true).Return(nil, errors.New("connection lost"))
+	m := &market.Market{ProductService: ps}
+
+	_, err := m.Batch(context.Background(), []market.Operation{{Op: market.OpDelete, Product: market.Product{ID: 1}}}, "1", true)
+	if err == nil {
+		t.Errorf("Market.Batch() error = nil, want the service error")
+	}
+}
+
+// staleProductService reports every product sold by the user,
+// as if it changed hands after the check.
+type staleProductService struct {
+	*mem.ProductService
+	userID string
+}
+
+func (srv staleProductService) Product(ctx context.Context, id int) (*market.Product, error) {
+	p, err := srv.ProductService.Product(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	stale := *p
+	stale.Seller = srv.userID
+	return &stale, nil
+}
+
+func TestMarket_BatchSellerChanged(t *testing.T) {
+	// The product 2 of mem.NewProductService is of the user 2.
+	products := mem.NewProductService()
+	m := &market.Market{
+		UserService:    mem.NewUserService(),
+		ProductService: staleProductService{ProductService: products, userID: "1"},
+	}
+
+	ops := []market.Operation{
+		{Op: market.OpReplace, Product: market.Product{ID: 2, Name: "Pear", Price: 1}},
+		{Op: market.OpDelete, Product: market.Product{ID: 2}},
+	}
+	results, err := m.Batch(context.Background(), ops, "1", false)
+	if err != nil {
+		t.Fatalf("Market.Batch() unexpected error: %v", err)
+	}
+	for i, r := range results {
+		if !errors.Is(r.Err, &market.ErrPermission{}) {
+			t.Errorf("result %d error = %v, want %v", i, r.Err, &market.ErrPermission{})
+		}
+	}
+	if p, _ := products.Product(context.Background(), 2); p == nil || p.Seller != "2" || p.Name != "Carrot" {
+		t.Errorf("product 2 = %v, want it unchanged", p)
+	}
+}
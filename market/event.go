@@ -0,0 +1,70 @@
+package market
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"time"
+)
+
+// Event is a domain event emitted by Market after a successful mutation.
+type Event interface {
+	// EventType returns the event name, e.g. "product.added".
+	EventType() string
+	// Metadata returns the data common to all events.
+	Metadata() EventMeta
+}
+
+// EventPublisher delivers events to interested subsystems.
+type EventPublisher interface {
+	Publish(e Event)
+}
+
+// EventMeta holds the data common to all events.
+type EventMeta struct {
+	ID         string
+	OccurredAt time.Time
+}
+
+// NewEventMeta returns the metadata for an event occurring right now.
+func NewEventMeta() EventMeta {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return EventMeta{
+		ID:         hex.EncodeToString(b),
+		OccurredAt: time.Now().UTC(),
+	}
+}
+
+const (
+	EventProductAdded    = "product.added"
+	EventProductReplaced = "product.replaced"
+	EventProductDeleted  = "product.deleted"
+)
+
+// ProductAdded is emitted when a new product appears on the market.
+type ProductAdded struct {
+	Meta    EventMeta
+	Product Product
+}
+
+func (e ProductAdded) EventType() string   { return EventProductAdded }
+func (e ProductAdded) Metadata() EventMeta { return e.Meta }
+
+// ProductReplaced is emitted when the product information is replaced.
+type ProductReplaced struct {
+	Meta    EventMeta
+	Product Product
+}
+
+func (e ProductReplaced) EventType() string   { return EventProductReplaced }
+func (e ProductReplaced) Metadata() EventMeta { return e.Meta }
+
+// ProductDeleted is emitted when the product is removed from the market.
+// Product holds the last known state of the deleted product.
+type ProductDeleted struct {
+	Meta    EventMeta
+	Product Product
+}
+
+func (e ProductDeleted) EventType() string   { return EventProductDeleted }
+func (e ProductDeleted) Metadata() EventMeta { return e.Meta }
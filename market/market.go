@@ -1,8 +1,12 @@
 package market
 
 import (
+	"context"
 	"errors"
 	"fmt"
+
+	"github.com/ortymid/t2-http/logging"
+	"github.com/ortymid/t2-http/trace"
 )
 
 // ErrPermission is an error returned when a user does not have rights
@@ -26,11 +30,13 @@ func (err *ErrPermission) Unwrap() error {
 
 // Interface may be used by protocol layers for RPC or mocking.
 type Interface interface {
-	Products() ([]*Product, error)
-	Product(id int) (*Product, error)
-	AddProduct(p *Product, userID string) (*Product, error)
-	ReplaceProduct(p *Product, userID string) (*Product, error)
-	DeleteProduct(id int, userID string) error
+	Products(ctx context.Context) ([]*Product, error)
+	Product(ctx context.Context, id int) (*Product, error)
+	AddProduct(ctx context.Context, p *Product, userID string) (*Product, error)
+	ReplaceProduct(ctx context.Context, p *Product, userID string) (*Product, error)
+	DeleteProduct(ctx context.Context, id int, userID string) error
+	Batch(ctx context.Context, ops []Operation, userID string, atomic bool) ([]OperationResult, error)
+	CheckBatch(ctx context.Context, ops []Operation, userID string) ([]OperationResult, error)
 }
 
 // Market composes business logic from different services.
@@ -38,11 +44,39 @@ type Market struct {
 	AuthService    AuthService
 	UserService    UserService
 	ProductService ProductService
+
+	// Events receives the events about market changes. May be nil.
+	Events EventPublisher
+	// Logger records the market changes. Defaults to logging.Default().
+	Logger *logging.Logger
+}
+
+// logger returns the market logger with the request ID of the context.
+func (m *Market) logger(ctx context.Context) *logging.Logger {
+	l := m.Logger
+	if l == nil {
+		l = logging.Default()
+	}
+	if id := logging.RequestID(ctx); id != "" {
+		l = l.With("request_id", id)
+	}
+	return l
+}
+
+// publish sends the event to the publisher if there is one.
+func (m *Market) publish(e Event) {
+	if m.Events == nil {
+		return
+	}
+	m.Events.Publish(e)
 }
 
 // Products returns all products on the market.
-func (m *Market) Products() ([]*Product, error) {
-	ps, err := m.ProductService.Products()
+func (m *Market) Products(ctx context.Context) (_ []*Product, err error) {
+	ctx, span := trace.Start(ctx, "Market.Products")
+	defer span.EndWith(&err)
+
+	ps, err := m.ProductService.Products(ctx)
 	if err != nil {
 		err = fmt.Errorf("products: %w", err)
 		return nil, err
@@ -51,8 +85,11 @@ func (m *Market) Products() ([]*Product, error) {
 }
 
 // Product finds the product by its ID.
-func (m *Market) Product(id int) (*Product, error) {
-	p, err := m.ProductService.Product(id)
+func (m *Market) Product(ctx context.Context, id int) (_ *Product, err error) {
+	ctx, span := trace.Start(ctx, "Market.Product", "product_id", id)
+	defer span.EndWith(&err)
+
+	p, err := m.ProductService.Product(ctx, id)
 	if err != nil {
 		err = fmt.Errorf("product: %w", err)
 		return nil, err
@@ -60,9 +97,12 @@ func (m *Market) Product(id int) (*Product, error) {
 	return p, nil
 }
 
-func (m *Market) AddProduct(p *Product, userID string) (*Product, error) {
+func (m *Market) AddProduct(ctx context.Context, p *Product, userID string) (_ *Product, err error) {
+	ctx, span := trace.Start(ctx, "Market.AddProduct", "user_id", userID)
+	defer span.EndWith(&err)
+
 	// Check the user for permission. Only the existence of the user counts yet.
-	_, err := m.UserService.User(userID)
+	_, err = m.UserService.User(ctx, userID)
 	if errors.Is(err, &ErrUserNotFound{}) {
 		err = fmt.Errorf("add product: %w", &ErrPermission{Reason: err})
 		return nil, err
@@ -73,18 +113,26 @@ func (m *Market) AddProduct(p *Product, userID string) (*Product, error) {
 	}
 
 	// After the user check, add the product.
-	p, err = m.ProductService.AddProduct(p)
+	p, err = m.ProductService.AddProduct(ctx, p)
 	if err != nil {
 		err = fmt.Errorf("add product: %w", err)
 		return nil, err
 	}
+
+	span.SetAttributes("product_id", p.ID)
+	m.logger(ctx).Info("product added", "product_id", p.ID, "user_id", userID)
+	m.publish(ProductAdded{Meta: NewEventMeta(), Product: *p})
 	return p, nil
 }
 
-// ReplaceProduct updates information about the product with the new one by product ID.
-func (m *Market) ReplaceProduct(p *Product, userID string) (*Product, error) {
+// ReplaceProduct updates information about the product with the new one by product ID
+// checking the product is sold by the user.
+func (m *Market) ReplaceProduct(ctx context.Context, p *Product, userID string) (_ *Product, err error) {
+	ctx, span := trace.Start(ctx, "Market.ReplaceProduct", "product_id", p.ID, "user_id", userID)
+	defer span.EndWith(&err)
+
 	// Check the user for permission. Only the existence of the user counts yet.
-	_, err := m.UserService.User(userID)
+	_, err = m.UserService.User(ctx, userID)
 	if errors.Is(err, &ErrUserNotFound{}) {
 		err = fmt.Errorf("edit product: %w", &ErrPermission{Reason: err})
 		return nil, err
@@ -94,20 +142,32 @@ func (m *Market) ReplaceProduct(p *Product, userID string) (*Product, error) {
 		return nil, err
 	}
 
-	// After the user check, add the product.
-	p, err = m.ProductService.ReplaceProduct(p)
+	// The product must be sold by the user, who stays its seller.
+	if err = m.checkSeller(ctx, p.ID, userID); err != nil {
+		err = fmt.Errorf("edit product: %w", err)
+		return nil, err
+	}
+	p.Seller = userID
+
+	p, err = m.ProductService.ReplaceProduct(ctx, p)
 	if err != nil {
 		err = fmt.Errorf("edit product: %w", err)
 		return nil, err
 	}
+
+	m.logger(ctx).Info("product replaced", "product_id", p.ID, "user_id", userID)
+	m.publish(ProductReplaced{Meta: NewEventMeta(), Product: *p})
 	return p, nil
 }
 
 // DeleteProduct deletes the product from the market by its ID
 // checking the permission to do it by user ID.
-func (m *Market) DeleteProduct(id int, userID string) error {
+func (m *Market) DeleteProduct(ctx context.Context, id int, userID string) (err error) {
+	ctx, span := trace.Start(ctx, "Market.DeleteProduct", "product_id", id, "user_id", userID)
+	defer span.EndWith(&err)
+
 	// Obtain the product.
-	product, err := m.ProductService.Product(id)
+	product, err := m.ProductService.Product(ctx, id)
 	if err != nil {
 		err = fmt.Errorf("delete product: %w", err)
 		return err
@@ -120,10 +180,13 @@ func (m *Market) DeleteProduct(id int, userID string) error {
 	}
 
 	// Perform deletion.
-	err = m.ProductService.DeleteProduct(id)
+	err = m.ProductService.DeleteProduct(ctx, id)
 	if err != nil {
 		err = fmt.Errorf("delete product: %w", err)
 		return err
 	}
+
+	m.logger(ctx).Info("product deleted", "product_id", id, "user_id", userID)
+	m.publish(ProductDeleted{Meta: NewEventMeta(), Product: *product})
 	return nil
 }
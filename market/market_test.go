@@ -1,6 +1,7 @@
 package market_test
 
 import (
+	"context"
 	"reflect"
 	"testing"
 
@@ -21,9 +22,9 @@ type MockUserService struct {
 
 func (opt *MockUserService) Setup(m *mock.MockUserService) {
 	if opt.User.expect {
-		m.EXPECT().User(gomock.Eq(opt.User.argID)).Return(opt.User.returnUser, opt.User.returnErr)
+		m.EXPECT().User(gomock.Any(), gomock.Eq(opt.User.argID)).Return(opt.User.returnUser, opt.User.returnErr)
 	} else {
-		m.EXPECT().User(nil).MaxTimes(0)
+		m.EXPECT().User(gomock.Any(), gomock.Any()).MaxTimes(0)
 	}
 }
 
@@ -65,29 +66,29 @@ type MockProductService struct {
 
 func (opt *MockProductService) Setup(m *mock.MockProductService) {
 	if opt.Products.expect {
-		m.EXPECT().Products().Return(opt.Products.returnProducts, opt.Products.returnErr)
+		m.EXPECT().Products(gomock.Any()).Return(opt.Products.returnProducts, opt.Products.returnErr)
 	} else {
-		m.EXPECT().Products().MaxTimes(0)
+		m.EXPECT().Products(gomock.Any()).MaxTimes(0)
 	}
 	if opt.Product.expect {
-		m.EXPECT().Product(opt.Product.argID).Return(opt.Product.returnProduct, opt.Product.returnErr)
+		m.EXPECT().Product(gomock.Any(), opt.Product.argID).Return(opt.Product.returnProduct, opt.Product.returnErr)
 	} else {
-		m.EXPECT().Product(nil).MaxTimes(0)
+		m.EXPECT().Product(gomock.Any(), gomock.Any()).MaxTimes(0)
 	}
 	if opt.AddProduct.expect {
-		m.EXPECT().AddProduct(opt.AddProduct.argProduct).Return(opt.AddProduct.returnProduct, opt.AddProduct.returnErr)
+		m.EXPECT().AddProduct(gomock.Any(), opt.AddProduct.argProduct).Return(opt.AddProduct.returnProduct, opt.AddProduct.returnErr)
 	} else {
-		m.EXPECT().AddProduct(nil).MaxTimes(0)
+		m.EXPECT().AddProduct(gomock.Any(), gomock.Any()).MaxTimes(0)
 	}
 	if opt.ReplaceProduct.expect {
-		m.EXPECT().ReplaceProduct(opt.ReplaceProduct.argProduct).Return(opt.ReplaceProduct.returnProduct, opt.ReplaceProduct.returnErr)
+		m.EXPECT().ReplaceProduct(gomock.Any(), opt.ReplaceProduct.argProduct).Return(opt.ReplaceProduct.returnProduct, opt.ReplaceProduct.returnErr)
 	} else {
-		m.EXPECT().ReplaceProduct(nil).MaxTimes(0)
+		m.EXPECT().ReplaceProduct(gomock.Any(), gomock.Any()).MaxTimes(0)
 	}
 	if opt.DeleteProduct.expect {
-		m.EXPECT().DeleteProduct(opt.DeleteProduct.argID).Return(opt.DeleteProduct.returnErr)
+		m.EXPECT().DeleteProduct(gomock.Any(), opt.DeleteProduct.argID).Return(opt.DeleteProduct.returnErr)
 	} else {
-		m.EXPECT().DeleteProduct(nil).MaxTimes(0)
+		m.EXPECT().DeleteProduct(gomock.Any(), gomock.Any()).MaxTimes(0)
 	}
 }
 
@@ -148,7 +149,7 @@ func TestMarket_Products(t *testing.T) {
 				UserService:    us,
 				ProductService: ps,
 			}
-			got, err := m.Products()
+			got, err := m.Products(context.Background())
 			if (err != nil) != tt.wantErr {
 				t.Errorf("Market.Products() error = %v, wantErr %v", err, tt.wantErr)
 				return
@@ -223,7 +224,7 @@ func TestMarket_Product(t *testing.T) {
 				UserService:    us,
 				ProductService: ps,
 			}
-			got, err := m.Product(tt.args.id)
+			got, err := m.Product(context.Background(), tt.args.id)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("Market.Products() error = %v, wantErr %v", err, tt.wantErr)
 				return
@@ -308,7 +309,7 @@ func TestMarket_AddProduct(t *testing.T) {
 				UserService:    us,
 				ProductService: ps,
 			}
-			got, err := m.AddProduct(tt.args.p, tt.args.userID)
+			got, err := m.AddProduct(context.Background(), tt.args.p, tt.args.userID)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("Market.Products() error = %v, wantErr %v", err, tt.wantErr)
 				return
@@ -347,6 +348,11 @@ func TestMarket_ReplaceProduct(t *testing.T) {
 					},
 				},
 				ProductService: MockProductService{
+					Product: MockFuncProduct{
+						expect:        true,
+						argID:         1,
+						returnProduct: &market.Product{ID: 1, Name: "p1", Price: 100, Seller: "1"},
+					},
 					ReplaceProduct: MockFuncReplaceProduct{
 						expect:        true,
 						argProduct:    &market.Product{ID: 1, Name: "p2", Price: 200, Seller: "1"},
@@ -355,11 +361,59 @@ func TestMarket_ReplaceProduct(t *testing.T) {
 				},
 			},
 			args: args{
-				p:      &market.Product{ID: 1, Name: "p2", Price: 200, Seller: "1"},
+				p:      &market.Product{ID: 1, Name: "p2", Price: 200},
 				userID: "1",
 			},
 			want: &market.Product{ID: 1, Name: "p2", Price: 200, Seller: "1"},
 		},
+		{
+			name: "Returns an error for a product of another seller",
+			mocks: mocks{
+				UserService: MockUserService{
+					User: MockFuncUser{
+						expect:     true,
+						argID:      "2",
+						returnUser: &market.User{ID: "2", Name: "u2"},
+					},
+				},
+				ProductService: MockProductService{
+					Product: MockFuncProduct{
+						expect:        true,
+						argID:         1,
+						returnProduct: &market.Product{ID: 1, Name: "p1", Price: 100, Seller: "1"},
+					},
+				},
+			},
+			args: args{
+				p:      &market.Product{ID: 1, Name: "p2", Price: 200, Seller: "2"},
+				userID: "2",
+			},
+			wantErr: true,
+		},
+		{
+			name: "Returns an error for not existing product",
+			mocks: mocks{
+				UserService: MockUserService{
+					User: MockFuncUser{
+						expect:     true,
+						argID:      "1",
+						returnUser: &market.User{ID: "1", Name: "u1"},
+					},
+				},
+				ProductService: MockProductService{
+					Product: MockFuncProduct{
+						expect:    true,
+						argID:     9,
+						returnErr: market.ErrProductNotFound,
+					},
+				},
+			},
+			args: args{
+				p:      &market.Product{ID: 9, Name: "p2", Price: 200},
+				userID: "1",
+			},
+			wantErr: true,
+		},
 		{
 			name: "Returns an error for not existing user",
 			mocks: mocks{
@@ -393,7 +447,7 @@ func TestMarket_ReplaceProduct(t *testing.T) {
 				UserService:    us,
 				ProductService: ps,
 			}
-			got, err := m.ReplaceProduct(tt.args.p, tt.args.userID)
+			got, err := m.ReplaceProduct(context.Background(), tt.args.p, tt.args.userID)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("Market.Products() error = %v, wantErr %v", err, tt.wantErr)
 				return
@@ -492,7 +546,7 @@ func TestMarket_DeleteProduct(t *testing.T) {
 				UserService:    us,
 				ProductService: ps,
 			}
-			err := m.DeleteProduct(tt.args.id, tt.args.userID)
+			err := m.DeleteProduct(context.Background(), tt.args.id, tt.args.userID)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("Market.Products() error = %v, wantErr %v", err, tt.wantErr)
 				return
@@ -500,3 +554,110 @@ func TestMarket_DeleteProduct(t *testing.T) {
 		})
 	}
 }
+
+type RecordingPublisher struct {
+	Events []market.Event
+}
+
+func (p *RecordingPublisher) Publish(e market.Event) {
+	p.Events = append(p.Events, e)
+}
+
+func TestMarket_Events(t *testing.T) {
+	product := &market.Product{ID: 1, Name: "p1", Price: 100, Seller: "1"}
+
+	tests := []struct {
+		name     string
+		mocks    MockProductService
+		do       func(m *market.Market) error
+		wantType string
+	}{
+		{
+			name: "Publishes ProductAdded",
+			mocks: MockProductService{
+				AddProduct: MockFuncAddProduct{
+					expect:        true,
+					argProduct:    &market.Product{Name: "p1", Price: 100, Seller: "1"},
+					returnProduct: product,
+				},
+			},
+			do: func(m *market.Market) error {
+				_, err := m.AddProduct(context.Background(), &market.Product{Name: "p1", Price: 100, Seller: "1"}, "1")
+				return err
+			},
+			wantType: market.EventProductAdded,
+		},
+		{
+			name: "Publishes ProductReplaced",
+			mocks: MockProductService{
+				Product: MockFuncProduct{
+					expect:        true,
+					argID:         1,
+					returnProduct: product,
+				},
+				ReplaceProduct: MockFuncReplaceProduct{
+					expect:        true,
+					argProduct:    product,
+					returnProduct: product,
+				},
+			},
+			do: func(m *market.Market) error {
+				_, err := m.ReplaceProduct(context.Background(), product, "1")
+				return err
+			},
+			wantType: market.EventProductReplaced,
+		},
+		{
+			name: "Publishes ProductDeleted",
+			mocks: MockProductService{
+				Product: MockFuncProduct{
+					expect:        true,
+					argID:         1,
+					returnProduct: product,
+				},
+				DeleteProduct: MockFuncDeleteProduct{
+					expect: true,
+					argID:  1,
+				},
+			},
+			do: func(m *market.Market) error {
+				return m.DeleteProduct(context.Background(), 1, "1")
+			},
+			wantType: market.EventProductDeleted,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			us := mock.NewMockUserService(ctrl)
+			us.EXPECT().User(gomock.Any(), "1").Return(&market.User{ID: "1", Name: "u1"}, nil).AnyTimes()
+
+			ps := mock.NewMockProductService(ctrl)
+			tt.mocks.Setup(ps)
+
+			pub := &RecordingPublisher{}
+			m := &market.Market{
+				UserService:    us,
+				ProductService: ps,
+				Events:         pub,
+			}
+			if err := tt.do(m); err != nil {
+				t.Errorf("unexpected error: %v", err)
+				return
+			}
+			if len(pub.Events) != 1 {
+				t.Errorf("published %d events, want 1", len(pub.Events))
+				return
+			}
+			e := pub.Events[0]
+			if e.EventType() != tt.wantType {
+				t.Errorf("EventType() = %s, want %s", e.EventType(), tt.wantType)
+			}
+			if e.Metadata().ID == "" {
+				t.Errorf("Metadata().ID is empty")
+			}
+		})
+	}
+}
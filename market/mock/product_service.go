@@ -5,6 +5,7 @@
 package mock
 
 import (
+	context "context"
 	gomock "github.com/golang/mock/gomock"
 	market "github.com/ortymid/t2-http/market"
 	reflect "reflect"
@@ -34,75 +35,90 @@ func (m *MockProductService) EXPECT() *MockProductServiceMockRecorder {
 }
 
 // AddProduct mocks base method
-func (m *MockProductService) AddProduct(arg0 *market.Product) (*market.Product, error) {
+func (m *MockProductService) AddProduct(arg0 context.Context, arg1 *market.Product) (*market.Product, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "AddProduct", arg0)
+	ret := m.ctrl.Call(m, "AddProduct", arg0, arg1)
 	ret0, _ := ret[0].(*market.Product)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
 // AddProduct indicates an expected call of AddProduct
-func (mr *MockProductServiceMockRecorder) AddProduct(arg0 interface{}) *gomock.Call {
+func (mr *MockProductServiceMockRecorder) AddProduct(arg0, arg1 interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AddProduct", reflect.TypeOf((*MockProductService)(nil).AddProduct), arg0)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AddProduct", reflect.TypeOf((*MockProductService)(nil).AddProduct), arg0, arg1)
+}
+
+// Batch mocks base method
+func (m *MockProductService) Batch(arg0 context.Context, arg1 []market.Operation, arg2 bool) ([]market.OperationResult, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Batch", arg0, arg1, arg2)
+	ret0, _ := ret[0].([]market.OperationResult)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Batch indicates an expected call of Batch
+func (mr *MockProductServiceMockRecorder) Batch(arg0, arg1, arg2 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Batch", reflect.TypeOf((*MockProductService)(nil).Batch), arg0, arg1, arg2)
 }
 
 // DeleteProduct mocks base method
-func (m *MockProductService) DeleteProduct(arg0 int) error {
+func (m *MockProductService) DeleteProduct(arg0 context.Context, arg1 int) error {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "DeleteProduct", arg0)
+	ret := m.ctrl.Call(m, "DeleteProduct", arg0, arg1)
 	ret0, _ := ret[0].(error)
 	return ret0
 }
 
 // DeleteProduct indicates an expected call of DeleteProduct
-func (mr *MockProductServiceMockRecorder) DeleteProduct(arg0 interface{}) *gomock.Call {
+func (mr *MockProductServiceMockRecorder) DeleteProduct(arg0, arg1 interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteProduct", reflect.TypeOf((*MockProductService)(nil).DeleteProduct), arg0)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteProduct", reflect.TypeOf((*MockProductService)(nil).DeleteProduct), arg0, arg1)
 }
 
 // Product mocks base method
-func (m *MockProductService) Product(arg0 int) (*market.Product, error) {
+func (m *MockProductService) Product(arg0 context.Context, arg1 int) (*market.Product, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "Product", arg0)
+	ret := m.ctrl.Call(m, "Product", arg0, arg1)
 	ret0, _ := ret[0].(*market.Product)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
 // Product indicates an expected call of Product
-func (mr *MockProductServiceMockRecorder) Product(arg0 interface{}) *gomock.Call {
+func (mr *MockProductServiceMockRecorder) Product(arg0, arg1 interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Product", reflect.TypeOf((*MockProductService)(nil).Product), arg0)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Product", reflect.TypeOf((*MockProductService)(nil).Product), arg0, arg1)
 }
 
 // Products mocks base method
-func (m *MockProductService) Products() ([]*market.Product, error) {
+func (m *MockProductService) Products(arg0 context.Context) ([]*market.Product, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "Products")
+	ret := m.ctrl.Call(m, "Products", arg0)
 	ret0, _ := ret[0].([]*market.Product)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
 // Products indicates an expected call of Products
-func (mr *MockProductServiceMockRecorder) Products() *gomock.Call {
+func (mr *MockProductServiceMockRecorder) Products(arg0 interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Products", reflect.TypeOf((*MockProductService)(nil).Products))
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Products", reflect.TypeOf((*MockProductService)(nil).Products), arg0)
 }
 
 // ReplaceProduct mocks base method
-func (m *MockProductService) ReplaceProduct(arg0 *market.Product) (*market.Product, error) {
+func (m *MockProductService) ReplaceProduct(arg0 context.Context, arg1 *market.Product) (*market.Product, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "ReplaceProduct", arg0)
+	ret := m.ctrl.Call(m, "ReplaceProduct", arg0, arg1)
 	ret0, _ := ret[0].(*market.Product)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
 // ReplaceProduct indicates an expected call of ReplaceProduct
-func (mr *MockProductServiceMockRecorder) ReplaceProduct(arg0 interface{}) *gomock.Call {
+func (mr *MockProductServiceMockRecorder) ReplaceProduct(arg0, arg1 interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ReplaceProduct", reflect.TypeOf((*MockProductService)(nil).ReplaceProduct), arg0)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ReplaceProduct", reflect.TypeOf((*MockProductService)(nil).ReplaceProduct), arg0, arg1)
 }
@@ -5,6 +5,7 @@
 package mock
 
 import (
+	context "context"
 	gomock "github.com/golang/mock/gomock"
 	market "github.com/ortymid/t2-http/market"
 	reflect "reflect"
@@ -34,16 +35,16 @@ func (m *MockUserService) EXPECT() *MockUserServiceMockRecorder {
 }
 
 // User mocks base method
-func (m *MockUserService) User(arg0 string) (*market.User, error) {
+func (m *MockUserService) User(arg0 context.Context, arg1 string) (*market.User, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "User", arg0)
+	ret := m.ctrl.Call(m, "User", arg0, arg1)
 	ret0, _ := ret[0].(*market.User)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
 // User indicates an expected call of User
-func (mr *MockUserServiceMockRecorder) User(arg0 interface{}) *gomock.Call {
+func (mr *MockUserServiceMockRecorder) User(arg0, arg1 interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "User", reflect.TypeOf((*MockUserService)(nil).User), arg0)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "User", reflect.TypeOf((*MockUserService)(nil).User), arg0, arg1)
 }
@@ -1,6 +1,7 @@
 package market
 
 import (
+	"context"
 	"errors"
 	"fmt"
 )
@@ -11,11 +12,16 @@ var ErrProductNotFound = errors.New("product not found")
 
 // ProductService represents a product data backend.
 type ProductService interface {
-	Products() ([]*Product, error)
-	Product(int) (*Product, error)
-	AddProduct(*Product) (*Product, error)
-	ReplaceProduct(*Product) (*Product, error)
-	DeleteProduct(int) error
+	Products(context.Context) ([]*Product, error)
+	Product(context.Context, int) (*Product, error)
+	AddProduct(context.Context, *Product) (*Product, error)
+	ReplaceProduct(context.Context, *Product) (*Product, error)
+	DeleteProduct(context.Context, int) error
+	// Batch applies the operations in a single transaction. The atomic
+	// batch applies all of them or none, the results of the operations
+	// not applied because of another one are ErrBatchAborted.
+	// The other batch applies every operation it can.
+	Batch(ctx context.Context, ops []Operation, atomic bool) ([]OperationResult, error)
 }
 
 type Product struct {
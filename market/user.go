@@ -1,6 +1,7 @@
 package market
 
 import (
+	"context"
 	"fmt"
 )
 
@@ -24,9 +25,29 @@ func (err *ErrUserNotFound) Is(target error) bool {
 
 // var ErrUserNotFound = errors.New("user not found")
 
+// ErrUnavailable is an error returned when a backing service cannot serve
+// the request at the moment, as opposed to the request itself being wrong.
+type ErrUnavailable struct {
+	Service string
+	Reason  error
+}
+
+func (err *ErrUnavailable) Error() string {
+	return fmt.Sprintf("%s unavailable: %s", err.Service, err.Reason)
+}
+
+func (err *ErrUnavailable) Is(target error) bool {
+	_, ok := target.(*ErrUnavailable)
+	return ok
+}
+
+func (err *ErrUnavailable) Unwrap() error {
+	return err.Reason
+}
+
 // UserService represents a user data backend.
 type UserService interface {
-	User(id string) (*User, error)
+	User(ctx context.Context, id string) (*User, error)
 }
 
 type User struct {
@@ -0,0 +1,334 @@
+// Package metrics collects the service metrics and exposes them
+// in the Prometheus text exposition format.
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultBuckets are the latency histogram bounds in seconds.
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// collector is a metric family of the registry.
+type collector interface {
+	name() string
+	write(w *bufio.Writer)
+}
+
+// Registry holds the metrics and serves them on HTTP.
+type Registry struct {
+	mu         sync.Mutex
+	collectors []collector
+	names      map[string]bool
+}
+
+func NewRegistry() *Registry {
+	return &Registry{names: make(map[string]bool)}
+}
+
+func (reg *Registry) register(c collector) {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+
+	if reg.names[c.name()] {
+		panic(fmt.Sprintf("metrics: %s registered twice", c.name()))
+	}
+	reg.names[c.name()] = true
+	reg.collectors = append(reg.collectors, c)
+}
+
+// WriteTo writes all the metrics sorted by name.
+func (reg *Registry) WriteTo(w io.Writer) (int64, error) {
+	reg.mu.Lock()
+	collectors := make([]collector, len(reg.collectors))
+	copy(collectors, reg.collectors)
+	reg.mu.Unlock()
+
+	sort.Slice(collectors, func(i, j int) bool {
+		return collectors[i].name() < collectors[j].name()
+	})
+
+	cw := &countingWriter{w: w}
+	bw := bufio.NewWriter(cw)
+	for _, c := range collectors {
+		c.write(bw)
+	}
+	err := bw.Flush()
+	return cw.n, err
+}
+
+// ServeHTTP serves the metrics to the scraper.
+func (reg *Registry) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	_, _ = reg.WriteTo(w)
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	return n, err
+}
+
+// vec keeps the series of a metric by their label values.
+type vec struct {
+	metricName string
+	help       string
+	typ        string
+	labels     []string
+
+	mu     sync.Mutex
+	series map[string]*series
+}
+
+type series struct {
+	labelValues []string
+	value       float64   // counters and gauges
+	counts      []uint64  // histogram buckets, not cumulative
+	sum         float64   // histogram
+	count       uint64    // histogram
+	buckets     []float64 // histogram upper bounds
+}
+
+func newVec(name, help, typ string, labels []string) *vec {
+	return &vec{metricName: name, help: help, typ: typ, labels: labels, series: make(map[string]*series)}
+}
+
+func (v *vec) name() string {
+	return v.metricName
+}
+
+// get finds the series of the label values. The caller must hold the lock.
+func (v *vec) get(labelValues []string) *series {
+	if len(labelValues) != len(v.labels) {
+		panic(fmt.Sprintf("metrics: %s expects %d label values, got %d", v.metricName, len(v.labels), len(labelValues)))
+	}
+	key := strings.Join(labelValues, "\xff")
+	s, ok := v.series[key]
+	if !ok {
+		s = &series{labelValues: append([]string(nil), labelValues...)}
+		v.series[key] = s
+	}
+	return s
+}
+
+// sorted returns the series in a stable order. The caller must hold the lock.
+func (v *vec) sorted() []*series {
+	keys := make([]string, 0, len(v.series))
+	for k := range v.series {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	ss := make([]*series, len(keys))
+	for i, k := range keys {
+		ss[i] = v.series[k]
+	}
+	return ss
+}
+
+func (v *vec) writeHeader(w *bufio.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n", v.metricName, escapeHelp(v.help))
+	fmt.Fprintf(w, "# TYPE %s %s\n", v.metricName, v.typ)
+}
+
+// CounterVec is a counter partitioned by the labels.
+type CounterVec struct {
+	*vec
+}
+
+// NewCounterVec registers a counter. The name should end with "_total".
+func (reg *Registry) NewCounterVec(name, help string, labels ...string) *CounterVec {
+	c := &CounterVec{newVec(name, help, "counter", labels)}
+	reg.register(c)
+	return c
+}
+
+// Add increases the counter of the label values. The delta must not be negative.
+func (c *CounterVec) Add(delta float64, labelValues ...string) {
+	if delta < 0 {
+		panic("metrics: counter cannot decrease")
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.get(labelValues).value += delta
+}
+
+// Inc increases the counter of the label values by one.
+func (c *CounterVec) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+func (c *CounterVec) write(w *bufio.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.writeHeader(w)
+	for _, s := range c.sorted() {
+		writeSample(w, c.metricName, c.labels, s.labelValues, "", "", s.value)
+	}
+}
+
+// HistogramVec is a histogram partitioned by the labels.
+type HistogramVec struct {
+	*vec
+	buckets []float64
+}
+
+// NewHistogramVec registers a histogram with the bucket upper bounds,
+// DefaultBuckets if none are given.
+func (reg *Registry) NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	if len(buckets) == 0 {
+		buckets = DefaultBuckets
+	}
+	buckets = append([]float64(nil), buckets...)
+	sort.Float64s(buckets)
+
+	h := &HistogramVec{vec: newVec(name, help, "histogram", labels), buckets: buckets}
+	reg.register(h)
+	return h
+}
+
+// Observe records the value for the label values.
+func (h *HistogramVec) Observe(value float64, labelValues ...string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	s := h.get(labelValues)
+	if s.counts == nil {
+		s.counts = make([]uint64, len(h.buckets))
+	}
+	i := sort.SearchFloat64s(h.buckets, value)
+	if i < len(h.buckets) {
+		s.counts[i]++
+	}
+	s.sum += value
+	s.count++
+}
+
+// ObserveDuration records the duration in seconds.
+func (h *HistogramVec) ObserveDuration(d time.Duration, labelValues ...string) {
+	h.Observe(d.Seconds(), labelValues...)
+}
+
+func (h *HistogramVec) write(w *bufio.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.writeHeader(w)
+	for _, s := range h.sorted() {
+		var cumulative uint64
+		for i, bound := range h.buckets {
+			cumulative += s.counts[i]
+			writeSample(w, h.metricName+"_bucket", h.labels, s.labelValues, "le", formatFloat(bound), float64(cumulative))
+		}
+		writeSample(w, h.metricName+"_bucket", h.labels, s.labelValues, "le", "+Inf", float64(s.count))
+		writeSample(w, h.metricName+"_sum", h.labels, s.labelValues, "", "", s.sum)
+		writeSample(w, h.metricName+"_count", h.labels, s.labelValues, "", "", float64(s.count))
+	}
+}
+
+// GaugeFunc is a gauge read at the scrape time.
+type GaugeFunc struct {
+	metricName string
+	help       string
+	typ        string
+	labels     []string
+	labelValue []string
+	f          func() float64
+}
+
+// NewGaugeFunc registers a gauge computed by f. The optional
+// constant labels are given as alternating names and values.
+func (reg *Registry) NewGaugeFunc(name, help string, f func() float64, constLabels ...string) *GaugeFunc {
+	g := newGaugeFunc(name, help, "gauge", f, constLabels)
+	reg.register(g)
+	return g
+}
+
+func newGaugeFunc(name, help, typ string, f func() float64, constLabels []string) *GaugeFunc {
+	g := &GaugeFunc{metricName: name, help: help, typ: typ, f: f}
+	for i := 0; i+1 < len(constLabels); i += 2 {
+		g.labels = append(g.labels, constLabels[i])
+		g.labelValue = append(g.labelValue, constLabels[i+1])
+	}
+	return g
+}
+
+func (g *GaugeFunc) name() string {
+	return g.metricName
+}
+
+func (g *GaugeFunc) write(w *bufio.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n", g.metricName, escapeHelp(g.help))
+	fmt.Fprintf(w, "# TYPE %s %s\n", g.metricName, g.typ)
+	writeSample(w, g.metricName, g.labels, g.labelValue, "", "", g.f())
+}
+
+// CounterFunc is a counter read at the scrape time, for the counts
+// kept by another package.
+type CounterFunc struct {
+	*GaugeFunc
+}
+
+// NewCounterFunc registers a counter read from f. The optional
+// constant labels are given as alternating names and values.
+func (reg *Registry) NewCounterFunc(name, help string, f func() float64, constLabels ...string) *CounterFunc {
+	c := &CounterFunc{newGaugeFunc(name, help, "counter", f, constLabels)}
+	reg.register(c)
+	return c
+}
+
+func writeSample(w *bufio.Writer, name string, labels, values []string, extraLabel, extraValue string, value float64) {
+	w.WriteString(name)
+	if len(labels) > 0 || extraLabel != "" {
+		w.WriteByte('{')
+		for i, l := range labels {
+			if i > 0 {
+				w.WriteByte(',')
+			}
+			w.WriteString(l + `="` + labelEscaper.Replace(values[i]) + `"`)
+		}
+		if extraLabel != "" {
+			if len(labels) > 0 {
+				w.WriteByte(',')
+			}
+			w.WriteString(extraLabel + `="` + labelEscaper.Replace(extraValue) + `"`)
+		}
+		w.WriteByte('}')
+	}
+	w.WriteByte(' ')
+	w.WriteString(formatFloat(value))
+	w.WriteByte('\n')
+}
+
+func formatFloat(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "+Inf"
+	case math.IsInf(f, -1):
+		return "-Inf"
+	case math.IsNaN(f):
+		return "NaN"
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeHelp(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(s)
+}
@@ -0,0 +1,63 @@
+package metrics
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRegistry_WriteTo(t *testing.T) {
+	reg := NewRegistry()
+	c := reg.NewCounterVec("requests_total", "Requests.", "route", "status")
+	c.Inc("/a", "200")
+	c.Add(2, "/b", "500")
+	c.Inc("/a", "200")
+	h := reg.NewHistogramVec("latency_seconds", "Latency.", []float64{0.1, 1}, "route")
+	h.Observe(0.05, `/"q"`)
+	h.Observe(0.5, `/"q"`)
+	h.Observe(5, `/"q"`)
+	reg.NewGaugeFunc("items", "Items\nin stock.", func() float64 { return 3 })
+	reg.NewCounterFunc("hits_total", "Hits.", func() float64 { return 7 }, "cache", "users")
+
+	want := `# HELP hits_total Hits.
+# TYPE hits_total counter
+hits_total{cache="users"} 7
+# HELP items Items\nin stock.
+# TYPE items gauge
+items 3
+# HELP latency_seconds Latency.
+# TYPE latency_seconds histogram
+latency_seconds_bucket{route="/\"q\"",le="0.1"} 1
+latency_seconds_bucket{route="/\"q\"",le="1"} 2
+latency_seconds_bucket{route="/\"q\"",le="+Inf"} 3
+latency_seconds_sum{route="/\"q\""} 5.55
+latency_seconds_count{route="/\"q\""} 3
+# HELP requests_total Requests.
+# TYPE requests_total counter
+requests_total{route="/a",status="200"} 2
+requests_total{route="/b",status="500"} 2
+`
+	var buf bytes.Buffer
+	if _, err := reg.WriteTo(&buf); err != nil {
+		t.Fatalf("WriteTo() unexpected error: %v", err)
+	}
+	if got := buf.String(); got != want {
+		t.Errorf("WriteTo() =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestRegistry_ServeHTTP(t *testing.T) {
+	reg := NewRegistry()
+	reg.RegisterRuntime()
+
+	w := httptest.NewRecorder()
+	reg.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+
+	if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Errorf("Content-Type = %q, want the text exposition format", ct)
+	}
+	if !strings.Contains(w.Body.String(), "\ngo_goroutines ") {
+		t.Errorf("Body = %s, want the runtime stats", w.Body.String())
+	}
+}
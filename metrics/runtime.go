@@ -0,0 +1,45 @@
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"runtime"
+)
+
+// runtimeCollector reports the Go runtime stats.
+// The memory stats are read once per scrape, as reading them stops the world.
+type runtimeCollector struct{}
+
+// RegisterRuntime adds the Go runtime stats to the registry.
+func (reg *Registry) RegisterRuntime() {
+	reg.register(runtimeCollector{})
+}
+
+func (runtimeCollector) name() string {
+	return "go_"
+}
+
+func (runtimeCollector) write(w *bufio.Writer) {
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+
+	gauge := func(name, help string, value float64) {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n", name, help, name)
+		writeSample(w, name, nil, nil, "", "", value)
+	}
+	counter := func(name, help string, value float64) {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", name, help, name)
+		writeSample(w, name, nil, nil, "", "", value)
+	}
+
+	gauge("go_goroutines", "Number of goroutines that currently exist.", float64(runtime.NumGoroutine()))
+	fmt.Fprintf(w, "# HELP go_info Information about the Go environment.\n# TYPE go_info gauge\n")
+	writeSample(w, "go_info", []string{"version"}, []string{runtime.Version()}, "", "", 1)
+	gauge("go_memstats_alloc_bytes", "Number of bytes allocated and still in use.", float64(ms.Alloc))
+	counter("go_memstats_alloc_bytes_total", "Total number of bytes allocated, even if freed.", float64(ms.TotalAlloc))
+	gauge("go_memstats_heap_objects", "Number of allocated objects.", float64(ms.HeapObjects))
+	gauge("go_memstats_sys_bytes", "Number of bytes obtained from system.", float64(ms.Sys))
+	gauge("go_memstats_last_gc_time_seconds", "Number of seconds since 1970 of last garbage collection.", float64(ms.LastGC)/1e9)
+	counter("go_gc_cycles_total", "Number of completed GC cycles.", float64(ms.NumGC))
+	counter("go_gc_pause_seconds_total", "Total GC pause time.", float64(ms.PauseTotalNs)/1e9)
+}
@@ -0,0 +1,86 @@
+// Package ratelimit limits the requests of a client with token buckets.
+package ratelimit
+
+import (
+	"context"
+	"math"
+	"time"
+)
+
+// Limit lets Requests requests in a burst, then refills the bucket
+// at the rate of Requests per Period.
+type Limit struct {
+	Requests int
+	Period   time.Duration
+}
+
+// Unlimited reports whether the limit lets everything in.
+func (l Limit) Unlimited() bool {
+	return l.Requests <= 0 || l.Period <= 0
+}
+
+// interval is the time of refilling a single token.
+func (l Limit) interval() time.Duration {
+	return l.Period / time.Duration(l.Requests)
+}
+
+// Result tells whether the request is allowed and the state of its bucket.
+type Result struct {
+	Allowed bool
+	// Remaining is the number of the requests allowed right away.
+	Remaining int
+	// Reset is the time until the bucket is full again.
+	Reset time.Duration
+	// RetryAfter is the time until the next request is allowed,
+	// zero if it is allowed now.
+	RetryAfter time.Duration
+}
+
+// Store keeps the buckets of the clients.
+type Store interface {
+	// Take takes a token from the bucket of the key for the request.
+	Take(ctx context.Context, key string, l Limit) (Result, error)
+}
+
+// Bucket is the state of a token bucket. The zero Bucket is full.
+type Bucket struct {
+	// Empty is the time when the bucket was or will be empty
+	// if nothing is taken, i.e. it is full again after Empty + Period.
+	// The tokens are counted from it, so the state is a single value.
+	Empty time.Time
+}
+
+// Take takes a token at now if there is one.
+func (b *Bucket) Take(now time.Time, l Limit) Result {
+	interval := l.interval()
+	// The bucket never has more than l.Requests tokens.
+	if full := now.Add(-l.Period); b.Empty.Before(full) {
+		b.Empty = full
+	}
+
+	tokens := int(now.Sub(b.Empty) / interval)
+	if tokens < 1 {
+		next := b.Empty.Add(interval)
+		return Result{
+			Reset:      b.Empty.Add(l.Period).Sub(now),
+			RetryAfter: next.Sub(now),
+		}
+	}
+
+	b.Empty = b.Empty.Add(interval)
+	return Result{
+		Allowed:   true,
+		Remaining: tokens - 1,
+		Reset:     b.Empty.Add(l.Period).Sub(now),
+	}
+}
+
+// Full reports whether the bucket is full at now, so it may be forgotten.
+func (b *Bucket) Full(now time.Time, l Limit) bool {
+	return !b.Empty.Add(l.Period).After(now)
+}
+
+// Seconds rounds the duration up to whole seconds for the headers.
+func Seconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
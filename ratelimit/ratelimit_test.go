@@ -0,0 +1,73 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBucket_Take(t *testing.T) {
+	limit := Limit{Requests: 3, Period: 3 * time.Second}
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name  string
+		takes []time.Duration // since start
+		want  Result          // of the last take
+	}{
+		{
+			name:  "Should allow the first request",
+			takes: []time.Duration{0},
+			want:  Result{Allowed: true, Remaining: 2, Reset: time.Second},
+		},
+		{
+			name:  "Should allow a burst up to the limit",
+			takes: []time.Duration{0, 0, 0},
+			want:  Result{Allowed: true, Remaining: 0, Reset: 3 * time.Second},
+		},
+		{
+			name:  "Should reject the request over the limit",
+			takes: []time.Duration{0, 0, 0, 0},
+			want:  Result{Reset: 3 * time.Second, RetryAfter: time.Second},
+		},
+		{
+			name:  "Should refill a token per interval",
+			takes: []time.Duration{0, 0, 0, 0, 1500 * time.Millisecond},
+			want:  Result{Allowed: true, Remaining: 0, Reset: 2500 * time.Millisecond},
+		},
+		{
+			name:  "Should not refill over the limit",
+			takes: []time.Duration{0, time.Hour},
+			want:  Result{Allowed: true, Remaining: 2, Reset: time.Second},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var b Bucket
+			var got Result
+			for _, d := range tt.takes {
+				got = b.Take(start.Add(d), limit)
+			}
+			if got != tt.want {
+				t.Errorf("Take() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBucket_Full(t *testing.T) {
+	limit := Limit{Requests: 2, Period: 2 * time.Second}
+	now := time.Now()
+
+	var b Bucket
+	if !b.Full(now, limit) {
+		t.Errorf("Full() = false for the zero bucket")
+	}
+	b.Take(now, limit)
+	if b.Full(now.Add(time.Second-time.Nanosecond), limit) {
+		t.Errorf("Full() = true before the token is refilled")
+	}
+	if !b.Full(now.Add(time.Second), limit) {
+		t.Errorf("Full() = false after the token is refilled")
+	}
+}
@@ -0,0 +1,208 @@
+// Package cache provides caching decorators for the market services.
+package cache
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/ortymid/t2-http/logging"
+	"github.com/ortymid/t2-http/market"
+)
+
+// errLookupPanicked is the result of a shared lookup that panicked.
+var errLookupPanicked = errors.New("user lookup panicked")
+
+// Stats are the counters of a cache since its creation.
+type Stats struct {
+	Hits         uint64 // found users served from the cache
+	NegativeHits uint64 // not found users served from the cache
+	Misses       uint64 // lookups passed to the underlying service
+	Shared       uint64 // lookups that waited for an identical one in flight
+	Evictions    uint64
+	Size         int
+}
+
+type userEntry struct {
+	id        string
+	user      *market.User
+	err       error // *market.ErrUserNotFound or nil
+	expiresAt time.Time
+}
+
+// userCall is a lookup in flight.
+type userCall struct {
+	done chan struct{}
+	user *market.User
+	err  error
+}
+
+// UserService caches the results of the underlying market.UserService.
+//
+// Found users are kept for TTL and not found ones for NegativeTTL,
+// other errors are not cached. At most Size entries are kept, the least
+// recently used are evicted first. Concurrent lookups of the same user
+// share a single call to the underlying service, limited by LookupTimeout.
+type UserService struct {
+	Service     market.UserService
+	TTL         time.Duration
+	NegativeTTL time.Duration
+	Size        int
+	// LookupTimeout limits the shared lookup, zero or less means no limit.
+	// NewUserService sets it to 10s.
+	LookupTimeout time.Duration
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	lru     *list.List // front is the most recently used
+	calls   map[string]*userCall
+	stats   Stats
+	now     func() time.Time
+}
+
+func NewUserService(s market.UserService, size int, ttl, negativeTTL time.Duration) *UserService {
+	return &UserService{
+		Service:       s,
+		TTL:           ttl,
+		NegativeTTL:   negativeTTL,
+		Size:          size,
+		LookupTimeout: 10 * time.Second,
+		entries:       make(map[string]*list.Element),
+		lru:           list.New(),
+		calls:         make(map[string]*userCall),
+		now:           time.Now,
+	}
+}
+
+// User returns the cached user or looks it up. Concurrent callers share
+// the lookup, which runs apart from their contexts, so a caller giving up
+// does not fail the others.
+func (c *UserService) User(ctx context.Context, id string) (*market.User, error) {
+	c.mu.Lock()
+	if u, err, ok := c.get(id); ok {
+		c.mu.Unlock()
+		return u, err
+	}
+	call, ok := c.calls[id]
+	if ok {
+		c.stats.Shared++
+	} else {
+		call = &userCall{done: make(chan struct{})}
+		c.calls[id] = call
+		c.stats.Misses++
+		go c.lookup(ctx, id, call)
+	}
+	c.mu.Unlock()
+
+	select {
+	case <-call.done:
+		return call.user, call.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// lookup makes the shared call to the underlying service with the values
+// of the first caller's context but LookupTimeout, if any, instead of its deadline.
+// A panic fails the call, nobody could recover it otherwise.
+func (c *UserService) lookup(ctx context.Context, id string, call *userCall) {
+	ctx = detachedContext{ctx}
+	if c.LookupTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.LookupTimeout)
+		defer cancel()
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			call.user, call.err = nil, fmt.Errorf("%w: %v", errLookupPanicked, r)
+			logging.FromContext(ctx).Error("user lookup failed", "error", call.err)
+		}
+
+		c.mu.Lock()
+		delete(c.calls, id)
+		c.put(id, call.user, call.err)
+		c.mu.Unlock()
+		close(call.done)
+	}()
+
+	call.user, call.err = c.Service.User(ctx, id)
+}
+
+// detachedContext keeps the values of the context without its deadline
+// and cancellation.
+type detachedContext struct {
+	context.Context
+}
+
+func (detachedContext) Deadline() (time.Time, bool) { return time.Time{}, false }
+func (detachedContext) Done() <-chan struct{}       { return nil }
+func (detachedContext) Err() error                  { return nil }
+
+// Stats returns the current cache counters.
+func (c *UserService) Stats() Stats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	s := c.stats
+	s.Size = c.lru.Len()
+	return s
+}
+
+// get returns the cached result if it is still fresh.
+// The caller must hold the mutex.
+func (c *UserService) get(id string) (*market.User, error, bool) {
+	el, ok := c.entries[id]
+	if !ok {
+		return nil, nil, false
+	}
+	e := el.Value.(*userEntry)
+	if !c.now().Before(e.expiresAt) {
+		c.remove(el)
+		return nil, nil, false
+	}
+
+	c.lru.MoveToFront(el)
+	if e.err != nil {
+		c.stats.NegativeHits++
+	} else {
+		c.stats.Hits++
+	}
+	return e.user, e.err, true
+}
+
+// put caches the lookup result if it is cacheable.
+// The caller must hold the mutex.
+func (c *UserService) put(id string, u *market.User, err error) {
+	ttl := c.TTL
+	if err != nil {
+		var notFound *market.ErrUserNotFound
+		if !errors.As(err, &notFound) {
+			return
+		}
+		ttl = c.NegativeTTL
+	}
+	if ttl <= 0 || c.Size <= 0 {
+		return
+	}
+
+	e := &userEntry{id: id, user: u, err: err, expiresAt: c.now().Add(ttl)}
+	if el, ok := c.entries[id]; ok {
+		el.Value = e
+		c.lru.MoveToFront(el)
+		return
+	}
+	c.entries[id] = c.lru.PushFront(e)
+
+	for c.lru.Len() > c.Size {
+		c.remove(c.lru.Back())
+		c.stats.Evictions++
+	}
+}
+
+func (c *UserService) remove(el *list.Element) {
+	e := c.lru.Remove(el).(*userEntry)
+	delete(c.entries, e.id)
+}
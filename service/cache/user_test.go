@@ -0,0 +1,249 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/ortymid/t2-http/market"
+	"github.com/ortymid/t2-http/market/mock"
+)
+
+func TestUserService_User(t *testing.T) {
+	ctx := context.Background()
+	u1 := &market.User{ID: "1", Name: "u1"}
+	u2 := &market.User{ID: "2", Name: "u2"}
+	errNotFound := &market.ErrUserNotFound{UserID: "3"}
+	errDown := errors.New("down")
+
+	t.Run("Should serve repeated lookups from the cache", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		us := mock.NewMockUserService(ctrl)
+		us.EXPECT().User(gomock.Any(), "1").Return(u1, nil).Times(1)
+		us.EXPECT().User(gomock.Any(), "3").Return(nil, errNotFound).Times(1)
+
+		c := NewUserService(us, 10, time.Minute, time.Minute)
+		for i := 0; i < 2; i++ {
+			got, err := c.User(ctx, "1")
+			if err != nil || !reflect.DeepEqual(got, u1) {
+				t.Errorf("User() = %v, %v, want %v, nil", got, err, u1)
+			}
+			_, err = c.User(ctx, "3")
+			if !errors.Is(err, &market.ErrUserNotFound{}) {
+				t.Errorf("User() error = %v, want %v", err, errNotFound)
+			}
+		}
+
+		want := Stats{Hits: 1, NegativeHits: 1, Misses: 2, Size: 2}
+		if got := c.Stats(); got != want {
+			t.Errorf("Stats() = %+v, want %+v", got, want)
+		}
+	})
+
+	t.Run("Should not cache other errors", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		us := mock.NewMockUserService(ctrl)
+		us.EXPECT().User(gomock.Any(), "1").Return(nil, errDown).Times(2)
+
+		c := NewUserService(us, 10, time.Minute, time.Minute)
+		for i := 0; i < 2; i++ {
+			if _, err := c.User(ctx, "1"); err != errDown {
+				t.Errorf("User() error = %v, want %v", err, errDown)
+			}
+		}
+	})
+
+	t.Run("Should expire entries", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		us := mock.NewMockUserService(ctrl)
+		us.EXPECT().User(gomock.Any(), "1").Return(u1, nil).Times(2)
+
+		now := time.Now()
+		c := NewUserService(us, 10, time.Minute, time.Second)
+		c.now = func() time.Time { return now }
+
+		_, _ = c.User(ctx, "1")
+		now = now.Add(time.Minute)
+		_, _ = c.User(ctx, "1")
+	})
+
+	t.Run("Should evict the least recently used", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		us := mock.NewMockUserService(ctrl)
+		us.EXPECT().User(gomock.Any(), "1").Return(u1, nil).Times(1)
+		us.EXPECT().User(gomock.Any(), "2").Return(u2, nil).Times(2)
+		us.EXPECT().User(gomock.Any(), "3").Return(nil, errNotFound).Times(1)
+
+		c := NewUserService(us, 2, time.Minute, time.Minute)
+		_, _ = c.User(ctx, "1")
+		_, _ = c.User(ctx, "2")
+		_, _ = c.User(ctx, "1") // "2" becomes the least recently used
+		_, _ = c.User(ctx, "3")
+		_, _ = c.User(ctx, "1")
+		_, _ = c.User(ctx, "2")
+
+		if got := c.Stats().Evictions; got != 2 {
+			t.Errorf("Stats().Evictions = %d, want 2", got)
+		}
+	})
+
+	t.Run("Should share concurrent lookups", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		release := make(chan struct{})
+		us := mock.NewMockUserService(ctrl)
+		us.EXPECT().User(gomock.Any(), "1").DoAndReturn(func(ctx context.Context, id string) (*market.User, error) {
+			<-release
+			return u1, nil
+		}).Times(1)
+
+		c := NewUserService(us, 10, time.Minute, time.Minute)
+
+		var wg sync.WaitGroup
+		for i := 0; i < 5; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				got, err := c.User(ctx, "1")
+				if err != nil || !reflect.DeepEqual(got, u1) {
+					t.Errorf("User() = %v, %v, want %v, nil", got, err, u1)
+				}
+			}()
+		}
+
+		// Let the goroutines reach the cache before the lookup completes.
+		for {
+			c.mu.Lock()
+			s := c.stats
+			c.mu.Unlock()
+			if s.Misses+s.Shared == 5 {
+				break
+			}
+			time.Sleep(time.Millisecond)
+		}
+		close(release)
+		wg.Wait()
+
+		if got := c.Stats(); got.Misses != 1 || got.Shared != 4 {
+			t.Errorf("Stats() = %+v, want 1 miss and 4 shared", got)
+		}
+	})
+
+	t.Run("Should fail the shared lookup when it panics", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		us := mock.NewMockUserService(ctrl)
+		us.EXPECT().User(gomock.Any(), "1").DoAndReturn(func(ctx context.Context, id string) (*market.User, error) {
+			panic("boom")
+		}).Times(1)
+		us.EXPECT().User(gomock.Any(), "1").Return(u1, nil).Times(1)
+
+		c := NewUserService(us, 10, time.Minute, time.Minute)
+		if _, err := c.User(ctx, "1"); !errors.Is(err, errLookupPanicked) {
+			t.Errorf("User() error = %v, want %v", err, errLookupPanicked)
+		}
+		if got, err := c.User(ctx, "1"); err != nil || !reflect.DeepEqual(got, u1) {
+			t.Errorf("User() after the panic = %v, %v, want %v, nil", got, err, u1)
+		}
+	})
+
+	t.Run("Should not fail the shared lookup when a caller gives up", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		release := make(chan struct{})
+		us := mock.NewMockUserService(ctrl)
+		us.EXPECT().User(gomock.Any(), "1").DoAndReturn(func(ctx context.Context, id string) (*market.User, error) {
+			<-release
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+			return u1, nil
+		}).Times(1)
+
+		c := NewUserService(us, 10, time.Minute, time.Minute)
+
+		first, cancel := context.WithCancel(ctx)
+		canceled := make(chan error)
+		go func() {
+			_, err := c.User(first, "1")
+			canceled <- err
+		}()
+		waited := make(chan error)
+		go func() {
+			got, err := c.User(ctx, "1")
+			if err == nil && !reflect.DeepEqual(got, u1) {
+				t.Errorf("User() = %v, want %v", got, u1)
+			}
+			waited <- err
+		}()
+		for {
+			c.mu.Lock()
+			s := c.stats
+			c.mu.Unlock()
+			if s.Misses+s.Shared == 2 {
+				break
+			}
+			time.Sleep(time.Millisecond)
+		}
+
+		cancel()
+		if err := <-canceled; !errors.Is(err, context.Canceled) {
+			t.Errorf("User() of the canceled caller error = %v, want %v", err, context.Canceled)
+		}
+		close(release)
+		if err := <-waited; err != nil {
+			t.Errorf("User() of the waiter unexpected error: %v", err)
+		}
+	})
+
+	t.Run("Should time out the shared lookup", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		us := mock.NewMockUserService(ctrl)
+		us.EXPECT().User(gomock.Any(), "1").DoAndReturn(func(ctx context.Context, id string) (*market.User, error) {
+			<-ctx.Done()
+			return nil, ctx.Err()
+		}).Times(1)
+
+		c := NewUserService(us, 10, time.Minute, time.Minute)
+		c.LookupTimeout = time.Millisecond
+		if _, err := c.User(ctx, "1"); !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("User() error = %v, want %v", err, context.DeadlineExceeded)
+		}
+	})
+
+	t.Run("Should not limit the lookup without a timeout", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		us := mock.NewMockUserService(ctrl)
+		us.EXPECT().User(gomock.Any(), "1").DoAndReturn(func(ctx context.Context, id string) (*market.User, error) {
+			if _, ok := ctx.Deadline(); ok {
+				t.Errorf("lookup context has a deadline, want none")
+			}
+			return &market.User{ID: "1"}, ctx.Err()
+		}).Times(1)
+
+		c := NewUserService(us, 10, time.Minute, time.Minute)
+		c.LookupTimeout = 0
+		if _, err := c.User(ctx, "1"); err != nil {
+			t.Errorf("User() unexpected error: %v", err)
+		}
+	})
+}
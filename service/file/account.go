@@ -0,0 +1,167 @@
+// Package file provides services persisting their data to local files.
+package file
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/ortymid/t2-http/service/mem"
+	"github.com/ortymid/t2-http/user"
+)
+
+// AccountStore keeps the accounts in memory and writes all of them
+// to a JSON file after every change.
+type AccountStore struct {
+	mu   sync.Mutex // serializes the changes with their saving
+	path string
+	mem  *mem.AccountStore
+}
+
+type accountRecord struct {
+	ID           string    `json:"id"`
+	Username     string    `json:"username"`
+	PasswordHash []byte    `json:"password_hash"`
+	CreatedAt    time.Time `json:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
+}
+
+// OpenAccountStore loads the accounts from the file.
+// A missing file is created on the first change.
+func OpenAccountStore(path string) (*AccountStore, error) {
+	var records []accountRecord
+	data, err := ioutil.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("opening account store: %w", err)
+	}
+	if err == nil {
+		if err := json.Unmarshal(data, &records); err != nil {
+			return nil, fmt.Errorf("opening account store %s: %w", path, err)
+		}
+	}
+
+	accounts := make([]*user.Account, len(records))
+	for i, r := range records {
+		accounts[i] = &user.Account{
+			ID:           r.ID,
+			Username:     r.Username,
+			PasswordHash: r.PasswordHash,
+			CreatedAt:    r.CreatedAt,
+			UpdatedAt:    r.UpdatedAt,
+		}
+	}
+
+	return &AccountStore{path: path, mem: mem.NewAccountStore(accounts...)}, nil
+}
+
+func (srv *AccountStore) Account(ctx context.Context, id string) (*user.Account, error) {
+	return srv.mem.Account(ctx, id)
+}
+
+func (srv *AccountStore) AccountByUsername(ctx context.Context, username string) (*user.Account, error) {
+	return srv.mem.AccountByUsername(ctx, username)
+}
+
+func (srv *AccountStore) AddAccount(ctx context.Context, a *user.Account) (*user.Account, error) {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+
+	a, err := srv.mem.AddAccount(ctx, a)
+	if err != nil {
+		return nil, err
+	}
+	if err := srv.save(ctx); err != nil {
+		_ = srv.mem.DeleteAccount(ctx, a.ID)
+		return nil, err
+	}
+	return a, nil
+}
+
+func (srv *AccountStore) ReplaceAccount(ctx context.Context, a *user.Account) (*user.Account, error) {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+
+	old, err := srv.mem.Account(ctx, a.ID)
+	if err != nil {
+		return nil, err
+	}
+	a, err = srv.mem.ReplaceAccount(ctx, a)
+	if err != nil {
+		return nil, err
+	}
+	if err := srv.save(ctx); err != nil {
+		_, _ = srv.mem.ReplaceAccount(ctx, old)
+		return nil, err
+	}
+	return a, nil
+}
+
+// Check reports whether the directory of the file is still there
+// for the changes to be saved.
+func (srv *AccountStore) Check(ctx context.Context) error {
+	if _, err := os.Stat(filepath.Dir(srv.path)); err != nil {
+		return fmt.Errorf("account store: %w", err)
+	}
+	return nil
+}
+
+// save atomically replaces the file with the current accounts.
+// If saving fails the caller undoes the change in memory.
+func (srv *AccountStore) save(ctx context.Context) error {
+	accounts, err := srv.mem.Accounts(ctx)
+	if err != nil {
+		return fmt.Errorf("saving accounts: %w", err)
+	}
+
+	records := make([]accountRecord, len(accounts))
+	for i, a := range accounts {
+		records[i] = accountRecord{
+			ID:           a.ID,
+			Username:     a.Username,
+			PasswordHash: a.PasswordHash,
+			CreatedAt:    a.CreatedAt,
+			UpdatedAt:    a.UpdatedAt,
+		}
+	}
+	data, err := json.MarshalIndent(records, "", "  ")
+	if err != nil {
+		return fmt.Errorf("saving accounts: %w", err)
+	}
+
+	if err := writeFileAtomic(srv.path, data); err != nil {
+		return fmt.Errorf("saving accounts: %w", err)
+	}
+	return nil
+}
+
+// writeFileAtomic writes the data to a temporary file and renames it to path,
+// so the readers see either the old or the new content.
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // no-op after the rename
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(0600); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
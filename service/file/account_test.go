@@ -0,0 +1,96 @@
+package file
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ortymid/t2-http/user"
+)
+
+func TestAccountStore(t *testing.T) {
+	ctx := context.Background()
+
+	dir, err := ioutil.TempDir("", "accounts")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "accounts.json")
+
+	s, err := OpenAccountStore(path)
+	if err != nil {
+		t.Fatalf("OpenAccountStore() unexpected error: %v", err)
+	}
+	a, err := s.AddAccount(ctx, &user.Account{Username: "alice", PasswordHash: []byte("hash")})
+	if err != nil {
+		t.Fatalf("AddAccount() unexpected error: %v", err)
+	}
+	a.Username = "alicia"
+	if _, err := s.ReplaceAccount(ctx, a); err != nil {
+		t.Fatalf("ReplaceAccount() unexpected error: %v", err)
+	}
+
+	// Reopen to read the saved accounts.
+	s, err = OpenAccountStore(path)
+	if err != nil {
+		t.Fatalf("OpenAccountStore() unexpected error: %v", err)
+	}
+	got, err := s.AccountByUsername(ctx, "alicia")
+	if err != nil {
+		t.Fatalf("AccountByUsername() unexpected error: %v", err)
+	}
+	if got.ID != "1" || string(got.PasswordHash) != "hash" {
+		t.Errorf("AccountByUsername() = %+v, want ID 1 with the saved hash", got)
+	}
+
+	_, err = s.AddAccount(ctx, &user.Account{Username: "ALICIA"})
+	if !errors.Is(err, user.ErrUsernameTaken) {
+		t.Errorf("AddAccount() error = %v, want %v", err, user.ErrUsernameTaken)
+	}
+	b, err := s.AddAccount(ctx, &user.Account{Username: "bob"})
+	if err != nil || b.ID != "2" {
+		t.Errorf("AddAccount() = %v, %v, want ID 2", b, err)
+	}
+}
+
+func TestAccountStore_saveFailed(t *testing.T) {
+	ctx := context.Background()
+
+	dir, err := ioutil.TempDir("", "accounts")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "accounts.json")
+
+	s, err := OpenAccountStore(path)
+	if err != nil {
+		t.Fatalf("OpenAccountStore() unexpected error: %v", err)
+	}
+	a, err := s.AddAccount(ctx, &user.Account{Username: "alice"})
+	if err != nil {
+		t.Fatalf("AddAccount() unexpected error: %v", err)
+	}
+
+	// The file cannot be written once its directory is gone.
+	s.path = filepath.Join(dir, "missing", "accounts.json")
+
+	if _, err := s.AddAccount(ctx, &user.Account{Username: "bob"}); err == nil {
+		t.Errorf("AddAccount() error = nil, want the saving error")
+	}
+	if _, err := s.AccountByUsername(ctx, "bob"); err == nil {
+		t.Errorf("AccountByUsername() found the account that was not saved")
+	}
+
+	if _, err := s.ReplaceAccount(ctx, &user.Account{ID: a.ID, Username: "alicia"}); err == nil {
+		t.Errorf("ReplaceAccount() error = nil, want the saving error")
+	}
+	got, err := s.Account(ctx, a.ID)
+	if err != nil || got.Username != "alice" {
+		t.Errorf("Account() = %v, %v, want the account not replaced", got, err)
+	}
+}
@@ -0,0 +1,211 @@
+package file
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/ortymid/t2-http/apikey"
+)
+
+// APIKeyStore keeps the API keys in memory and writes all of them
+// to a JSON file after every change. A change is seen by the readers
+// only after it is saved.
+type APIKeyStore struct {
+	changeMu sync.Mutex // serializes the changes with their saving
+
+	mu     sync.RWMutex
+	path   string
+	lastID int
+	keys   []*apikey.Key
+}
+
+type apiKeyRecord struct {
+	ID         int       `json:"id"`
+	UserID     string    `json:"user_id"`
+	Name       string    `json:"name"`
+	Prefix     string    `json:"prefix"`
+	Hash       []byte    `json:"hash"`
+	Scopes     []string  `json:"scopes"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastUsedAt time.Time `json:"last_used_at"`
+}
+
+// OpenAPIKeyStore loads the API keys from the file.
+// A missing file is created on the first change.
+func OpenAPIKeyStore(path string) (*APIKeyStore, error) {
+	var records []apiKeyRecord
+	data, err := ioutil.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("opening api key store: %w", err)
+	}
+	if err == nil {
+		if err := json.Unmarshal(data, &records); err != nil {
+			return nil, fmt.Errorf("opening api key store %s: %w", path, err)
+		}
+	}
+
+	srv := &APIKeyStore{path: path, keys: make([]*apikey.Key, len(records))}
+	for i, r := range records {
+		srv.keys[i] = &apikey.Key{
+			ID:         r.ID,
+			UserID:     r.UserID,
+			Name:       r.Name,
+			Prefix:     r.Prefix,
+			Hash:       r.Hash,
+			Scopes:     r.Scopes,
+			CreatedAt:  r.CreatedAt,
+			LastUsedAt: r.LastUsedAt,
+		}
+		if r.ID > srv.lastID {
+			srv.lastID = r.ID
+		}
+	}
+	return srv, nil
+}
+
+func (srv *APIKeyStore) Keys(ctx context.Context, userID string) ([]*apikey.Key, error) {
+	srv.mu.RLock()
+	defer srv.mu.RUnlock()
+
+	keys := make([]*apikey.Key, 0)
+	for _, k := range srv.keys {
+		if k.UserID == userID {
+			keys = append(keys, k)
+		}
+	}
+	return keys, nil
+}
+
+func (srv *APIKeyStore) Key(ctx context.Context, id int) (*apikey.Key, error) {
+	srv.mu.RLock()
+	defer srv.mu.RUnlock()
+
+	for _, k := range srv.keys {
+		if k.ID == id {
+			return k, nil
+		}
+	}
+	return nil, apikey.ErrKeyNotFound
+}
+
+func (srv *APIKeyStore) KeyByPrefix(ctx context.Context, prefix string) (*apikey.Key, error) {
+	srv.mu.RLock()
+	defer srv.mu.RUnlock()
+
+	for _, k := range srv.keys {
+		if k.Prefix == prefix {
+			return k, nil
+		}
+	}
+	return nil, apikey.ErrKeyNotFound
+}
+
+func (srv *APIKeyStore) AddKey(ctx context.Context, k *apikey.Key) (*apikey.Key, error) {
+	srv.changeMu.Lock()
+	defer srv.changeMu.Unlock()
+
+	k.ID = srv.lastID + 1
+	keys := append(srv.current(), k)
+	if err := srv.save(keys); err != nil {
+		k.ID = 0
+		return nil, err
+	}
+
+	srv.mu.Lock()
+	srv.keys = keys
+	srv.lastID = k.ID
+	srv.mu.Unlock()
+	return k, nil
+}
+
+func (srv *APIKeyStore) DeleteKey(ctx context.Context, id int) error {
+	return srv.change(id, func(keys []*apikey.Key, i int) []*apikey.Key {
+		return append(keys[:i], keys[i+1:]...)
+	})
+}
+
+// MarkUsed replaces the key with a copy, so the readers holding it
+// do not race with the change.
+func (srv *APIKeyStore) MarkUsed(ctx context.Context, id int, at time.Time) error {
+	return srv.change(id, func(keys []*apikey.Key, i int) []*apikey.Key {
+		used := *keys[i]
+		used.LastUsedAt = at
+		keys[i] = &used
+		return keys
+	})
+}
+
+// Check reports whether the directory of the file is still there
+// for the changes to be saved.
+func (srv *APIKeyStore) Check(ctx context.Context) error {
+	if _, err := os.Stat(filepath.Dir(srv.path)); err != nil {
+		return fmt.Errorf("api key store: %w", err)
+	}
+	return nil
+}
+
+// change applies f to a copy of the keys with the index of the key,
+// saves the result and makes it current.
+func (srv *APIKeyStore) change(id int, f func(keys []*apikey.Key, i int) []*apikey.Key) error {
+	srv.changeMu.Lock()
+	defer srv.changeMu.Unlock()
+
+	keys := srv.current()
+	for i, k := range keys {
+		if k.ID != id {
+			continue
+		}
+		keys = f(keys, i)
+		if err := srv.save(keys); err != nil {
+			return err
+		}
+
+		srv.mu.Lock()
+		srv.keys = keys
+		srv.mu.Unlock()
+		return nil
+	}
+	return apikey.ErrKeyNotFound
+}
+
+// current returns a copy of the key list to be changed.
+func (srv *APIKeyStore) current() []*apikey.Key {
+	srv.mu.RLock()
+	defer srv.mu.RUnlock()
+
+	keys := make([]*apikey.Key, len(srv.keys), len(srv.keys)+1)
+	copy(keys, srv.keys)
+	return keys
+}
+
+// save atomically replaces the file with the keys.
+func (srv *APIKeyStore) save(keys []*apikey.Key) error {
+	records := make([]apiKeyRecord, len(keys))
+	for i, k := range keys {
+		records[i] = apiKeyRecord{
+			ID:         k.ID,
+			UserID:     k.UserID,
+			Name:       k.Name,
+			Prefix:     k.Prefix,
+			Hash:       k.Hash,
+			Scopes:     k.Scopes,
+			CreatedAt:  k.CreatedAt,
+			LastUsedAt: k.LastUsedAt,
+		}
+	}
+	data, err := json.MarshalIndent(records, "", "  ")
+	if err != nil {
+		return fmt.Errorf("saving api keys: %w", err)
+	}
+
+	if err := writeFileAtomic(srv.path, data); err != nil {
+		return fmt.Errorf("saving api keys: %w", err)
+	}
+	return nil
+}
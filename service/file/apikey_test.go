@@ -0,0 +1,80 @@
+package file
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ortymid/t2-http/apikey"
+)
+
+func TestAPIKeyStore(t *testing.T) {
+	ctx := context.Background()
+
+	dir, err := ioutil.TempDir("", "apikeys")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "api_keys.json")
+
+	s, err := OpenAPIKeyStore(path)
+	if err != nil {
+		t.Fatalf("OpenAPIKeyStore() unexpected error: %v", err)
+	}
+	k1, err := s.AddKey(ctx, &apikey.Key{UserID: "1", Prefix: "p1", Hash: []byte("hash"), Scopes: []string{apikey.ScopeProductsWrite}})
+	if err != nil {
+		t.Fatalf("AddKey() unexpected error: %v", err)
+	}
+	k2, err := s.AddKey(ctx, &apikey.Key{UserID: "1", Prefix: "p2"})
+	if err != nil || k2.ID != 2 {
+		t.Fatalf("AddKey() = %v, %v, want ID 2", k2, err)
+	}
+	used := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	if err := s.MarkUsed(ctx, k1.ID, used); err != nil {
+		t.Fatalf("MarkUsed() unexpected error: %v", err)
+	}
+	if err := s.DeleteKey(ctx, k2.ID); err != nil {
+		t.Fatalf("DeleteKey() unexpected error: %v", err)
+	}
+
+	// Reopen to read the saved keys.
+	s, err = OpenAPIKeyStore(path)
+	if err != nil {
+		t.Fatalf("OpenAPIKeyStore() unexpected error: %v", err)
+	}
+	got, err := s.KeyByPrefix(ctx, "p1")
+	if err != nil {
+		t.Fatalf("KeyByPrefix() unexpected error: %v", err)
+	}
+	if got.ID != 1 || string(got.Hash) != "hash" || !got.HasScope(apikey.ScopeProductsWrite) || !got.LastUsedAt.Equal(used) {
+		t.Errorf("KeyByPrefix() = %+v, want the saved key 1", got)
+	}
+	if _, err := s.Key(ctx, k2.ID); !errors.Is(err, apikey.ErrKeyNotFound) {
+		t.Errorf("Key() error = %v, want %v", err, apikey.ErrKeyNotFound)
+	}
+	k3, err := s.AddKey(ctx, &apikey.Key{UserID: "2", Prefix: "p3"})
+	if err != nil {
+		t.Fatalf("AddKey() unexpected error: %v", err)
+	}
+
+	// The file cannot be written once its directory is gone.
+	s.path = filepath.Join(dir, "missing", "api_keys.json")
+
+	if _, err := s.AddKey(ctx, &apikey.Key{UserID: "2", Prefix: "p4"}); err == nil {
+		t.Errorf("AddKey() error = nil, want the saving error")
+	}
+	if _, err := s.KeyByPrefix(ctx, "p4"); !errors.Is(err, apikey.ErrKeyNotFound) {
+		t.Errorf("KeyByPrefix() found the key that was not saved")
+	}
+	if err := s.DeleteKey(ctx, k3.ID); err == nil {
+		t.Errorf("DeleteKey() error = nil, want the saving error")
+	}
+	if _, err := s.Key(ctx, k3.ID); err != nil {
+		t.Errorf("Key() error = %v, want the key not deleted", err)
+	}
+}
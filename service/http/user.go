@@ -1,33 +1,155 @@
 package http
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
 	"net/http"
 	"strconv"
+	"time"
 
+	"github.com/ortymid/t2-http/logging"
 	"github.com/ortymid/t2-http/market"
+	"github.com/ortymid/t2-http/trace"
 )
 
+const serviceName = "user service"
+
+// UserService is a client of the external user service.
+//
+// Every attempt is limited by Timeout. Network errors and 5xx responses
+// are retried up to MaxRetries times with jittered exponential backoff.
+// When the service keeps failing, the Breaker makes the calls fail fast.
+// Both cases result in *market.ErrUnavailable.
 type UserService struct {
-	URL string
+	URL     string
+	Client  *http.Client
+	Timeout time.Duration
+
+	MaxRetries   int
+	RetryBackoff time.Duration
+
+	// Breaker may be nil to disable the circuit breaking.
+	Breaker *CircuitBreaker
 }
 
 func NewUserService(url string) *UserService {
-	return &UserService{URL: url}
+	return &UserService{
+		URL:          url,
+		Client:       &http.Client{},
+		Timeout:      2 * time.Second,
+		MaxRetries:   2,
+		RetryBackoff: 100 * time.Millisecond,
+		Breaker:      NewCircuitBreaker(5, 10*time.Second),
+	}
 }
 
-func (srv *UserService) User(id string) (*market.User, error) {
-	resp, err := http.Get(fmt.Sprintf("%s/%s", srv.URL, id))
-	if err != nil {
-		return nil, err
+// User looks the user up. The context cancellation stops the retries,
+// the context error is returned then.
+func (srv *UserService) User(ctx context.Context, id string) (*market.User, error) {
+	if srv.Breaker != nil && !srv.Breaker.Allow() {
+		return nil, &market.ErrUnavailable{Service: serviceName, Reason: ErrCircuitOpen}
 	}
-	if resp.StatusCode != http.StatusOK {
-		if resp.StatusCode == http.StatusNotFound {
-			return nil, &market.ErrUserNotFound{UserID: id}
+
+	var err error
+	for attempt := 0; attempt <= srv.MaxRetries; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-time.After(srv.backoff(attempt)):
+			case <-ctx.Done():
+				srv.recordResult(ctx.Err())
+				return nil, ctx.Err()
+			}
+		}
+
+		var user *market.User
+		var retry bool
+		user, retry, err = srv.user(ctx, id)
+		if ctx.Err() != nil {
+			// The caller gave up, it says nothing about the service.
+			srv.recordResult(ctx.Err())
+			return nil, ctx.Err()
+		}
+		if !retry {
+			srv.recordResult(err)
+			return user, err
 		}
-		return nil, errors.New("something went wrong")
+	}
+
+	if srv.Breaker != nil {
+		srv.Breaker.Failure()
+	}
+	return nil, &market.ErrUnavailable{Service: serviceName, Reason: err}
+}
+
+// Check reports whether the service is reachable. It fails fast while
+// the breaker is open, otherwise any answer but a 5xx means the service is up.
+// It does not retry and does not affect the breaker.
+func (srv *UserService) Check(ctx context.Context) error {
+	if srv.Breaker != nil && srv.Breaker.Open() {
+		return ErrCircuitOpen
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, srv.Timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := srv.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	_, _ = io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+
+	if resp.StatusCode >= 500 {
+		return fmt.Errorf("user service: unexpected status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// user makes a single attempt to get the user.
+// retry reports whether the error is temporary.
+func (srv *UserService) user(ctx context.Context, id string) (user *market.User, retry bool, err error) {
+	ctx, span := trace.Start(ctx, "GET "+serviceName, "user_id", id)
+	defer span.EndWith(&err)
+
+	ctx, cancel := context.WithTimeout(ctx, srv.Timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf("%s/%s", srv.URL, id), nil)
+	if err != nil {
+		return nil, false, err
+	}
+	if requestID := logging.RequestID(ctx); requestID != "" {
+		req.Header.Set(logging.HeaderRequestID, requestID)
+	}
+	trace.Inject(ctx, req.Header)
+
+	resp, err := srv.Client.Do(req)
+	if err != nil {
+		return nil, true, err
+	}
+	defer func() {
+		// Let the connection be reused.
+		_, _ = io.Copy(ioutil.Discard, resp.Body)
+		resp.Body.Close()
+	}()
+
+	span.SetAttributes("http.status_code", resp.StatusCode)
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return nil, false, &market.ErrUserNotFound{UserID: id}
+	case resp.StatusCode >= 500:
+		return nil, true, fmt.Errorf("unexpected status %d", resp.StatusCode)
+	case resp.StatusCode != http.StatusOK:
+		return nil, false, fmt.Errorf("user service: unexpected status %d", resp.StatusCode)
 	}
 
 	data := struct {
@@ -36,13 +158,40 @@ func (srv *UserService) User(id string) (*market.User, error) {
 		Balance  int    `json:"balance"`
 	}{}
 	err = json.NewDecoder(resp.Body).Decode(&data)
-	defer resp.Body.Close()
 	if err != nil {
-		return nil, err
+		return nil, false, fmt.Errorf("user service: decoding user: %w", err)
 	}
 
 	return &market.User{
 		ID:   strconv.Itoa(data.ID),
 		Name: data.Username,
-	}, nil
+	}, false, nil
+}
+
+// recordResult reports the outcome of a call to the breaker.
+// Any answer of the service, including not found, means it is alive.
+// A call canceled by the caller is neither a success nor a failure,
+// but it must release the half-open breaker.
+func (srv *UserService) recordResult(err error) {
+	if srv.Breaker == nil {
+		return
+	}
+	var notFound *market.ErrUserNotFound
+	switch {
+	case err == nil, errors.As(err, &notFound):
+		srv.Breaker.Success()
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		srv.Breaker.Release()
+	default:
+		srv.Breaker.Failure()
+	}
+}
+
+// backoff returns a random delay up to the exponentially growing limit.
+func (srv *UserService) backoff(attempt int) time.Duration {
+	limit := srv.RetryBackoff << (attempt - 1)
+	if limit <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(limit)))
 }
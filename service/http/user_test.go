@@ -0,0 +1,240 @@
+package http
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ortymid/t2-http/logging"
+	"github.com/ortymid/t2-http/market"
+	"github.com/ortymid/t2-http/trace"
+)
+
+func newTestUserService(url string) *UserService {
+	srv := NewUserService(url)
+	srv.Timeout = 100 * time.Millisecond
+	srv.RetryBackoff = time.Millisecond
+	return srv
+}
+
+func TestUserService_User(t *testing.T) {
+	tests := []struct {
+		name      string
+		responses []int // status codes of the consecutive responses, the last one repeats
+		delay     time.Duration
+		want      *market.User
+		wantErr   error
+		wantCalls int32
+	}{
+		{
+			name:      "Should return the user",
+			responses: []int{http.StatusOK},
+			want:      &market.User{ID: "1", Name: "u1"},
+			wantCalls: 1,
+		},
+		{
+			name:      "Should return not found without retrying",
+			responses: []int{http.StatusNotFound},
+			wantErr:   &market.ErrUserNotFound{},
+			wantCalls: 1,
+		},
+		{
+			name:      "Should retry server errors",
+			responses: []int{http.StatusBadGateway, http.StatusInternalServerError, http.StatusOK},
+			want:      &market.User{ID: "1", Name: "u1"},
+			wantCalls: 3,
+		},
+		{
+			name:      "Should return unavailable after all retries",
+			responses: []int{http.StatusServiceUnavailable},
+			wantErr:   &market.ErrUnavailable{},
+			wantCalls: 3,
+		},
+		{
+			name:      "Should return unavailable on timeouts",
+			responses: []int{http.StatusOK},
+			delay:     time.Second,
+			wantErr:   &market.ErrUnavailable{},
+			wantCalls: 3,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var calls int32
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				n := atomic.AddInt32(&calls, 1)
+				if tt.delay > 0 {
+					select {
+					case <-time.After(tt.delay):
+					case <-r.Context().Done():
+						return
+					}
+				}
+				i := int(n) - 1
+				if i >= len(tt.responses) {
+					i = len(tt.responses) - 1
+				}
+				w.WriteHeader(tt.responses[i])
+				if tt.responses[i] == http.StatusOK {
+					_, _ = w.Write([]byte(`{"id":1,"username":"u1","balance":0}`))
+				}
+			}))
+			defer ts.Close()
+
+			srv := newTestUserService(ts.URL)
+			got, err := srv.User(context.Background(), "1")
+			if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Errorf("User() error = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr == nil && err != nil {
+				t.Errorf("User() unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("User() = %v, want %v", got, tt.want)
+			}
+			if c := atomic.LoadInt32(&calls); c != tt.wantCalls {
+				t.Errorf("made %d calls, want %d", c, tt.wantCalls)
+			}
+		})
+	}
+}
+
+func TestUserService_Breaker(t *testing.T) {
+	var calls int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer ts.Close()
+
+	now := time.Now()
+	srv := newTestUserService(ts.URL)
+	srv.MaxRetries = 0
+	srv.Breaker = NewCircuitBreaker(2, time.Minute)
+	srv.Breaker.now = func() time.Time { return now }
+
+	for i := 0; i < 2; i++ {
+		_, _ = srv.User(context.Background(), "1")
+	}
+
+	_, err := srv.User(context.Background(), "1")
+	if !errors.Is(err, ErrCircuitOpen) {
+		t.Errorf("User() error = %v, want %v", err, ErrCircuitOpen)
+	}
+	if c := atomic.LoadInt32(&calls); c != 2 {
+		t.Errorf("made %d calls, want 2", c)
+	}
+
+	// After the timeout a single probe goes through.
+	now = now.Add(time.Minute)
+	_, err = srv.User(context.Background(), "1")
+	if errors.Is(err, ErrCircuitOpen) {
+		t.Errorf("User() error = %v, want the probe to be made", err)
+	}
+	if c := atomic.LoadInt32(&calls); c != 3 {
+		t.Errorf("made %d calls, want 3", c)
+	}
+}
+
+func TestUserService_UserCanceled(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	defer ts.Close()
+
+	srv := newTestUserService(ts.URL)
+	srv.Timeout = time.Minute
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	_, err := srv.User(ctx, "1")
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("User() error = %v, want %v", err, context.DeadlineExceeded)
+	}
+	if !srv.Breaker.Allow() {
+		t.Errorf("breaker is open after a canceled call")
+	}
+}
+
+func TestUserService_UserRequestID(t *testing.T) {
+	var got atomic.Value
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got.Store(r.Header.Get(logging.HeaderRequestID))
+		w.Write([]byte(`{"id":1,"username":"u1"}`))
+	}))
+	defer ts.Close()
+
+	ctx := logging.WithRequestID(context.Background(), "req-1")
+	if _, err := newTestUserService(ts.URL).User(ctx, "1"); err != nil {
+		t.Fatalf("User() unexpected error: %v", err)
+	}
+	if got.Load() != "req-1" {
+		t.Errorf("%s = %q, want %q", logging.HeaderRequestID, got.Load(), "req-1")
+	}
+}
+
+func TestUserService_UserTraceparent(t *testing.T) {
+	var got atomic.Value
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got.Store(r.Header.Get(trace.HeaderTraceparent))
+		w.Write([]byte(`{"id":1,"username":"u1"}`))
+	}))
+	defer ts.Close()
+
+	exporter := &trace.MemoryExporter{}
+	ctx, parent := trace.NewTracer(exporter).Start(context.Background(), "test")
+	if _, err := newTestUserService(ts.URL).User(ctx, "1"); err != nil {
+		t.Fatalf("User() unexpected error: %v", err)
+	}
+	parent.End()
+
+	spans := exporter.Spans()
+	if len(spans) != 2 {
+		t.Fatalf("spans = %d, want the attempt and the parent", len(spans))
+	}
+	attempt := spans[0]
+	if attempt.TraceID != parent.TraceID || attempt.ParentID == nil || *attempt.ParentID != parent.SpanID {
+		t.Errorf("attempt span = %+v, want a child of %+v", attempt, parent)
+	}
+	if want := attempt.SpanContext().Traceparent(); got.Load() != want {
+		t.Errorf("%s = %q, want %q", trace.HeaderTraceparent, got.Load(), want)
+	}
+}
+
+func TestUserService_Check(t *testing.T) {
+	tests := []struct {
+		name        string
+		status      int
+		openBreaker bool
+		wantErr     bool
+	}{
+		{name: "Should pass on any answer", status: http.StatusNotFound},
+		{name: "Should fail on 5xx", status: http.StatusBadGateway, wantErr: true},
+		{name: "Should fail fast while the breaker is open", status: http.StatusOK, openBreaker: true, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.status)
+			}))
+			defer ts.Close()
+
+			srv := newTestUserService(ts.URL)
+			if tt.openBreaker {
+				for i := 0; i < srv.Breaker.FailureThreshold; i++ {
+					srv.Breaker.Failure()
+				}
+			}
+			if err := srv.Check(context.Background()); (err != nil) != tt.wantErr {
+				t.Errorf("Check() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,119 @@
+package mem
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/ortymid/t2-http/market"
+	"github.com/ortymid/t2-http/user"
+)
+
+type AccountStore struct {
+	mu       sync.RWMutex
+	lastID   int
+	accounts []*user.Account
+}
+
+// NewAccountStore creates a store with the given accounts.
+func NewAccountStore(accounts ...*user.Account) *AccountStore {
+	srv := &AccountStore{accounts: accounts}
+	for _, a := range accounts {
+		if id, err := strconv.Atoi(a.ID); err == nil && id > srv.lastID {
+			srv.lastID = id
+		}
+	}
+	return srv
+}
+
+// Accounts returns all the accounts.
+func (srv *AccountStore) Accounts(ctx context.Context) ([]*user.Account, error) {
+	srv.mu.RLock()
+	defer srv.mu.RUnlock()
+
+	accounts := make([]*user.Account, len(srv.accounts))
+	copy(accounts, srv.accounts)
+	return accounts, nil
+}
+
+func (srv *AccountStore) Account(ctx context.Context, id string) (*user.Account, error) {
+	srv.mu.RLock()
+	defer srv.mu.RUnlock()
+
+	for _, a := range srv.accounts {
+		if a.ID == id {
+			return a, nil
+		}
+	}
+	return nil, &market.ErrUserNotFound{UserID: id}
+}
+
+// AccountByUsername finds the account by its username ignoring the case.
+func (srv *AccountStore) AccountByUsername(ctx context.Context, username string) (*user.Account, error) {
+	srv.mu.RLock()
+	defer srv.mu.RUnlock()
+
+	for _, a := range srv.accounts {
+		if strings.EqualFold(a.Username, username) {
+			return a, nil
+		}
+	}
+	return nil, &market.ErrUserNotFound{}
+}
+
+func (srv *AccountStore) AddAccount(ctx context.Context, a *user.Account) (*user.Account, error) {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+
+	if srv.taken(a.Username, "") {
+		return nil, user.ErrUsernameTaken
+	}
+
+	srv.lastID++
+	a.ID = strconv.Itoa(srv.lastID)
+	srv.accounts = append(srv.accounts, a)
+	return a, nil
+}
+
+func (srv *AccountStore) ReplaceAccount(ctx context.Context, na *user.Account) (*user.Account, error) {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+
+	if srv.taken(na.Username, na.ID) {
+		return nil, user.ErrUsernameTaken
+	}
+
+	for i, oa := range srv.accounts {
+		if oa.ID == na.ID {
+			srv.accounts[i] = na
+			return na, nil
+		}
+	}
+	return nil, &market.ErrUserNotFound{UserID: na.ID}
+}
+
+// DeleteAccount removes the account.
+func (srv *AccountStore) DeleteAccount(ctx context.Context, id string) error {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+
+	for i, a := range srv.accounts {
+		if a.ID == id {
+			srv.accounts = append(srv.accounts[:i], srv.accounts[i+1:]...)
+			return nil
+		}
+	}
+	return &market.ErrUserNotFound{UserID: id}
+}
+
+// taken reports whether the username belongs to an account other than exceptID.
+// The caller must hold the lock.
+func (srv *AccountStore) taken(username string, exceptID string) bool {
+	for _, a := range srv.accounts {
+		if a.ID != exceptID && strings.EqualFold(a.Username, username) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,96 @@
+package mem
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/ortymid/t2-http/apikey"
+)
+
+type APIKeyStore struct {
+	mu     sync.RWMutex
+	lastID int
+	keys   []*apikey.Key
+}
+
+func NewAPIKeyStore() *APIKeyStore {
+	return &APIKeyStore{}
+}
+
+func (srv *APIKeyStore) Keys(ctx context.Context, userID string) ([]*apikey.Key, error) {
+	srv.mu.RLock()
+	defer srv.mu.RUnlock()
+
+	keys := make([]*apikey.Key, 0)
+	for _, k := range srv.keys {
+		if k.UserID == userID {
+			keys = append(keys, k)
+		}
+	}
+	return keys, nil
+}
+
+func (srv *APIKeyStore) Key(ctx context.Context, id int) (*apikey.Key, error) {
+	srv.mu.RLock()
+	defer srv.mu.RUnlock()
+
+	for _, k := range srv.keys {
+		if k.ID == id {
+			return k, nil
+		}
+	}
+	return nil, apikey.ErrKeyNotFound
+}
+
+func (srv *APIKeyStore) KeyByPrefix(ctx context.Context, prefix string) (*apikey.Key, error) {
+	srv.mu.RLock()
+	defer srv.mu.RUnlock()
+
+	for _, k := range srv.keys {
+		if k.Prefix == prefix {
+			return k, nil
+		}
+	}
+	return nil, apikey.ErrKeyNotFound
+}
+
+func (srv *APIKeyStore) AddKey(ctx context.Context, k *apikey.Key) (*apikey.Key, error) {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+
+	srv.lastID++
+	k.ID = srv.lastID
+	srv.keys = append(srv.keys, k)
+	return k, nil
+}
+
+func (srv *APIKeyStore) DeleteKey(ctx context.Context, id int) error {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+
+	for i, k := range srv.keys {
+		if k.ID == id {
+			srv.keys = append(srv.keys[:i], srv.keys[i+1:]...)
+			return nil
+		}
+	}
+	return apikey.ErrKeyNotFound
+}
+
+// MarkUsed replaces the key with a copy, so the readers holding it
+// do not race with the change.
+func (srv *APIKeyStore) MarkUsed(ctx context.Context, id int, at time.Time) error {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+
+	for i, k := range srv.keys {
+		if k.ID == id {
+			used := *k
+			used.LastUsedAt = at
+			srv.keys[i] = &used
+			return nil
+		}
+	}
+	return apikey.ErrKeyNotFound
+}
@@ -0,0 +1,87 @@
+package mem
+
+import (
+	"bytes"
+	"context"
+	"sync"
+	"time"
+
+	"github.com/ortymid/t2-http/idempotency"
+)
+
+type idempotencyEntry struct {
+	hash    []byte
+	resp    *idempotency.Response
+	expires time.Time
+}
+
+// IdempotencyStore keeps the responses in the memory of a single instance.
+type IdempotencyStore struct {
+	mu      sync.Mutex
+	entries map[string]*idempotencyEntry
+	swept   time.Time
+	now     func() time.Time
+}
+
+func NewIdempotencyStore() *IdempotencyStore {
+	return &IdempotencyStore{
+		entries: make(map[string]*idempotencyEntry),
+		now:     time.Now,
+	}
+}
+
+func (s *IdempotencyStore) Begin(ctx context.Context, userID, key string, hash []byte, ttl time.Duration) (*idempotency.Response, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	if now.Sub(s.swept) >= sweepInterval {
+		s.sweep(now)
+	}
+
+	id := idempotencyID(userID, key)
+	e, ok := s.entries[id]
+	if !ok || !now.Before(e.expires) {
+		s.entries[id] = &idempotencyEntry{hash: hash, expires: now.Add(ttl)}
+		return nil, nil
+	}
+	if !bytes.Equal(e.hash, hash) {
+		return nil, idempotency.ErrMismatch
+	}
+	if e.resp == nil {
+		return nil, idempotency.ErrInProgress
+	}
+	return e.resp, nil
+}
+
+func (s *IdempotencyStore) Finish(ctx context.Context, userID, key string, resp *idempotency.Response) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if e, ok := s.entries[idempotencyID(userID, key)]; ok {
+		e.resp = resp
+	}
+	return nil
+}
+
+func (s *IdempotencyStore) Abort(ctx context.Context, userID, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.entries, idempotencyID(userID, key))
+	return nil
+}
+
+// sweep forgets the expired keys. The caller must hold the mutex.
+func (s *IdempotencyStore) sweep(now time.Time) {
+	for id, e := range s.entries {
+		if !now.Before(e.expires) {
+			delete(s.entries, id)
+		}
+	}
+	s.swept = now
+}
+
+func idempotencyID(userID, key string) string {
+	return userID + "\x00" + key
+}
@@ -1,6 +1,8 @@
 package mem
 
 import (
+	"context"
+	"fmt"
 	"sync"
 
 	"github.com/ortymid/t2-http/market"
@@ -10,6 +12,10 @@ type ProductService struct {
 	mu       sync.RWMutex
 	lastID   int
 	products []*market.Product
+
+	// The events are recorded only when the outbox is enabled.
+	outboxEnabled bool
+	outbox        []market.Event
 }
 
 func NewProductService() *ProductService {
@@ -20,14 +26,14 @@ func NewProductService() *ProductService {
 	return &ProductService{products: products, lastID: 2}
 }
 
-func (srv *ProductService) Products() ([]*market.Product, error) {
+func (srv *ProductService) Products(ctx context.Context) ([]*market.Product, error) {
 	srv.mu.RLock()
 	defer srv.mu.RUnlock()
 
 	return srv.products, nil
 }
 
-func (srv *ProductService) Product(id int) (*market.Product, error) {
+func (srv *ProductService) Product(ctx context.Context, id int) (*market.Product, error) {
 	srv.mu.RLock()
 	defer srv.mu.RUnlock()
 
@@ -39,42 +45,187 @@ func (srv *ProductService) Product(id int) (*market.Product, error) {
 	return nil, market.ErrProductNotFound
 }
 
-func (srv *ProductService) AddProduct(p *market.Product) (*market.Product, error) {
+func (srv *ProductService) AddProduct(ctx context.Context, p *market.Product) (*market.Product, error) {
 	srv.mu.Lock()
 	defer srv.mu.Unlock()
 
 	srv.lastID++
 	p.ID = srv.lastID
 	srv.products = append(srv.products, p)
+	srv.record(market.ProductAdded{Meta: market.NewEventMeta(), Product: *p})
 	return p, nil
 }
 
-func (srv *ProductService) ReplaceProduct(np *market.Product) (*market.Product, error) {
+func (srv *ProductService) ReplaceProduct(ctx context.Context, np *market.Product) (*market.Product, error) {
 	srv.mu.Lock()
 	defer srv.mu.Unlock()
 
 	for i, op := range srv.products {
 		if op.ID == np.ID {
 			srv.products[i] = np
+			srv.record(market.ProductReplaced{Meta: market.NewEventMeta(), Product: *np})
 			return np, nil
 		}
 	}
 	return nil, market.ErrProductNotFound
 }
 
-func (srv *ProductService) DeleteProduct(id int) error {
+func (srv *ProductService) DeleteProduct(ctx context.Context, id int) error {
 	srv.mu.Lock()
 	defer srv.mu.Unlock()
 
 	for i, p := range srv.products {
 		if p.ID == id {
-			if i == len(srv.products)-1 {
-				srv.products[i] = nil
-				srv.products = srv.products[:i]
-			}
+			last := len(srv.products) - 1
 			copy(srv.products[i:], srv.products[i+1:])
+			srv.products[last] = nil
+			srv.products = srv.products[:last]
+			srv.record(market.ProductDeleted{Meta: market.NewEventMeta(), Product: *p})
 			return nil
 		}
 	}
 	return market.ErrProductNotFound
 }
+
+// Batch applies the operations to a copy of the products, which replaces
+// the products at once unless the atomic batch fails.
+func (srv *ProductService) Batch(ctx context.Context, ops []market.Operation, atomic bool) ([]market.OperationResult, error) {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+
+	products := make([]*market.Product, len(srv.products))
+	copy(products, srv.products)
+	lastID := srv.lastID
+	find := func(id int) int {
+		for i, p := range products {
+			if p.ID == id {
+				return i
+			}
+		}
+		return -1
+	}
+
+	results := make([]market.OperationResult, len(ops))
+	events := make([]market.Event, 0, len(ops))
+	failed := false
+	for i, op := range ops {
+		p := op.Product
+		switch op.Op {
+		case market.OpCreate:
+			lastID++
+			p.ID = lastID
+			products = append(products, &p)
+			events = append(events, market.ProductAdded{Meta: market.NewEventMeta(), Product: p})
+		case market.OpReplace:
+			j := find(p.ID)
+			if err := checkOperation(op, products, j); err != nil {
+				results[i].Err = err
+				failed = true
+				continue
+			}
+			products[j] = &p
+			events = append(events, market.ProductReplaced{Meta: market.NewEventMeta(), Product: p})
+		case market.OpDelete:
+			j := find(p.ID)
+			if err := checkOperation(op, products, j); err != nil {
+				results[i].Err = err
+				failed = true
+				continue
+			}
+			p = *products[j]
+			products = append(products[:j:j], products[j+1:]...)
+			events = append(events, market.ProductDeleted{Meta: market.NewEventMeta(), Product: p})
+		default:
+			results[i].Err = fmt.Errorf("%w %q", market.ErrUnknownOperation, op.Op)
+			failed = true
+			continue
+		}
+		results[i].Product = &p
+	}
+
+	if atomic && failed {
+		for i := range results {
+			if results[i].Err == nil {
+				results[i] = market.OperationResult{Err: market.ErrBatchAborted}
+			}
+		}
+		return results, nil
+	}
+
+	srv.products = products
+	srv.lastID = lastID
+	for _, e := range events {
+		srv.record(e)
+	}
+	return results, nil
+}
+
+// checkOperation checks the product found at j may be changed by the operation.
+func checkOperation(op market.Operation, products []*market.Product, j int) error {
+	if j < 0 {
+		return market.ErrProductNotFound
+	}
+	if op.Seller != "" && products[j].Seller != op.Seller {
+		return &market.ErrPermission{Reason: market.ErrNotSeller}
+	}
+	return nil
+}
+
+// EnableOutbox makes the service record the product events in its outbox
+// together with the product changes, so no event is lost between the change
+// and its publishing. The events are expected to be delivered by event.Relay
+// instead of being published by the market.
+func (srv *ProductService) EnableOutbox() {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+
+	srv.outboxEnabled = true
+}
+
+// PendingEvents returns up to limit oldest undelivered events,
+// none for a negative limit.
+func (srv *ProductService) PendingEvents(limit int) ([]market.Event, error) {
+	srv.mu.RLock()
+	defer srv.mu.RUnlock()
+
+	if limit < 0 {
+		limit = 0
+	}
+	if limit > len(srv.outbox) {
+		limit = len(srv.outbox)
+	}
+	events := make([]market.Event, limit)
+	copy(events, srv.outbox)
+	return events, nil
+}
+
+// MarkDelivered removes the events with the given IDs from the outbox.
+func (srv *ProductService) MarkDelivered(ids ...string) error {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+
+	delivered := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		delivered[id] = true
+	}
+
+	pending := srv.outbox[:0]
+	for _, e := range srv.outbox {
+		if !delivered[e.Metadata().ID] {
+			pending = append(pending, e)
+		}
+	}
+	for i := len(pending); i < len(srv.outbox); i++ {
+		srv.outbox[i] = nil
+	}
+	srv.outbox = pending
+	return nil
+}
+
+// record adds the event to the outbox. The caller must hold the write lock.
+func (srv *ProductService) record(e market.Event) {
+	if !srv.outboxEnabled {
+		return
+	}
+	srv.outbox = append(srv.outbox, e)
+}
@@ -0,0 +1,61 @@
+package mem
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/ortymid/t2-http/ratelimit"
+)
+
+// sweepInterval is how often the stores forget the stale entries.
+const sweepInterval = time.Minute
+
+type limitedBucket struct {
+	ratelimit.Bucket
+	limit ratelimit.Limit
+}
+
+// RateLimitStore keeps the buckets in the memory of a single instance.
+type RateLimitStore struct {
+	mu      sync.Mutex
+	buckets map[string]*limitedBucket
+	swept   time.Time
+	now     func() time.Time
+}
+
+func NewRateLimitStore() *RateLimitStore {
+	return &RateLimitStore{
+		buckets: make(map[string]*limitedBucket),
+		now:     time.Now,
+	}
+}
+
+func (s *RateLimitStore) Take(ctx context.Context, key string, l ratelimit.Limit) (ratelimit.Result, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	if now.Sub(s.swept) >= sweepInterval {
+		s.sweep(now)
+	}
+
+	b, ok := s.buckets[key]
+	if !ok {
+		b = &limitedBucket{}
+		s.buckets[key] = b
+	}
+	b.limit = l
+	return b.Take(now, l), nil
+}
+
+// sweep forgets the full buckets, they are the same as the missing ones.
+// The caller must hold the mutex.
+func (s *RateLimitStore) sweep(now time.Time) {
+	for key, b := range s.buckets {
+		if b.Full(now, b.limit) {
+			delete(s.buckets, key)
+		}
+	}
+	s.swept = now
+}
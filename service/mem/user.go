@@ -1,6 +1,7 @@
 package mem
 
 import (
+	"context"
 	"sync"
 
 	"github.com/ortymid/t2-http/market"
@@ -20,7 +21,7 @@ func NewUserService() *UserService {
 	return &UserService{users: users, lastID: 2}
 }
 
-func (srv *UserService) User(id string) (*market.User, error) {
+func (srv *UserService) User(ctx context.Context, id string) (*market.User, error) {
 	srv.mu.RLock()
 	defer srv.mu.RUnlock()
 
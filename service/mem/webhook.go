@@ -0,0 +1,140 @@
+package mem
+
+import (
+	"context"
+	"sync"
+
+	"github.com/ortymid/t2-http/webhook"
+)
+
+// Limits of the kept webhook history.
+const (
+	maxDeliveriesPerSubscription = 100
+	maxDeadLetters               = 1000
+)
+
+type WebhookStore struct {
+	mu sync.RWMutex
+
+	lastSubscriptionID int
+	subscriptions      []*webhook.Subscription
+
+	lastDeliveryID int
+	deliveries     map[int][]*webhook.Delivery
+
+	lastDeadLetterID int
+	deadLetters      []*webhook.DeadLetter
+}
+
+func NewWebhookStore() *WebhookStore {
+	return &WebhookStore{deliveries: make(map[int][]*webhook.Delivery)}
+}
+
+func (srv *WebhookStore) Subscriptions(ctx context.Context) ([]*webhook.Subscription, error) {
+	srv.mu.RLock()
+	defer srv.mu.RUnlock()
+
+	subs := make([]*webhook.Subscription, len(srv.subscriptions))
+	copy(subs, srv.subscriptions)
+	return subs, nil
+}
+
+func (srv *WebhookStore) Subscription(ctx context.Context, id int) (*webhook.Subscription, error) {
+	srv.mu.RLock()
+	defer srv.mu.RUnlock()
+
+	for _, s := range srv.subscriptions {
+		if s.ID == id {
+			return s, nil
+		}
+	}
+	return nil, webhook.ErrSubscriptionNotFound
+}
+
+func (srv *WebhookStore) AddSubscription(ctx context.Context, s *webhook.Subscription) (*webhook.Subscription, error) {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+
+	srv.lastSubscriptionID++
+	s.ID = srv.lastSubscriptionID
+	srv.subscriptions = append(srv.subscriptions, s)
+	return s, nil
+}
+
+func (srv *WebhookStore) ReplaceSubscription(ctx context.Context, ns *webhook.Subscription) (*webhook.Subscription, error) {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+
+	for i, old := range srv.subscriptions {
+		if old.ID == ns.ID {
+			srv.subscriptions[i] = ns
+			return ns, nil
+		}
+	}
+	return nil, webhook.ErrSubscriptionNotFound
+}
+
+func (srv *WebhookStore) DeleteSubscription(ctx context.Context, id int) error {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+
+	for i, s := range srv.subscriptions {
+		if s.ID == id {
+			copy(srv.subscriptions[i:], srv.subscriptions[i+1:])
+			srv.subscriptions[len(srv.subscriptions)-1] = nil
+			srv.subscriptions = srv.subscriptions[:len(srv.subscriptions)-1]
+			delete(srv.deliveries, id)
+			return nil
+		}
+	}
+	return webhook.ErrSubscriptionNotFound
+}
+
+// AddDelivery logs the delivery keeping only the latest ones per subscription.
+func (srv *WebhookStore) AddDelivery(ctx context.Context, d *webhook.Delivery) error {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+
+	srv.lastDeliveryID++
+	d.ID = srv.lastDeliveryID
+
+	ds := append(srv.deliveries[d.SubscriptionID], d)
+	if len(ds) > maxDeliveriesPerSubscription {
+		ds = ds[len(ds)-maxDeliveriesPerSubscription:]
+	}
+	srv.deliveries[d.SubscriptionID] = ds
+	return nil
+}
+
+func (srv *WebhookStore) Deliveries(ctx context.Context, subscriptionID int) ([]*webhook.Delivery, error) {
+	srv.mu.RLock()
+	defer srv.mu.RUnlock()
+
+	ds := make([]*webhook.Delivery, len(srv.deliveries[subscriptionID]))
+	copy(ds, srv.deliveries[subscriptionID])
+	return ds, nil
+}
+
+// AddDeadLetter adds the undelivered event keeping only the latest ones.
+func (srv *WebhookStore) AddDeadLetter(ctx context.Context, dl *webhook.DeadLetter) error {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+
+	srv.lastDeadLetterID++
+	dl.ID = srv.lastDeadLetterID
+
+	srv.deadLetters = append(srv.deadLetters, dl)
+	if len(srv.deadLetters) > maxDeadLetters {
+		srv.deadLetters = srv.deadLetters[len(srv.deadLetters)-maxDeadLetters:]
+	}
+	return nil
+}
+
+func (srv *WebhookStore) DeadLetters(ctx context.Context) ([]*webhook.DeadLetter, error) {
+	srv.mu.RLock()
+	defer srv.mu.RUnlock()
+
+	dls := make([]*webhook.DeadLetter, len(srv.deadLetters))
+	copy(dls, srv.deadLetters)
+	return dls, nil
+}
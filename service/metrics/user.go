@@ -0,0 +1,62 @@
+// Package metrics provides decorators measuring the market services.
+package metrics
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/ortymid/t2-http/market"
+	"github.com/ortymid/t2-http/metrics"
+)
+
+// Call results used as the "result" label.
+const (
+	resultOK          = "ok"
+	resultNotFound    = "not_found"
+	resultUnavailable = "unavailable"
+	resultError       = "error"
+)
+
+// UserService counts the calls to the underlying market.UserService
+// by their result and measures their latency.
+type UserService struct {
+	Service market.UserService
+
+	calls   *metrics.CounterVec
+	latency *metrics.HistogramVec
+}
+
+// NewUserService registers the user service metrics in the registry.
+func NewUserService(s market.UserService, reg *metrics.Registry) *UserService {
+	return &UserService{
+		Service: s,
+		calls: reg.NewCounterVec("user_service_calls_total",
+			"User service calls by result.", "result"),
+		latency: reg.NewHistogramVec("user_service_call_duration_seconds",
+			"User service call latency.", nil, "result"),
+	}
+}
+
+func (srv *UserService) User(ctx context.Context, id string) (*market.User, error) {
+	start := time.Now()
+	u, err := srv.Service.User(ctx, id)
+
+	result := callResult(err)
+	srv.calls.Inc(result)
+	srv.latency.ObserveDuration(time.Since(start), result)
+	return u, err
+}
+
+func callResult(err error) string {
+	switch {
+	case err == nil:
+		return resultOK
+	case errors.Is(err, &market.ErrUserNotFound{}):
+		return resultNotFound
+	case errors.Is(err, &market.ErrUnavailable{}):
+		return resultUnavailable
+	default:
+		return resultError
+	}
+}
@@ -0,0 +1,42 @@
+package metrics
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/ortymid/t2-http/market"
+	"github.com/ortymid/t2-http/market/mock"
+	"github.com/ortymid/t2-http/metrics"
+)
+
+func TestUserService_User(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	us := mock.NewMockUserService(ctrl)
+	us.EXPECT().User(gomock.Any(), "1").Return(&market.User{ID: "1"}, nil)
+	us.EXPECT().User(gomock.Any(), "2").Return(nil, &market.ErrUserNotFound{UserID: "2"})
+	us.EXPECT().User(gomock.Any(), "3").Return(nil, &market.ErrUnavailable{Service: "user service"})
+	us.EXPECT().User(gomock.Any(), "4").Return(nil, errors.New("bad response"))
+
+	reg := metrics.NewRegistry()
+	srv := NewUserService(us, reg)
+	for _, id := range []string{"1", "2", "3", "4"} {
+		_, _ = srv.User(context.Background(), id)
+	}
+
+	var buf bytes.Buffer
+	if _, err := reg.WriteTo(&buf); err != nil {
+		t.Fatalf("WriteTo() unexpected error: %v", err)
+	}
+	for _, result := range []string{resultOK, resultNotFound, resultUnavailable, resultError} {
+		want := `user_service_calls_total{result="` + result + `"} 1`
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("metrics = %s, want %s", buf.String(), want)
+		}
+	}
+}
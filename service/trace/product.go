@@ -0,0 +1,65 @@
+package trace
+
+import (
+	"context"
+
+	"github.com/ortymid/t2-http/market"
+	"github.com/ortymid/t2-http/trace"
+)
+
+// ProductService records a span per call to the underlying market.ProductService.
+type ProductService struct {
+	Service market.ProductService
+}
+
+func NewProductService(s market.ProductService) *ProductService {
+	return &ProductService{Service: s}
+}
+
+func (srv *ProductService) Products(ctx context.Context) (_ []*market.Product, err error) {
+	ctx, span := trace.Start(ctx, "ProductService.Products")
+	defer span.EndWith(&err)
+
+	ps, err := srv.Service.Products(ctx)
+	span.SetAttributes("products", len(ps))
+	return ps, err
+}
+
+func (srv *ProductService) Product(ctx context.Context, id int) (_ *market.Product, err error) {
+	ctx, span := trace.Start(ctx, "ProductService.Product", "product_id", id)
+	defer span.EndWith(&err)
+
+	return srv.Service.Product(ctx, id)
+}
+
+func (srv *ProductService) AddProduct(ctx context.Context, p *market.Product) (_ *market.Product, err error) {
+	ctx, span := trace.Start(ctx, "ProductService.AddProduct")
+	defer span.EndWith(&err)
+
+	p, err = srv.Service.AddProduct(ctx, p)
+	if err == nil {
+		span.SetAttributes("product_id", p.ID)
+	}
+	return p, err
+}
+
+func (srv *ProductService) ReplaceProduct(ctx context.Context, p *market.Product) (_ *market.Product, err error) {
+	ctx, span := trace.Start(ctx, "ProductService.ReplaceProduct", "product_id", p.ID)
+	defer span.EndWith(&err)
+
+	return srv.Service.ReplaceProduct(ctx, p)
+}
+
+func (srv *ProductService) DeleteProduct(ctx context.Context, id int) (err error) {
+	ctx, span := trace.Start(ctx, "ProductService.DeleteProduct", "product_id", id)
+	defer span.EndWith(&err)
+
+	return srv.Service.DeleteProduct(ctx, id)
+}
+
+func (srv *ProductService) Batch(ctx context.Context, ops []market.Operation, atomic bool) (_ []market.OperationResult, err error) {
+	ctx, span := trace.Start(ctx, "ProductService.Batch", "operations", len(ops), "atomic", atomic)
+	defer span.EndWith(&err)
+
+	return srv.Service.Batch(ctx, ops, atomic)
+}
@@ -0,0 +1,25 @@
+// Package trace provides decorators recording the spans of the market service calls.
+package trace
+
+import (
+	"context"
+
+	"github.com/ortymid/t2-http/market"
+	"github.com/ortymid/t2-http/trace"
+)
+
+// UserService records a span per call to the underlying market.UserService.
+type UserService struct {
+	Service market.UserService
+}
+
+func NewUserService(s market.UserService) *UserService {
+	return &UserService{Service: s}
+}
+
+func (srv *UserService) User(ctx context.Context, id string) (_ *market.User, err error) {
+	ctx, span := trace.Start(ctx, "UserService.User", "user_id", id)
+	defer span.EndWith(&err)
+
+	return srv.Service.User(ctx, id)
+}
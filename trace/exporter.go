@@ -0,0 +1,52 @@
+package trace
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+)
+
+// WriterExporter writes every span as a JSON object on its own line,
+// e.g. to the standard output.
+type WriterExporter struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+}
+
+func NewWriterExporter(w io.Writer) *WriterExporter {
+	return &WriterExporter{enc: json.NewEncoder(w)}
+}
+
+func (e *WriterExporter) ExportSpan(s *Span) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	// The spans have nothing to fail the encoding but the attributes,
+	// losing a span is better than breaking the request.
+	_ = e.enc.Encode(s)
+}
+
+// MemoryExporter keeps the spans in memory, it is meant for tests.
+type MemoryExporter struct {
+	mu    sync.Mutex
+	spans []*Span
+}
+
+func (e *MemoryExporter) ExportSpan(s *Span) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.spans = append(e.spans, s)
+}
+
+// Spans returns the exported spans in the order they ended.
+func (e *MemoryExporter) Spans() []*Span {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return append([]*Span(nil), e.spans...)
+}
+
+// Reset forgets the exported spans.
+func (e *MemoryExporter) Reset() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.spans = nil
+}
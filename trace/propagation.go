@@ -0,0 +1,77 @@
+package trace
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strings"
+)
+
+// HeaderTraceparent carries the span context between the services,
+// see https://www.w3.org/TR/trace-context/.
+const HeaderTraceparent = "traceparent"
+
+// ErrTraceparent is returned for a malformed traceparent header.
+var ErrTraceparent = errors.New("malformed traceparent")
+
+const flagSampled = 0x01
+
+// Traceparent formats the span context as the traceparent header value.
+func (sc SpanContext) Traceparent() string {
+	flags := "00"
+	if sc.Sampled {
+		flags = "01"
+	}
+	return "00-" + sc.TraceID.String() + "-" + sc.SpanID.String() + "-" + flags
+}
+
+// ParseTraceparent reads the traceparent header value. The fields appended
+// by the versions after 00 are ignored.
+func ParseTraceparent(s string) (SpanContext, error) {
+	var sc SpanContext
+	parts := strings.Split(s, "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" {
+		return sc, ErrTraceparent
+	}
+	if parts[0] == "00" && len(parts) != 4 {
+		return sc, ErrTraceparent
+	}
+
+	var version, flags [1]byte
+	if !decodeHex(version[:], parts[0]) ||
+		!decodeHex(sc.TraceID[:], parts[1]) ||
+		!decodeHex(sc.SpanID[:], parts[2]) ||
+		!decodeHex(flags[:], parts[3]) ||
+		!sc.IsValid() {
+		return SpanContext{}, ErrTraceparent
+	}
+	sc.Sampled = flags[0]&flagSampled != 0
+	return sc, nil
+}
+
+// decodeHex fills dst with the lowercase hex string of the exact length.
+func decodeHex(dst []byte, s string) bool {
+	if len(s) != hex.EncodedLen(len(dst)) || strings.ToLower(s) != s {
+		return false
+	}
+	_, err := hex.Decode(dst, []byte(s))
+	return err == nil
+}
+
+// Inject adds the traceparent header of the current span of the context.
+func Inject(ctx context.Context, h http.Header) {
+	if sc := SpanFromContext(ctx).SpanContext(); sc.IsValid() {
+		h.Set(HeaderTraceparent, sc.Traceparent())
+	}
+}
+
+// Extract returns a context continuing the trace of the traceparent header.
+// A missing or malformed header starts a new trace.
+func Extract(ctx context.Context, h http.Header) context.Context {
+	sc, err := ParseTraceparent(h.Get(HeaderTraceparent))
+	if err != nil {
+		return ctx
+	}
+	return ContextWithRemote(ctx, sc)
+}
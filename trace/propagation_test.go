@@ -0,0 +1,97 @@
+package trace
+
+import (
+	"context"
+	"net/http"
+	"testing"
+)
+
+func TestParseTraceparent(t *testing.T) {
+	sampled := SpanContext{
+		TraceID: TraceID{0x4b, 0xf9, 0x2f, 0x35, 0x77, 0xb3, 0x4d, 0xa6, 0xa3, 0xce, 0x92, 0x9d, 0x0e, 0x0e, 0x47, 0x36},
+		SpanID:  SpanID{0x00, 0xf0, 0x67, 0xaa, 0x0b, 0xa9, 0x02, 0xb7},
+		Sampled: true,
+	}
+	unsampled := sampled
+	unsampled.Sampled = false
+
+	tests := []struct {
+		name    string
+		header  string
+		want    SpanContext
+		wantErr bool
+	}{
+		{
+			name:   "Should parse the sampled context",
+			header: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+			want:   sampled,
+		},
+		{
+			name:   "Should parse the unsampled context",
+			header: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00",
+			want:   unsampled,
+		},
+		{
+			name:   "Should ignore the fields of a future version",
+			header: "cc-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-what-the-future-will-be-like",
+			want:   sampled,
+		},
+		{
+			name:    "Should fail on extra fields of version 00",
+			header:  "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra",
+			wantErr: true,
+		},
+		{
+			name:    "Should fail on the invalid version",
+			header:  "ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+			wantErr: true,
+		},
+		{
+			name:    "Should fail on uppercase hex",
+			header:  "00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01",
+			wantErr: true,
+		},
+		{
+			name:    "Should fail on the zero trace ID",
+			header:  "00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+			wantErr: true,
+		},
+		{
+			name:    "Should fail on the short span ID",
+			header:  "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902-01",
+			wantErr: true,
+		},
+		{
+			name:    "Should fail on the empty header",
+			header:  "",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseTraceparent(tt.header)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseTraceparent() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("ParseTraceparent() = %+v, want %+v", got, tt.want)
+			}
+			if !tt.wantErr && tt.header[:2] == "00" && got.Traceparent() != tt.header {
+				t.Errorf("Traceparent() = %q, want %q", got.Traceparent(), tt.header)
+			}
+		})
+	}
+}
+
+func TestInjectExtract(t *testing.T) {
+	exporter := &MemoryExporter{}
+	ctx, s := NewTracer(exporter).Start(context.Background(), "client")
+	h := http.Header{}
+	Inject(ctx, h)
+
+	_, server := NewTracer(exporter).Start(Extract(context.Background(), h), "server")
+	if server.TraceID != s.TraceID || server.ParentID == nil || *server.ParentID != s.SpanID {
+		t.Errorf("server span = %+v, want a child of %+v", server, s)
+	}
+}
@@ -0,0 +1,231 @@
+// Package trace records the spans of the request handling and propagates
+// the trace to other services in the W3C Trace Context format.
+package trace
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"sync"
+	"time"
+)
+
+// TraceID identifies the whole trace.
+type TraceID [16]byte
+
+func (id TraceID) IsValid() bool { return id != TraceID{} }
+
+func (id TraceID) String() string { return hex.EncodeToString(id[:]) }
+
+func (id TraceID) MarshalText() ([]byte, error) { return []byte(id.String()), nil }
+
+// SpanID identifies a span within the trace.
+type SpanID [8]byte
+
+func (id SpanID) IsValid() bool { return id != SpanID{} }
+
+func (id SpanID) String() string { return hex.EncodeToString(id[:]) }
+
+func (id SpanID) MarshalText() ([]byte, error) { return []byte(id.String()), nil }
+
+// SpanContext is the part of the span passed to the child spans,
+// including the ones in other services.
+type SpanContext struct {
+	TraceID TraceID
+	SpanID  SpanID
+	// Sampled tells whether the trace is recorded.
+	Sampled bool
+}
+
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID.IsValid() && sc.SpanID.IsValid()
+}
+
+// Span is a timed operation within the trace. The span is exported when it ends.
+// A span is not safe for concurrent use. The methods of the nil span do nothing.
+type Span struct {
+	Name       string                 `json:"name"`
+	TraceID    TraceID                `json:"trace_id"`
+	SpanID     SpanID                 `json:"span_id"`
+	ParentID   *SpanID                `json:"parent_id,omitempty"`
+	StartTime  time.Time              `json:"start_time"`
+	EndTime    time.Time              `json:"end_time"`
+	Attributes map[string]interface{} `json:"attributes,omitempty"`
+	Error      string                 `json:"error,omitempty"`
+
+	tracer  *Tracer
+	sampled bool
+	ended   bool
+}
+
+// SpanContext returns the identity of the span to propagate.
+func (s *Span) SpanContext() SpanContext {
+	if s == nil {
+		return SpanContext{}
+	}
+	return SpanContext{TraceID: s.TraceID, SpanID: s.SpanID, Sampled: s.sampled}
+}
+
+// SetAttributes adds the attributes given as alternating keys and values.
+func (s *Span) SetAttributes(keyvals ...interface{}) {
+	if s == nil {
+		return
+	}
+	if s.Attributes == nil {
+		s.Attributes = make(map[string]interface{}, len(keyvals)/2)
+	}
+	for i := 0; i+1 < len(keyvals); i += 2 {
+		key, ok := keyvals[i].(string)
+		if !ok {
+			continue
+		}
+		s.Attributes[key] = keyvals[i+1]
+	}
+}
+
+// SetError marks the span failed. A nil error is ignored.
+func (s *Span) SetError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.Error = err.Error()
+}
+
+// End finishes the span and passes it to the exporter.
+// The calls after the first one do nothing.
+func (s *Span) End() {
+	if s == nil || s.ended {
+		return
+	}
+	s.ended = true
+	s.EndTime = s.tracer.now()
+	if s.sampled {
+		s.tracer.exporter.ExportSpan(s)
+	}
+}
+
+// EndWith ends the span marking it failed with the error errp points to.
+// It is meant to be deferred with the named error result of the traced function.
+func (s *Span) EndWith(errp *error) {
+	if s == nil {
+		return
+	}
+	s.SetError(*errp)
+	s.End()
+}
+
+// Exporter receives the ended spans. It must be safe for concurrent use.
+type Exporter interface {
+	ExportSpan(s *Span)
+}
+
+// Tracer starts the spans and exports them.
+type Tracer struct {
+	exporter Exporter
+	now      func() time.Time
+}
+
+// NewTracer creates a tracer passing the spans to the exporter.
+// A tracer without an exporter does not start spans at all.
+func NewTracer(e Exporter) *Tracer {
+	return &Tracer{exporter: e, now: time.Now}
+}
+
+// Start starts a span as a child of the span or the remote span context
+// of the context, or as a root of a new trace. The returned context
+// carries the new span. The attributes are alternating keys and values.
+func (t *Tracer) Start(ctx context.Context, name string, keyvals ...interface{}) (context.Context, *Span) {
+	if t == nil || t.exporter == nil {
+		return ctx, nil
+	}
+
+	s := &Span{
+		Name:      name,
+		SpanID:    newSpanID(),
+		StartTime: t.now(),
+		tracer:    t,
+		sampled:   true,
+	}
+	parent := SpanFromContext(ctx).SpanContext()
+	if !parent.IsValid() {
+		parent = remoteFromContext(ctx)
+	}
+	if parent.IsValid() {
+		s.TraceID = parent.TraceID
+		s.ParentID = &parent.SpanID
+		s.sampled = parent.Sampled
+	} else {
+		s.TraceID = newTraceID()
+	}
+	s.SetAttributes(keyvals...)
+
+	return context.WithValue(ctx, keySpan, s), s
+}
+
+// Start starts a span with the tracer of the span in the context
+// or, if there is none, with the default tracer.
+func Start(ctx context.Context, name string, keyvals ...interface{}) (context.Context, *Span) {
+	t := Default()
+	if parent := SpanFromContext(ctx); parent != nil {
+		t = parent.tracer
+	}
+	return t.Start(ctx, name, keyvals...)
+}
+
+var (
+	defaultMu     sync.RWMutex
+	defaultTracer = NewTracer(nil)
+)
+
+// Default returns the tracer starting the spans outside of a trace.
+// Initially it does not trace.
+func Default() *Tracer {
+	defaultMu.RLock()
+	defer defaultMu.RUnlock()
+	return defaultTracer
+}
+
+// SetDefault replaces the tracer returned by Default.
+func SetDefault(t *Tracer) {
+	defaultMu.Lock()
+	defer defaultMu.Unlock()
+	defaultTracer = t
+}
+
+type contextKey int
+
+const (
+	keySpan contextKey = iota
+	keyRemote
+)
+
+// SpanFromContext returns the current span of the context, if any.
+func SpanFromContext(ctx context.Context) *Span {
+	s, _ := ctx.Value(keySpan).(*Span)
+	return s
+}
+
+// ContextWithRemote returns a context whose spans continue the trace
+// of another service.
+func ContextWithRemote(ctx context.Context, sc SpanContext) context.Context {
+	return context.WithValue(ctx, keyRemote, sc)
+}
+
+func remoteFromContext(ctx context.Context) SpanContext {
+	sc, _ := ctx.Value(keyRemote).(SpanContext)
+	return sc
+}
+
+func newTraceID() (id TraceID) {
+	for !id.IsValid() {
+		_, _ = rand.Read(id[:])
+	}
+	return id
+}
+
+func newSpanID() (id SpanID) {
+	for !id.IsValid() {
+		_, _ = rand.Read(id[:])
+	}
+	return id
+}
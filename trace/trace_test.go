@@ -0,0 +1,99 @@
+package trace
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestTracer_Start(t *testing.T) {
+	remote := SpanContext{
+		TraceID: TraceID{1},
+		SpanID:  SpanID{2},
+		Sampled: true,
+	}
+
+	t.Run("Should start a new trace", func(t *testing.T) {
+		exporter := &MemoryExporter{}
+		_, s := NewTracer(exporter).Start(context.Background(), "root", "k", "v")
+		s.End()
+
+		spans := exporter.Spans()
+		if len(spans) != 1 {
+			t.Fatalf("spans = %d, want 1", len(spans))
+		}
+		if !spans[0].TraceID.IsValid() || spans[0].ParentID != nil {
+			t.Errorf("span = %+v, want a root", spans[0])
+		}
+		if spans[0].Attributes["k"] != "v" {
+			t.Errorf("Attributes = %v, want k=v", spans[0].Attributes)
+		}
+	})
+
+	t.Run("Should continue the trace of the context", func(t *testing.T) {
+		exporter := &MemoryExporter{}
+		ctx, parent := NewTracer(exporter).Start(context.Background(), "parent")
+		_, child := Start(ctx, "child")
+		child.EndWith(new(error))
+		parent.End()
+
+		spans := exporter.Spans()
+		if len(spans) != 2 || spans[0] != child {
+			t.Fatalf("spans = %v, want the child and the parent", spans)
+		}
+		if child.TraceID != parent.TraceID || child.ParentID == nil || *child.ParentID != parent.SpanID {
+			t.Errorf("child = %+v, want a child of %+v", child, parent)
+		}
+	})
+
+	t.Run("Should continue the remote trace", func(t *testing.T) {
+		exporter := &MemoryExporter{}
+		ctx := ContextWithRemote(context.Background(), remote)
+		_, s := NewTracer(exporter).Start(ctx, "server")
+		s.End()
+
+		if s.TraceID != remote.TraceID || s.ParentID == nil || *s.ParentID != remote.SpanID {
+			t.Errorf("span = %+v, want a child of %+v", s, remote)
+		}
+	})
+
+	t.Run("Should not export the unsampled trace", func(t *testing.T) {
+		exporter := &MemoryExporter{}
+		unsampled := remote
+		unsampled.Sampled = false
+		ctx := ContextWithRemote(context.Background(), unsampled)
+		_, s := NewTracer(exporter).Start(ctx, "server")
+		s.End()
+
+		if len(exporter.Spans()) != 0 {
+			t.Errorf("spans = %v, want none", exporter.Spans())
+		}
+		if s.SpanContext().Sampled {
+			t.Errorf("SpanContext() = %+v, want unsampled", s.SpanContext())
+		}
+	})
+
+	t.Run("Should record the error", func(t *testing.T) {
+		exporter := &MemoryExporter{}
+		_, s := NewTracer(exporter).Start(context.Background(), "failing")
+		err := errors.New("boom")
+		s.EndWith(&err)
+		s.End()
+
+		spans := exporter.Spans()
+		if len(spans) != 1 || spans[0].Error != "boom" {
+			t.Errorf("spans = %v, want one failed span", spans)
+		}
+	})
+
+	t.Run("Should not trace without an exporter", func(t *testing.T) {
+		ctx := context.Background()
+		got, s := NewTracer(nil).Start(ctx, "nothing")
+		if s != nil || got != ctx {
+			t.Errorf("Start() = %v, %v, want the same context and no span", got, s)
+		}
+		// The nil span is safe to use.
+		s.SetAttributes("k", "v")
+		s.End()
+	})
+}
@@ -0,0 +1,71 @@
+// Package user provides the built-in user management: registration,
+// login and profiles of the market users.
+package user
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+	"unicode"
+)
+
+var (
+	ErrUsernameTaken      = errors.New("username already taken")
+	ErrInvalidCredentials = errors.New("invalid username or password")
+	ErrInvalidAccount     = errors.New("invalid account")
+	ErrWrongPassword      = errors.New("wrong current password")
+)
+
+// Account is a registered user with the credentials.
+// The ID is an integer string as it is carried by the JWT claims.
+type Account struct {
+	ID           string
+	Username     string
+	PasswordHash []byte
+	CreatedAt    time.Time
+	UpdatedAt    time.Time
+}
+
+// Store represents an account data backend.
+// A missing account is reported with *market.ErrUserNotFound.
+type Store interface {
+	Account(ctx context.Context, id string) (*Account, error)
+	AccountByUsername(ctx context.Context, username string) (*Account, error)
+	// AddAccount assigns the ID to the account.
+	// It returns ErrUsernameTaken when the username is in use.
+	AddAccount(context.Context, *Account) (*Account, error)
+	// ReplaceAccount returns ErrUsernameTaken when the new username is in use.
+	ReplaceAccount(context.Context, *Account) (*Account, error)
+}
+
+// Password length limits. Bcrypt ignores everything past 72 bytes.
+const (
+	minPasswordLength = 8
+	maxPasswordLength = 72
+)
+
+// Username length limits.
+const (
+	minUsernameLength = 3
+	maxUsernameLength = 32
+)
+
+func validateUsername(username string) error {
+	if len(username) < minUsernameLength || len(username) > maxUsernameLength {
+		return fmt.Errorf("%w: username must be %d to %d characters long", ErrInvalidAccount, minUsernameLength, maxUsernameLength)
+	}
+	for _, r := range username {
+		if r > unicode.MaxASCII || !(unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_' || r == '-' || r == '.') {
+			return fmt.Errorf("%w: username may contain only latin letters, digits, '_', '-' and '.'", ErrInvalidAccount)
+		}
+	}
+	return nil
+}
+
+func validatePassword(password string) error {
+	if len(password) < minPasswordLength || len(password) > maxPasswordLength {
+		return fmt.Errorf("%w: password must be %d to %d bytes long", ErrInvalidAccount, minPasswordLength, maxPasswordLength)
+	}
+	return nil
+}
@@ -0,0 +1,179 @@
+package user
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/ortymid/t2-http/market"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// TokenIssuer creates access tokens for the logged in users.
+// It is implemented by *jwt.Issuer.
+type TokenIssuer interface {
+	IssueToken(userID string) (string, error)
+}
+
+// Interface may be used by protocol layers for RPC or mocking.
+type Interface interface {
+	Register(ctx context.Context, username, password string) (*Account, error)
+	Login(ctx context.Context, username, password string) (token string, err error)
+	Profile(ctx context.Context, userID string) (*Account, error)
+	UpdateProfile(ctx context.Context, userID string, u ProfileUpdate) (*Account, error)
+}
+
+// ProfileUpdate lists the account changes. Empty fields are left as is.
+// A new Password needs the CurrentPassword.
+type ProfileUpdate struct {
+	Username        string
+	Password        string
+	CurrentPassword string
+}
+
+// Service manages the accounts. It also implements market.UserService,
+// so the market may run without the external user service.
+type Service struct {
+	Store  Store
+	Issuer TokenIssuer
+	// BcryptCost defaults to bcrypt.DefaultCost.
+	BcryptCost int
+
+	dummyOnce sync.Once
+	dummy     []byte
+}
+
+// Register creates a new account.
+func (s *Service) Register(ctx context.Context, username, password string) (*Account, error) {
+	if err := validateUsername(username); err != nil {
+		return nil, fmt.Errorf("register: %w", err)
+	}
+	if err := validatePassword(password); err != nil {
+		return nil, fmt.Errorf("register: %w", err)
+	}
+
+	hash, err := s.hash(password)
+	if err != nil {
+		return nil, fmt.Errorf("register: %w", err)
+	}
+
+	now := time.Now().UTC()
+	a := &Account{
+		Username:     username,
+		PasswordHash: hash,
+		CreatedAt:    now,
+		UpdatedAt:    now,
+	}
+	a, err = s.Store.AddAccount(ctx, a)
+	if err != nil {
+		return nil, fmt.Errorf("register: %w", err)
+	}
+	return a, nil
+}
+
+// Login checks the credentials and issues a token for the user.
+func (s *Service) Login(ctx context.Context, username, password string) (string, error) {
+	a, err := s.Store.AccountByUsername(ctx, username)
+	if errors.Is(err, &market.ErrUserNotFound{}) {
+		// Take the same time as for the wrong password
+		// to not reveal which usernames exist.
+		_ = bcrypt.CompareHashAndPassword(s.dummyHash(), []byte(password))
+		return "", fmt.Errorf("login: %w", ErrInvalidCredentials)
+	}
+	if err != nil {
+		return "", fmt.Errorf("login: %w", err)
+	}
+
+	if err := bcrypt.CompareHashAndPassword(a.PasswordHash, []byte(password)); err != nil {
+		return "", fmt.Errorf("login: %w", ErrInvalidCredentials)
+	}
+
+	token, err := s.Issuer.IssueToken(a.ID)
+	if err != nil {
+		return "", fmt.Errorf("login: %w", err)
+	}
+	return token, nil
+}
+
+// Profile returns the account of the user.
+func (s *Service) Profile(ctx context.Context, userID string) (*Account, error) {
+	a, err := s.Store.Account(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("profile: %w", err)
+	}
+	return a, nil
+}
+
+// UpdateProfile changes the username and/or the password of the user.
+// The password is changed only if the current one is given right,
+// so a stolen token cannot lock the user out.
+func (s *Service) UpdateProfile(ctx context.Context, userID string, u ProfileUpdate) (*Account, error) {
+	a, err := s.Store.Account(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("update profile: %w", err)
+	}
+
+	updated := *a
+	if u.Username != "" {
+		if err := validateUsername(u.Username); err != nil {
+			return nil, fmt.Errorf("update profile: %w", err)
+		}
+		updated.Username = u.Username
+	}
+	if u.Password != "" {
+		if u.CurrentPassword == "" {
+			return nil, fmt.Errorf("update profile: %w: current password required", ErrInvalidAccount)
+		}
+		if err := bcrypt.CompareHashAndPassword(a.PasswordHash, []byte(u.CurrentPassword)); err != nil {
+			return nil, fmt.Errorf("update profile: %w", ErrWrongPassword)
+		}
+		if err := validatePassword(u.Password); err != nil {
+			return nil, fmt.Errorf("update profile: %w", err)
+		}
+		updated.PasswordHash, err = s.hash(u.Password)
+		if err != nil {
+			return nil, fmt.Errorf("update profile: %w", err)
+		}
+	}
+	updated.UpdatedAt = time.Now().UTC()
+
+	a, err = s.Store.ReplaceAccount(ctx, &updated)
+	if err != nil {
+		return nil, fmt.Errorf("update profile: %w", err)
+	}
+	return a, nil
+}
+
+// User implements market.UserService.
+func (s *Service) User(ctx context.Context, id string) (*market.User, error) {
+	a, err := s.Store.Account(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	return &market.User{ID: a.ID, Name: a.Username}, nil
+}
+
+func (s *Service) hash(password string) ([]byte, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), s.cost())
+	if err != nil {
+		return nil, fmt.Errorf("hashing password: %w", err)
+	}
+	return hash, nil
+}
+
+func (s *Service) cost() int {
+	if s.BcryptCost == 0 {
+		return bcrypt.DefaultCost
+	}
+	return s.BcryptCost
+}
+
+// dummyHash is a hash of some password with the service cost.
+func (s *Service) dummyHash() []byte {
+	s.dummyOnce.Do(func() {
+		s.dummy, _ = bcrypt.GenerateFromPassword([]byte("not a real password"), s.cost())
+	})
+	return s.dummy
+}
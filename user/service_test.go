@@ -0,0 +1,132 @@
+package user_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/ortymid/t2-http/jwt"
+	"github.com/ortymid/t2-http/market"
+	"github.com/ortymid/t2-http/service/mem"
+	"github.com/ortymid/t2-http/user"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var secret = []byte("secret")
+
+func newService() *user.Service {
+	return &user.Service{
+		Store:      mem.NewAccountStore(),
+		Issuer:     &jwt.Issuer{Alg: "HS256", Key: secret},
+		BcryptCost: bcrypt.MinCost,
+	}
+}
+
+func TestService_Register(t *testing.T) {
+	ctx := context.Background()
+
+	tests := []struct {
+		name     string
+		username string
+		password string
+		wantErr  error
+	}{
+		{name: "Should register a user", username: "alice", password: "password1"},
+		{name: "Should reject a taken username", username: "Bob", password: "password1", wantErr: user.ErrUsernameTaken},
+		{name: "Should reject a short password", username: "carol", password: "short", wantErr: user.ErrInvalidAccount},
+		{name: "Should reject a bad username", username: "dave smith", password: "password1", wantErr: user.ErrInvalidAccount},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newService()
+			if _, err := s.Register(ctx, "bob", "password0"); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			a, err := s.Register(ctx, tt.username, tt.password)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("Register() error = %v, want %v", err, tt.wantErr)
+				return
+			}
+			if err != nil {
+				return
+			}
+			if a.ID != "2" || a.Username != tt.username {
+				t.Errorf("Register() = %+v, want ID 2 and username %s", a, tt.username)
+			}
+			if string(a.PasswordHash) == tt.password {
+				t.Errorf("Register() stored the password in plain text")
+			}
+		})
+	}
+}
+
+func TestService_Login(t *testing.T) {
+	ctx := context.Background()
+	s := newService()
+	if _, err := s.Register(ctx, "alice", "password1"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	t.Run("Should issue a token", func(t *testing.T) {
+		token, err := s.Login(ctx, "alice", "password1")
+		if err != nil {
+			t.Fatalf("Login() unexpected error: %v", err)
+		}
+		claims, err := jwt.Parse(token, "HS256", secret)
+		if err != nil {
+			t.Fatalf("Parse() unexpected error: %v", err)
+		}
+		if claims.UserID != 1 {
+			t.Errorf("claims.UserID = %d, want 1", claims.UserID)
+		}
+	})
+
+	t.Run("Should reject a wrong password", func(t *testing.T) {
+		_, err := s.Login(ctx, "alice", "password2")
+		if !errors.Is(err, user.ErrInvalidCredentials) {
+			t.Errorf("Login() error = %v, want %v", err, user.ErrInvalidCredentials)
+		}
+	})
+
+	t.Run("Should reject an unknown user", func(t *testing.T) {
+		_, err := s.Login(ctx, "bob", "password1")
+		if !errors.Is(err, user.ErrInvalidCredentials) {
+			t.Errorf("Login() error = %v, want %v", err, user.ErrInvalidCredentials)
+		}
+	})
+}
+
+func TestService_UpdateProfile(t *testing.T) {
+	ctx := context.Background()
+	s := newService()
+	a, _ := s.Register(ctx, "alice", "password1")
+
+	_, err := s.UpdateProfile(ctx, a.ID, user.ProfileUpdate{Password: "password2"})
+	if !errors.Is(err, user.ErrInvalidAccount) {
+		t.Errorf("UpdateProfile() without the current password error = %v, want %v", err, user.ErrInvalidAccount)
+	}
+	_, err = s.UpdateProfile(ctx, a.ID, user.ProfileUpdate{Password: "password2", CurrentPassword: "password3"})
+	if !errors.Is(err, user.ErrWrongPassword) {
+		t.Errorf("UpdateProfile() with a wrong current password error = %v, want %v", err, user.ErrWrongPassword)
+	}
+
+	_, err = s.UpdateProfile(ctx, a.ID, user.ProfileUpdate{Username: "alicia", Password: "password2", CurrentPassword: "password1"})
+	if err != nil {
+		t.Fatalf("UpdateProfile() unexpected error: %v", err)
+	}
+
+	if _, err := s.Login(ctx, "alicia", "password2"); err != nil {
+		t.Errorf("Login() with the new credentials error = %v", err)
+	}
+
+	u, err := s.User(ctx, a.ID)
+	if err != nil || u.Name != "alicia" {
+		t.Errorf("User() = %v, %v, want alicia", u, err)
+	}
+
+	_, err = s.User(ctx, "42")
+	if !errors.Is(err, &market.ErrUserNotFound{}) {
+		t.Errorf("User() error = %v, want %v", err, &market.ErrUserNotFound{})
+	}
+}
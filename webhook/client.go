@@ -0,0 +1,41 @@
+package webhook
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"syscall"
+	"time"
+)
+
+// newClient creates the delivery client. It does not follow the redirects
+// and refuses to dial the addresses internal reports, so neither a redirect
+// nor a host resolving differently after the subscription was checked
+// reaches the services behind the firewall.
+func newClient(timeout time.Duration, internal func(net.IP) bool) *http.Client {
+	dialer := &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+		Control: func(network, address string, c syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || internal(ip) {
+				return fmt.Errorf("webhook: dialing %s: %w", address, errInternalAddress)
+			}
+			return nil
+		},
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	// A proxy would dial the internal addresses instead.
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+	return &http.Client{
+		Timeout:   timeout,
+		Transport: transport,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
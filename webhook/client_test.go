@@ -0,0 +1,29 @@
+package webhook
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestNewClient_redirect(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "http://169.254.169.254/latest/meta-data/", http.StatusFound)
+	}))
+	defer srv.Close()
+
+	// Only the loopback receiver is let through.
+	c := newClient(time.Second, func(ip net.IP) bool {
+		return !ip.IsLoopback() && internalIP(ip)
+	})
+	resp, err := c.Post(srv.URL, "application/json", nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusFound {
+		t.Errorf("Status = %d, want the redirect not followed", resp.StatusCode)
+	}
+}
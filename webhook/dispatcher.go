@@ -0,0 +1,216 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/ortymid/t2-http/logging"
+	"github.com/ortymid/t2-http/market"
+)
+
+// Dispatcher delivers market events to the matching subscriptions.
+// Failed deliveries are retried with exponential backoff and end up
+// in the dead-letter list after MaxAttempts.
+type Dispatcher struct {
+	Store  Store
+	Client *http.Client
+
+	MaxAttempts    int
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+
+	// ctx is canceled on Close to abandon the deliveries.
+	// The store writes do not use it, so they outlive Close.
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// NewDispatcher creates a dispatcher with reasonable defaults.
+func NewDispatcher(store Store) *Dispatcher {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Dispatcher{
+		Store:          store,
+		Client:         newClient(10*time.Second, internalIP),
+		MaxAttempts:    5,
+		InitialBackoff: time.Second,
+		MaxBackoff:     time.Minute,
+		ctx:            ctx,
+		cancel:         cancel,
+	}
+}
+
+// Handle starts the delivery of the event to every matching subscription.
+// It does not wait for the deliveries, so it may be subscribed to the event bus directly.
+func (d *Dispatcher) Handle(e market.Event) {
+	body, err := json.Marshal(newPayload(e))
+	if err != nil {
+		err = fmt.Errorf("webhook: encoding event %s: %w", e.Metadata().ID, err)
+		logging.Default().Error("webhook dispatch failed", "error", err)
+		return
+	}
+
+	subs, err := d.Store.Subscriptions(d.ctx)
+	if err != nil {
+		err = fmt.Errorf("webhook: subscriptions: %w", err)
+		logging.Default().Error("webhook dispatch failed", "error", err)
+		return
+	}
+
+	for _, s := range subs {
+		if !s.Matches(e.EventType()) {
+			continue
+		}
+		d.wg.Add(1)
+		go func(s *Subscription) {
+			defer d.wg.Done()
+			d.deliver(s, e, body)
+		}(s)
+	}
+}
+
+// Wait blocks until all the started deliveries either succeed or are dead-lettered.
+func (d *Dispatcher) Wait() {
+	d.wg.Wait()
+}
+
+// Close abandons the pending deliveries and waits for them to return.
+// The abandoned deliveries are dead-lettered with the attempts made so far.
+func (d *Dispatcher) Close() {
+	d.cancel()
+	d.wg.Wait()
+}
+
+// deliver sends the event to the subscriber retrying until it succeeds,
+// runs out of attempts or the dispatcher is closed.
+func (d *Dispatcher) deliver(s *Subscription, e market.Event, body []byte) {
+	backoff := d.InitialBackoff
+	var last *Delivery
+	attempts := 0
+retry:
+	for attempts < d.MaxAttempts {
+		attempts++
+		last = d.attempt(s, e, body, attempts)
+		if err := d.Store.AddDelivery(context.Background(), last); err != nil {
+			err = fmt.Errorf("webhook: logging delivery: %w", err)
+			logging.Default().Error("webhook dispatch failed", "error", err)
+		}
+		if last.Succeeded() {
+			return
+		}
+		if attempts == d.MaxAttempts {
+			break
+		}
+
+		select {
+		case <-time.After(backoff):
+		case <-d.ctx.Done():
+			break retry
+		}
+		backoff *= 2
+		if backoff > d.MaxBackoff {
+			backoff = d.MaxBackoff
+		}
+	}
+
+	dl := &DeadLetter{
+		SubscriptionID: s.ID,
+		UserID:         s.UserID,
+		EventID:        e.Metadata().ID,
+		EventType:      e.EventType(),
+		Payload:        body,
+		Attempts:       attempts,
+		LastError:      last.failure(),
+		FailedAt:       time.Now().UTC(),
+	}
+	if err := d.Store.AddDeadLetter(context.Background(), dl); err != nil {
+		err = fmt.Errorf("webhook: adding dead letter: %w", err)
+		logging.Default().Error("webhook dispatch failed", "error", err)
+	}
+}
+
+// attempt makes a single signed delivery request.
+func (d *Dispatcher) attempt(s *Subscription, e market.Event, body []byte, attempt int) *Delivery {
+	start := time.Now()
+	delivery := &Delivery{
+		SubscriptionID: s.ID,
+		EventID:        e.Metadata().ID,
+		EventType:      e.EventType(),
+		Attempt:        attempt,
+		At:             start.UTC(),
+	}
+
+	req, err := http.NewRequestWithContext(d.ctx, http.MethodPost, s.URL, bytes.NewReader(body))
+	if err != nil {
+		delivery.Error = err.Error()
+		return delivery
+	}
+	ts := start.Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(HeaderEventID, e.Metadata().ID)
+	req.Header.Set(HeaderEventType, e.EventType())
+	req.Header.Set(HeaderTimestamp, strconv.FormatInt(ts, 10))
+	req.Header.Set(HeaderSignature, Sign(s.Secret, ts, body))
+
+	resp, err := d.Client.Do(req)
+	delivery.Duration = time.Since(start)
+	if err != nil {
+		delivery.Error = err.Error()
+		return delivery
+	}
+	_, _ = io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+
+	delivery.StatusCode = resp.StatusCode
+	return delivery
+}
+
+func (d *Delivery) failure() string {
+	if d.Error != "" {
+		return d.Error
+	}
+	return fmt.Sprintf("unexpected status %d", d.StatusCode)
+}
+
+// payload is the JSON body of a delivery.
+type payload struct {
+	ID         string         `json:"id"`
+	Type       string         `json:"type"`
+	OccurredAt time.Time      `json:"occurred_at"`
+	Data       payloadProduct `json:"data"`
+}
+
+type payloadProduct struct {
+	ID     int    `json:"id"`
+	Name   string `json:"name"`
+	Price  int    `json:"price"`
+	Seller string `json:"seller"`
+}
+
+func newPayload(e market.Event) payload {
+	var p market.Product
+	switch e := e.(type) {
+	case market.ProductAdded:
+		p = e.Product
+	case market.ProductReplaced:
+		p = e.Product
+	case market.ProductDeleted:
+		p = e.Product
+	}
+
+	meta := e.Metadata()
+	return payload{
+		ID:         meta.ID,
+		Type:       e.EventType(),
+		OccurredAt: meta.OccurredAt,
+		Data:       payloadProduct(p),
+	}
+}
@@ -0,0 +1,196 @@
+package webhook_test
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ortymid/t2-http/market"
+	"github.com/ortymid/t2-http/service/mem"
+	"github.com/ortymid/t2-http/webhook"
+)
+
+// receiver is a webhook endpoint failing the first failures requests.
+type receiver struct {
+	mu       sync.Mutex
+	failures int
+	secret   string
+	bodies   [][]byte
+	badSig   int
+}
+
+func (rc *receiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	body, _ := ioutil.ReadAll(r.Body)
+	if !webhook.Verify(rc.secret, r.Header.Get(webhook.HeaderTimestamp), r.Header.Get(webhook.HeaderSignature), body) {
+		rc.badSig++
+	}
+	rc.bodies = append(rc.bodies, body)
+
+	if rc.failures > 0 {
+		rc.failures--
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+func TestDispatcher_Handle(t *testing.T) {
+	ctx := context.Background()
+	event := market.ProductAdded{
+		Meta:    market.EventMeta{ID: "e1", OccurredAt: time.Now()},
+		Product: market.Product{ID: 1, Name: "p1", Price: 100, Seller: "1"},
+	}
+
+	tests := []struct {
+		name            string
+		failures        int
+		events          []string
+		wantDeliveries  int
+		wantDeadLetters int
+	}{
+		{
+			name:           "Should deliver a signed event",
+			wantDeliveries: 1,
+		},
+		{
+			name:           "Should retry failed deliveries",
+			failures:       2,
+			wantDeliveries: 3,
+		},
+		{
+			name:            "Should dead-letter after all attempts",
+			failures:        10,
+			wantDeliveries:  3,
+			wantDeadLetters: 1,
+		},
+		{
+			name:   "Should skip not subscribed events",
+			events: []string{market.EventProductDeleted},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rc := &receiver{failures: tt.failures, secret: "secret"}
+			srv := httptest.NewServer(rc)
+			defer srv.Close()
+
+			store := mem.NewWebhookStore()
+			sub, _ := store.AddSubscription(ctx, &webhook.Subscription{
+				UserID: "1",
+				URL:    srv.URL,
+				Secret: rc.secret,
+				Events: tt.events,
+			})
+
+			d := webhook.NewDispatcher(store)
+			// The default client refuses the loopback receiver.
+			d.Client = srv.Client()
+			d.MaxAttempts = 3
+			d.InitialBackoff = time.Millisecond
+			d.MaxBackoff = time.Millisecond
+
+			d.Handle(event)
+			d.Wait()
+			d.Close()
+
+			ds, _ := store.Deliveries(ctx, sub.ID)
+			if len(ds) != tt.wantDeliveries {
+				t.Errorf("got %d deliveries, want %d", len(ds), tt.wantDeliveries)
+			}
+			dls, _ := store.DeadLetters(ctx)
+			if len(dls) != tt.wantDeadLetters {
+				t.Errorf("got %d dead letters, want %d", len(dls), tt.wantDeadLetters)
+			}
+			if rc.badSig != 0 {
+				t.Errorf("got %d deliveries with a bad signature", rc.badSig)
+			}
+
+			for _, body := range rc.bodies {
+				var payload struct {
+					ID   string `json:"id"`
+					Type string `json:"type"`
+					Data struct {
+						ID int `json:"id"`
+					} `json:"data"`
+				}
+				if err := json.Unmarshal(body, &payload); err != nil {
+					t.Errorf("unexpected error: %v", err)
+					continue
+				}
+				if payload.ID != "e1" || payload.Type != market.EventProductAdded || payload.Data.ID != 1 {
+					t.Errorf("unexpected payload %s", body)
+				}
+			}
+		})
+	}
+}
+
+func TestDispatcher_Close(t *testing.T) {
+	ctx := context.Background()
+	rc := &receiver{failures: 10, secret: "secret"}
+	srv := httptest.NewServer(rc)
+	defer srv.Close()
+
+	store := mem.NewWebhookStore()
+	sub, _ := store.AddSubscription(ctx, &webhook.Subscription{UserID: "1", URL: srv.URL, Secret: rc.secret})
+
+	d := webhook.NewDispatcher(store)
+	d.Client = srv.Client()
+	d.InitialBackoff = time.Hour
+	d.Handle(market.ProductAdded{
+		Meta:    market.EventMeta{ID: "e1", OccurredAt: time.Now()},
+		Product: market.Product{ID: 1, Name: "p1", Price: 100, Seller: "1"},
+	})
+	for i := 0; i < 100; i++ {
+		if ds, _ := store.Deliveries(ctx, sub.ID); len(ds) > 0 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	d.Close()
+
+	dls, _ := store.DeadLetters(ctx)
+	if len(dls) != 1 {
+		t.Fatalf("got %d dead letters, want 1", len(dls))
+	}
+	if dls[0].EventID != "e1" || dls[0].Attempts != 1 {
+		t.Errorf("dead letter = %+v, want event e1 after 1 attempt", dls[0])
+	}
+}
+
+func TestDispatcher_internalAddress(t *testing.T) {
+	ctx := context.Background()
+	rc := &receiver{secret: "secret"}
+	srv := httptest.NewServer(rc)
+	defer srv.Close()
+
+	// The subscription passed the check, but the host is internal now.
+	store := mem.NewWebhookStore()
+	sub, _ := store.AddSubscription(ctx, &webhook.Subscription{UserID: "1", URL: srv.URL, Secret: rc.secret})
+
+	d := webhook.NewDispatcher(store)
+	d.MaxAttempts = 1
+	d.Handle(market.ProductAdded{
+		Meta:    market.EventMeta{ID: "e1", OccurredAt: time.Now()},
+		Product: market.Product{ID: 1, Name: "p1", Price: 100, Seller: "1"},
+	})
+	d.Wait()
+	d.Close()
+
+	if len(rc.bodies) != 0 {
+		t.Errorf("the internal receiver got %d deliveries, want 0", len(rc.bodies))
+	}
+	ds, _ := store.Deliveries(ctx, sub.ID)
+	if len(ds) != 1 || !strings.Contains(ds[0].Error, "internal address") {
+		t.Errorf("deliveries = %+v, want one refused as internal", ds)
+	}
+}
@@ -0,0 +1,168 @@
+package webhook
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"time"
+
+	"github.com/ortymid/t2-http/market"
+)
+
+// Interface may be used by protocol layers for RPC or mocking.
+type Interface interface {
+	Subscriptions(ctx context.Context, userID string) ([]*Subscription, error)
+	Subscription(ctx context.Context, id int, userID string) (*Subscription, error)
+	AddSubscription(ctx context.Context, s *Subscription, userID string) (*Subscription, error)
+	ReplaceSubscription(ctx context.Context, s *Subscription, userID string) (*Subscription, error)
+	DeleteSubscription(ctx context.Context, id int, userID string) error
+	Deliveries(ctx context.Context, id int, userID string) ([]*Delivery, error)
+	DeadLetters(ctx context.Context, userID string) ([]*DeadLetter, error)
+}
+
+// Manager lets users manage their own webhook subscriptions.
+type Manager struct {
+	Store Store
+	// LookupIP resolves the hosts of the subscription URLs,
+	// net.DefaultResolver.LookupIPAddr if nil.
+	LookupIP func(ctx context.Context, host string) ([]net.IPAddr, error)
+}
+
+// Subscriptions returns all subscriptions of the user.
+func (m *Manager) Subscriptions(ctx context.Context, userID string) ([]*Subscription, error) {
+	all, err := m.Store.Subscriptions(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("subscriptions: %w", err)
+	}
+
+	subs := make([]*Subscription, 0)
+	for _, s := range all {
+		if s.UserID == userID {
+			subs = append(subs, s)
+		}
+	}
+	return subs, nil
+}
+
+// Subscription finds the user's subscription by its ID.
+func (m *Manager) Subscription(ctx context.Context, id int, userID string) (*Subscription, error) {
+	s, err := m.owned(ctx, id, userID)
+	if err != nil {
+		return nil, fmt.Errorf("subscription: %w", err)
+	}
+	return s, nil
+}
+
+// AddSubscription creates a subscription for the user.
+// A secret is generated when the subscription does not have one.
+func (m *Manager) AddSubscription(ctx context.Context, s *Subscription, userID string) (*Subscription, error) {
+	if err := m.validateURL(ctx, s.URL); err != nil {
+		return nil, fmt.Errorf("add subscription: %w", err)
+	}
+
+	s.UserID = userID
+	if s.Secret == "" {
+		s.Secret = newSecret()
+	}
+	s.CreatedAt = time.Now().UTC()
+
+	s, err := m.Store.AddSubscription(ctx, s)
+	if err != nil {
+		return nil, fmt.Errorf("add subscription: %w", err)
+	}
+	return s, nil
+}
+
+// ReplaceSubscription updates the URL and the events of the user's subscription.
+// The secret is kept unless the new one is given.
+func (m *Manager) ReplaceSubscription(ctx context.Context, s *Subscription, userID string) (*Subscription, error) {
+	if err := m.validateURL(ctx, s.URL); err != nil {
+		return nil, fmt.Errorf("edit subscription: %w", err)
+	}
+
+	old, err := m.owned(ctx, s.ID, userID)
+	if err != nil {
+		return nil, fmt.Errorf("edit subscription: %w", err)
+	}
+
+	s.UserID = old.UserID
+	s.CreatedAt = old.CreatedAt
+	if s.Secret == "" {
+		s.Secret = old.Secret
+	}
+
+	s, err = m.Store.ReplaceSubscription(ctx, s)
+	if err != nil {
+		return nil, fmt.Errorf("edit subscription: %w", err)
+	}
+	return s, nil
+}
+
+// DeleteSubscription removes the user's subscription.
+func (m *Manager) DeleteSubscription(ctx context.Context, id int, userID string) error {
+	if _, err := m.owned(ctx, id, userID); err != nil {
+		return fmt.Errorf("delete subscription: %w", err)
+	}
+
+	if err := m.Store.DeleteSubscription(ctx, id); err != nil {
+		return fmt.Errorf("delete subscription: %w", err)
+	}
+	return nil
+}
+
+// Deliveries returns the delivery log of the user's subscription.
+func (m *Manager) Deliveries(ctx context.Context, id int, userID string) ([]*Delivery, error) {
+	if _, err := m.owned(ctx, id, userID); err != nil {
+		return nil, fmt.Errorf("deliveries: %w", err)
+	}
+
+	ds, err := m.Store.Deliveries(ctx, id)
+	if err != nil {
+		return nil, fmt.Errorf("deliveries: %w", err)
+	}
+	return ds, nil
+}
+
+// DeadLetters returns the events that were not delivered to the user's subscriptions.
+func (m *Manager) DeadLetters(ctx context.Context, userID string) ([]*DeadLetter, error) {
+	all, err := m.Store.DeadLetters(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("dead letters: %w", err)
+	}
+
+	dls := make([]*DeadLetter, 0)
+	for _, dl := range all {
+		if dl.UserID == userID {
+			dls = append(dls, dl)
+		}
+	}
+	return dls, nil
+}
+
+// validateURL checks the subscription URL is a valid HTTP URL
+// of a host outside the internal networks.
+func (m *Manager) validateURL(ctx context.Context, rawURL string) error {
+	if err := validateURL(rawURL); err != nil {
+		return err
+	}
+	lookup := m.LookupIP
+	if lookup == nil {
+		lookup = net.DefaultResolver.LookupIPAddr
+	}
+	u, _ := url.Parse(rawURL)
+	return checkHost(ctx, u.Hostname(), lookup)
+}
+
+// owned finds the subscription checking that it belongs to the user.
+func (m *Manager) owned(ctx context.Context, id int, userID string) (*Subscription, error) {
+	s, err := m.Store.Subscription(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if s.UserID != userID {
+		return nil, &market.ErrPermission{Reason: errors.New("subscription belongs to another user")}
+	}
+	return s, nil
+}
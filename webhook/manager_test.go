@@ -0,0 +1,57 @@
+package webhook_test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/ortymid/t2-http/service/mem"
+	"github.com/ortymid/t2-http/webhook"
+)
+
+func TestManager_AddSubscription(t *testing.T) {
+	hosts := map[string][]net.IPAddr{
+		"hooks.example.com":    {{IP: net.ParseIP("203.0.113.10")}},
+		"internal.example.com": {{IP: net.ParseIP("203.0.113.10")}, {IP: net.ParseIP("10.1.2.3")}},
+	}
+	m := &webhook.Manager{
+		Store: mem.NewWebhookStore(),
+		LookupIP: func(ctx context.Context, host string) ([]net.IPAddr, error) {
+			addrs, ok := hosts[host]
+			if !ok {
+				return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+			}
+			return addrs, nil
+		},
+	}
+
+	tests := []struct {
+		name    string
+		url     string
+		wantErr bool
+	}{
+		{name: "Should accept a public host", url: "https://hooks.example.com/market"},
+		{name: "Should accept a public address", url: "http://203.0.113.10:8080/market"},
+		{name: "Should reject another scheme", url: "ftp://hooks.example.com/", wantErr: true},
+		{name: "Should reject localhost", url: "http://localhost:8080/", wantErr: true},
+		{name: "Should reject a loopback address", url: "http://127.0.0.1/", wantErr: true},
+		{name: "Should reject an IPv6 loopback address", url: "http://[::1]/", wantErr: true},
+		{name: "Should reject a private address", url: "http://192.168.1.10/", wantErr: true},
+		{name: "Should reject a link-local address", url: "http://169.254.169.254/latest/meta-data/", wantErr: true},
+		{name: "Should reject the unspecified address", url: "http://0.0.0.0/", wantErr: true},
+		{name: "Should reject a host resolving to a private address", url: "https://internal.example.com/", wantErr: true},
+		{name: "Should reject an unknown host", url: "https://unknown.example.com/", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := m.AddSubscription(context.Background(), &webhook.Subscription{URL: tt.url}, "1")
+			if tt.wantErr && !errors.Is(err, webhook.ErrInvalidSubscription) {
+				t.Errorf("AddSubscription() error = %v, want %v", err, webhook.ErrInvalidSubscription)
+			}
+			if !tt.wantErr && err != nil {
+				t.Errorf("AddSubscription() unexpected error: %v", err)
+			}
+		})
+	}
+}
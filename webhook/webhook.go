@@ -0,0 +1,206 @@
+// Package webhook notifies external subscribers about market events over HTTP.
+package webhook
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	ErrSubscriptionNotFound = errors.New("webhook subscription not found")
+	ErrInvalidSubscription  = errors.New("invalid webhook subscription")
+
+	errInternalAddress = errors.New("internal address")
+)
+
+// Subscription is a user's request to receive events at the URL.
+type Subscription struct {
+	ID     int
+	UserID string
+	URL    string
+	// Secret is the HMAC key used to sign the deliveries.
+	Secret string
+	// Events limits the delivered event types. Empty means all events.
+	Events    []string
+	CreatedAt time.Time
+}
+
+// Matches reports whether the event type is wanted by the subscription.
+func (s *Subscription) Matches(eventType string) bool {
+	if len(s.Events) == 0 {
+		return true
+	}
+	for _, typ := range s.Events {
+		if typ == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+// Delivery is a record of a single delivery attempt.
+type Delivery struct {
+	ID             int
+	SubscriptionID int
+	EventID        string
+	EventType      string
+	Attempt        int
+	StatusCode     int
+	Error          string
+	Duration       time.Duration
+	At             time.Time
+}
+
+// Succeeded reports whether the receiver accepted the delivery.
+func (d *Delivery) Succeeded() bool {
+	return d.Error == "" && d.StatusCode >= 200 && d.StatusCode < 300
+}
+
+// DeadLetter is an event that could not be delivered after all attempts.
+type DeadLetter struct {
+	ID             int
+	SubscriptionID int
+	UserID         string
+	EventID        string
+	EventType      string
+	Payload        []byte
+	Attempts       int
+	LastError      string
+	FailedAt       time.Time
+}
+
+// Store represents a webhook data backend.
+type Store interface {
+	Subscriptions(ctx context.Context) ([]*Subscription, error)
+	Subscription(ctx context.Context, id int) (*Subscription, error)
+	AddSubscription(context.Context, *Subscription) (*Subscription, error)
+	ReplaceSubscription(context.Context, *Subscription) (*Subscription, error)
+	DeleteSubscription(ctx context.Context, id int) error
+
+	AddDelivery(context.Context, *Delivery) error
+	Deliveries(ctx context.Context, subscriptionID int) ([]*Delivery, error)
+
+	AddDeadLetter(context.Context, *DeadLetter) error
+	DeadLetters(ctx context.Context) ([]*DeadLetter, error)
+}
+
+// Headers set on every delivery request.
+const (
+	HeaderEventID   = "X-Webhook-ID"
+	HeaderEventType = "X-Webhook-Event"
+	HeaderTimestamp = "X-Webhook-Timestamp"
+	HeaderSignature = "X-Webhook-Signature"
+)
+
+// Sign computes the signature of the delivery body sent at the timestamp.
+// The signed message is "<timestamp>.<body>", the result is "sha256=<hex HMAC>".
+func Sign(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify checks the signature header values of a delivery.
+// Receivers may use it to authenticate the deliveries.
+func Verify(secret string, timestamp string, signature string, body []byte) bool {
+	ts, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return false
+	}
+	want := Sign(secret, ts, body)
+	return hmac.Equal([]byte(want), []byte(signature))
+}
+
+func newSecret() string {
+	b := make([]byte, 32)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func validateURL(rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return fmt.Errorf("%w: url: %v", ErrInvalidSubscription, err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("%w: url scheme must be http or https", ErrInvalidSubscription)
+	}
+	if u.Host == "" {
+		return fmt.Errorf("%w: url host required", ErrInvalidSubscription)
+	}
+	return nil
+}
+
+// internalNetworks are the private and shared address ranges. Together
+// with the loopback, link-local, multicast and unspecified addresses
+// they are not reachable from the internet.
+var internalNetworks = []*net.IPNet{
+	mustParseCIDR("0.0.0.0/8"),
+	mustParseCIDR("10.0.0.0/8"),
+	mustParseCIDR("100.64.0.0/10"),
+	mustParseCIDR("172.16.0.0/12"),
+	mustParseCIDR("192.168.0.0/16"),
+	mustParseCIDR("fc00::/7"),
+}
+
+func mustParseCIDR(s string) *net.IPNet {
+	_, n, err := net.ParseCIDR(s)
+	if err != nil {
+		panic(err)
+	}
+	return n
+}
+
+// internalIP reports whether the address is not reachable from the internet.
+func internalIP(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsUnspecified() || ip.IsLinkLocalUnicast() ||
+		ip.IsMulticast() || ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() {
+		return true
+	}
+	for _, n := range internalNetworks {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkHost rejects the hosts that are or resolve to internal addresses,
+// so the deliveries cannot reach the services behind the firewall.
+func checkHost(ctx context.Context, host string, lookup func(context.Context, string) ([]net.IPAddr, error)) error {
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return fmt.Errorf("%w: url host must not be internal", ErrInvalidSubscription)
+	}
+
+	var ips []net.IP
+	if ip := net.ParseIP(host); ip != nil {
+		ips = append(ips, ip)
+	} else {
+		addrs, err := lookup(ctx, host)
+		if err != nil {
+			return fmt.Errorf("%w: url host: %v", ErrInvalidSubscription, err)
+		}
+		for _, a := range addrs {
+			ips = append(ips, a.IP)
+		}
+	}
+	for _, ip := range ips {
+		if internalIP(ip) {
+			return fmt.Errorf("%w: url host must not be internal", ErrInvalidSubscription)
+		}
+	}
+	return nil
+}